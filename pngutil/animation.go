@@ -0,0 +1,507 @@
+package pngutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+func init() {
+	for typ, newValue := range map[string]func() ChunkUnmarshaler{
+		"acTL": func() ChunkUnmarshaler { return new(AnimationControl) },
+		"fcTL": func() ChunkUnmarshaler { return new(FrameControl) },
+	} {
+		if err := RegisterChunk(typ, CodecHandler(newValue), false); err != nil {
+			panic(err)
+		}
+	}
+}
+
+/*
+AnimationControl represents an acTL chunk, which marks a PNG as
+animated and gives its number of frames and the number of times
+to play them, zero meaning indefinitely.
+*/
+type AnimationControl struct {
+	Frames uint32
+	Plays  uint32
+}
+
+func (ac AnimationControl) ChunkType() string {
+	return "acTL"
+}
+
+func (ac AnimationControl) MarshalChunk() ([]byte, error) {
+	if ac.Frames == 0 {
+		return nil, fmt.Errorf("pngutil: acTL chunk has no frames")
+	}
+	data := make([]byte, 0, 8)
+	data = binary.BigEndian.AppendUint32(data, ac.Frames)
+	return binary.BigEndian.AppendUint32(data, ac.Plays), nil
+}
+
+func (ac *AnimationControl) UnmarshalChunk(data []byte) error {
+	if len(data) != 8 {
+		return fmt.Errorf("pngutil: acTL chunk has length %d, want 8", len(data))
+	}
+	ac.Frames = binary.BigEndian.Uint32(data[0:4])
+	ac.Plays = binary.BigEndian.Uint32(data[4:8])
+	return nil
+}
+
+/*
+FrameControl represents an fcTL chunk, which gives the region of
+the canvas a frame of an animated PNG covers, how long it's
+shown for, and how it's composited. The delay is DelayNum /
+DelayDen seconds, a DelayDen of zero meaning hundredths.
+*/
+type FrameControl struct {
+	Sequence      uint32
+	Width, Height uint32
+	X, Y          uint32
+	DelayNum      uint16
+	DelayDen      uint16
+	Dispose       uint8 // DisposeNone, DisposeBackground or DisposePrevious
+	Blend         uint8 // BlendSource or BlendOver
+}
+
+func (fc FrameControl) ChunkType() string {
+	return "fcTL"
+}
+
+func (fc FrameControl) MarshalChunk() ([]byte, error) {
+	if fc.Width == 0 || fc.Height == 0 {
+		return nil, fmt.Errorf("pngutil: fcTL chunk has empty frame %dx%d", fc.Width, fc.Height)
+	}
+	if fc.Dispose > DisposePrevious || fc.Blend > BlendOver {
+		return nil, fmt.Errorf("pngutil: fcTL chunk has invalid dispose %d or blend %d", fc.Dispose, fc.Blend)
+	}
+	data := make([]byte, 0, 26)
+	for _, v := range [5]uint32{fc.Sequence, fc.Width, fc.Height, fc.X, fc.Y} {
+		data = binary.BigEndian.AppendUint32(data, v)
+	}
+	data = binary.BigEndian.AppendUint16(data, fc.DelayNum)
+	data = binary.BigEndian.AppendUint16(data, fc.DelayDen)
+	return append(data, fc.Dispose, fc.Blend), nil
+}
+
+func (fc *FrameControl) UnmarshalChunk(data []byte) error {
+	if len(data) != 26 {
+		return fmt.Errorf("pngutil: fcTL chunk has length %d, want 26", len(data))
+	}
+	*fc = FrameControl{
+		Sequence: binary.BigEndian.Uint32(data[0:4]),
+		Width:    binary.BigEndian.Uint32(data[4:8]),
+		Height:   binary.BigEndian.Uint32(data[8:12]),
+		X:        binary.BigEndian.Uint32(data[12:16]),
+		Y:        binary.BigEndian.Uint32(data[16:20]),
+		DelayNum: binary.BigEndian.Uint16(data[20:22]),
+		DelayDen: binary.BigEndian.Uint16(data[22:24]),
+		Dispose:  data[24],
+		Blend:    data[25],
+	}
+	return nil
+}
+
+/*
+Delay returns how long the frame is shown for. A DelayDen of
+zero is taken to mean hundredths of a second, as the spec
+requires.
+*/
+func (fc FrameControl) Delay() time.Duration {
+	den := time.Duration(fc.DelayDen)
+	if den == 0 {
+		den = 100
+	}
+	return time.Duration(fc.DelayNum) * time.Second / den
+}
+
+/*
+Animation summarises an animated PNG. Frames is the number of
+frames declared by acTL while Delays holds the delay of each
+frame actually present, so the two disagree only if the file is
+malformed. Duration is the sum of Delays, that is the length of
+a single play.
+*/
+type Animation struct {
+	Width, Height uint32 // size of the canvas
+	Frames        uint32
+	Plays         uint32 // times to play the animation, zero meaning indefinitely
+	Delays        []time.Duration
+	Duration      time.Duration
+}
+
+/*
+AnimationInfo returns a summary of the animated PNG rs, reading
+only IHDR and the animation control chunks, so it's cheap even
+for long animations. An error wrapping ErrNoChunk is returned if
+rs isn't animated. The offset of rs is left unspecified.
+*/
+func AnimationInfo(rs io.ReadSeeker) (*Animation, error) {
+
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return nil, err
+	}
+	ihdr, err := readIHDR(rs, idx)
+	if err != nil {
+		return nil, err
+	}
+
+	anim := &Animation{Width: ihdr.Width, Height: ihdr.Height}
+	animated, seenIDAT := false, false
+	for _, h := range idx {
+		switch h.typ {
+		case "IDAT":
+			seenIDAT = true
+		case "acTL":
+			if seenIDAT || animated {
+				continue
+			}
+			data, err := readChunkData(rs, h)
+			if err != nil {
+				return nil, err
+			}
+			var ac AnimationControl
+			if err = ac.UnmarshalChunk(data); err != nil {
+				return nil, &ChunkError{Type: h.typ, Offset: h.offset, Err: err}
+			}
+			anim.Frames, anim.Plays = ac.Frames, ac.Plays
+			animated = true
+		case "fcTL":
+			var fc FrameControl
+			if err = getControl(rs, h, &fc); err != nil {
+				return nil, err
+			}
+			anim.Delays = append(anim.Delays, fc.Delay())
+			anim.Duration += fc.Delay()
+		}
+	}
+	if !animated {
+		return nil, fmt.Errorf("%w: no acTL chunk before image data, so not an animated PNG", ErrNoChunk)
+	}
+	return anim, nil
+}
+
+/*
+Frame is a single frame of an animated PNG. Image is a
+standalone PNG holding the frame's region of the canvas, which
+Control locates.
+*/
+type Frame struct {
+	Control FrameControl
+	Image   io.ReadSeeker
+}
+
+/*
+Frames splits the animated PNG in rs into its frames, in order.
+Each frame's image has an IHDR chunk sized to the frame, the
+chunks which precede the image data in rs, such as PLTE, tRNS
+and gAMA, and the frame's data as IDAT chunks. The default image
+is only included if it's the first frame of the animation.
+
+Frames are not composited; apply each Control's dispose and
+blend operations to render the animation. The images read from
+rs, so it shouldn't be altered until they've been drained.
+*/
+func Frames(rs io.ReadSeeker) ([]Frame, error) {
+
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return nil, err
+	}
+	ihdr, err := readIHDR(rs, idx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group the chunks: those shared by every frame, then each frame's.
+	type frameChunks struct {
+		control FrameControl
+		data    []chunkHeader
+	}
+	var shared []chunkHeader
+	var frames []*frameChunks
+	animated, seenIDAT, idatIsFrame := false, false, false
+	for _, h := range idx[1 : len(idx)-1] {
+		switch h.typ {
+		case "acTL":
+			animated = !seenIDAT
+		case "fcTL":
+			fc := new(frameChunks)
+			if err = getControl(rs, h, &fc.control); err != nil {
+				return nil, err
+			}
+			c := fc.control
+			if uint64(c.X)+uint64(c.Width) > uint64(ihdr.Width) || uint64(c.Y)+uint64(c.Height) > uint64(ihdr.Height) {
+				return nil, &ChunkError{Type: h.typ, Offset: h.offset, Err: fmt.Errorf("frame lies outside the %dx%d canvas", ihdr.Width, ihdr.Height)}
+			}
+			frames = append(frames, fc)
+		case "IDAT":
+			// The default image is a frame only if an fcTL precedes it.
+			if !seenIDAT {
+				idatIsFrame = len(frames) == 1
+			}
+			if idatIsFrame {
+				frames[0].data = append(frames[0].data, h)
+			}
+			seenIDAT = true
+		case "fdAT":
+			if len(frames) == 0 || !seenIDAT {
+				return nil, &ChunkError{Type: h.typ, Offset: h.offset, Err: fmt.Errorf("frame data has no fcTL chunk")}
+			}
+			if h.length < 4 {
+				return nil, &ChunkError{Type: h.typ, Offset: h.offset, Err: fmt.Errorf("fdAT chunk has no sequence number")}
+			}
+			last := frames[len(frames)-1]
+			last.data = append(last.data, h)
+		default:
+			if !seenIDAT {
+				shared = append(shared, h)
+			}
+		}
+	}
+	if !animated {
+		return nil, fmt.Errorf("%w: no acTL chunk before image data, so not an animated PNG", ErrNoChunk)
+	}
+
+	ihdrData, err := readChunkData(rs, idx[0])
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Frame, 0, len(frames))
+	for _, fc := range frames {
+		if len(fc.data) == 0 {
+			return nil, fmt.Errorf("pngutil: frame %d has no image data", fc.control.Sequence)
+		}
+		a := newAssembler(rs, len(shared)+2*len(fc.data)+4)
+		a.write("header", header)
+		binary.BigEndian.PutUint32(ihdrData[0:4], fc.control.Width)
+		binary.BigEndian.PutUint32(ihdrData[4:8], fc.control.Height)
+		a.write("IHDR", AppendChunk(nil, "IHDR", ihdrData))
+		for _, h := range shared {
+			a.copyChunk(h)
+		}
+		for _, h := range fc.data {
+			if h.typ == "IDAT" {
+				a.copyChunk(h)
+				continue
+			}
+			// fdAT becomes IDAT without its sequence number.
+			crc := crc32.NewIEEE()
+			crc.Write([]byte("IDAT"))
+			if err = hashRange(crc, rs, h.dataOffset()+4, h.end()-4); err != nil {
+				return nil, err
+			}
+			a.write("IDAT", append(binary.BigEndian.AppendUint32(nil, h.length-4), "IDAT"...))
+			a.copyRange(h.dataOffset()+4, h.end()-4)
+			a.write("crc", binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+		}
+		a.write("IEND", iend)
+		mrs, err := a.finish()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Frame{Control: fc.control, Image: mrs})
+	}
+	return out, nil
+}
+
+// getControl reads the fcTL chunk located by h into fc.
+func getControl(rs io.ReadSeeker, h chunkHeader, fc *FrameControl) error {
+	data, err := readChunkData(rs, h)
+	if err != nil {
+		return err
+	}
+	if err = fc.UnmarshalChunk(data); err != nil {
+		return &ChunkError{Type: h.typ, Offset: h.offset, Err: err}
+	}
+	return nil
+}
+
+/*
+WriteAnimation writes an animated PNG to w made of frames, which
+are played plays times, zero meaning indefinitely. Each frame's
+image is a standalone PNG which is placed on the canvas at the
+X and Y of its Control; the Sequence, Width and Height of each
+Control are ignored, being taken from the frame's position and
+IHDR chunk.
+
+The first frame sets the canvas size and is the image shown by
+decoders that don't support animation, so it must cover the
+whole canvas. Its IHDR chunk and the chunks preceding its image
+data, such as PLTE and gAMA, apply to every frame. The other
+frames must have the same bit depth, colour type and interlace
+method, as well as the same palette if indexed. Only their image
+data is used; their other chunks are discarded.
+*/
+func WriteAnimation(w io.Writer, frames []Frame, plays uint32) error {
+
+	if len(frames) == 0 {
+		return errors.New("pngutil: no frames to animate")
+	}
+	cw := NewChunkWriter(w, true)
+	var canvas ImageInfo
+	var palette []byte
+	seq := uint32(0)
+
+	for i, f := range frames {
+
+		idx, err := indexPNG(f.Image, Options{})
+		if err != nil {
+			return err
+		}
+		ihdr, err := readIHDR(f.Image, idx)
+		if err != nil {
+			return err
+		}
+		var shared, data []chunkHeader
+		var plte []byte
+		for _, h := range idx[1 : len(idx)-1] {
+			switch {
+			case h.typ == "IDAT":
+				data = append(data, h)
+			case data != nil, h.typ == "acTL", h.typ == "fcTL", h.typ == "fdAT":
+			case h.typ == "PLTE":
+				if plte, err = readChunkData(f.Image, h); err != nil {
+					return err
+				}
+				fallthrough
+			default:
+				shared = append(shared, h)
+			}
+		}
+
+		fc := f.Control
+		fc.Sequence, fc.Width, fc.Height = seq, ihdr.Width, ihdr.Height
+		if i == 0 {
+			if fc.X != 0 || fc.Y != 0 {
+				return fmt.Errorf("pngutil: first frame is at %d, %d, want 0, 0", fc.X, fc.Y)
+			}
+			canvas, palette = ihdr, plte
+		} else {
+			if ihdr.BitDepth != canvas.BitDepth || ihdr.ColorType != canvas.ColorType || ihdr.Interlace != canvas.Interlace {
+				return fmt.Errorf("pngutil: frame %d has bit depth %d, colour type %d and interlace method %d, want %d, %d and %d",
+					i, ihdr.BitDepth, ihdr.ColorType, ihdr.Interlace, canvas.BitDepth, canvas.ColorType, canvas.Interlace)
+			}
+			if canvas.ColorType == ColorIndexed && !bytes.Equal(plte, palette) {
+				return fmt.Errorf("pngutil: frame %d has a different palette to the first frame", i)
+			}
+			if uint64(fc.X)+uint64(fc.Width) > uint64(canvas.Width) || uint64(fc.Y)+uint64(fc.Height) > uint64(canvas.Height) {
+				return fmt.Errorf("pngutil: frame %d of %dx%d at %d, %d lies outside the %dx%d canvas",
+					i, fc.Width, fc.Height, fc.X, fc.Y, canvas.Width, canvas.Height)
+			}
+		}
+		control, err := fc.MarshalChunk()
+		if err != nil {
+			return err
+		}
+		seq++
+
+		if i == 0 {
+			ihdrData, err := readChunkData(f.Image, idx[0])
+			if err != nil {
+				return err
+			}
+			actl, err := AnimationControl{Frames: uint32(len(frames)), Plays: plays}.MarshalChunk()
+			if err != nil {
+				return err
+			}
+			if err = cw.WriteChunk("IHDR", ihdrData); err != nil {
+				return err
+			}
+			if err = cw.WriteChunk("acTL", actl); err != nil {
+				return err
+			}
+			for _, h := range shared {
+				p, err := readChunkData(f.Image, h)
+				if err != nil {
+					return err
+				}
+				if err = cw.WriteChunk(h.typ, p); err != nil {
+					return err
+				}
+			}
+		}
+		if err = cw.WriteChunk("fcTL", control); err != nil {
+			return err
+		}
+		for _, h := range data {
+			p, err := readChunkData(f.Image, h)
+			if err != nil {
+				return err
+			}
+			typ := "IDAT"
+			if i > 0 {
+				typ = "fdAT"
+				p = append(binary.BigEndian.AppendUint32(nil, seq), p...)
+				seq++
+			}
+			if err = cw.WriteChunk(typ, p); err != nil {
+				return err
+			}
+		}
+	}
+	return cw.Close()
+}
+
+/*
+RenumberFrames returns f with the sequence numbers of its fcTL
+and fdAT chunks rewritten to count up from zero in the order the
+chunks appear, as decoders require, recomputing their CRCs. Use
+it after inserting or removing animation chunks. Chunks already
+numbered correctly, and every other chunk, are kept byte for
+byte, so f is returned unchanged if it has no animation chunks.
+
+As with ReplaceMeta, the result reads from f so f shouldn't be
+altered until it has been drained.
+*/
+func RenumberFrames(f io.ReadSeeker) (*multiReadSeeker, error) {
+
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, err
+	}
+	a := newAssembler(f, len(idx))
+	a.copyRange(0, idx[0].offset)
+	seq := uint32(0)
+	p := make([]byte, 4)
+	for _, h := range idx {
+		if h.typ != "fcTL" && h.typ != "fdAT" {
+			a.copyChunk(h)
+			continue
+		}
+		if h.length < 4 {
+			return nil, &ChunkError{Type: h.typ, Offset: h.offset, Err: errors.New("chunk has no sequence number")}
+		}
+		if _, err = f.Seek(h.dataOffset(), io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err = io.ReadFull(f, p); err != nil {
+			return nil, fmt.Errorf("pngutil: couldn't read %s chunk at offset %d: %w", h.typ, h.offset, err)
+		}
+		if binary.BigEndian.Uint32(p) == seq {
+			a.copyChunk(h)
+			seq++
+			continue
+		}
+		binary.BigEndian.PutUint32(p, seq)
+		crc := crc32.NewIEEE()
+		crc.Write([]byte(h.typ))
+		crc.Write(p)
+		if err = hashRange(crc, f, h.dataOffset()+4, h.end()-4); err != nil {
+			return nil, fmt.Errorf("pngutil: couldn't read %s chunk at offset %d: %w", h.typ, h.offset, err)
+		}
+		head := binary.BigEndian.AppendUint32(nil, h.length)
+		head = append(head, h.typ...)
+		a.write(h.typ, binary.BigEndian.AppendUint32(head, seq))
+		a.copyRange(h.dataOffset()+4, h.end()-4)
+		a.write("crc", binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+		seq++
+	}
+	return a.finish()
+}
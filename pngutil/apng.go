@@ -0,0 +1,222 @@
+package pngutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// APNG frame dispose operations, applied after a frame is displayed.
+const (
+	DisposeNone       uint8 = 0 // leave the canvas as is
+	DisposeBackground uint8 = 1 // clear the frame's region to transparent black
+	DisposePrevious   uint8 = 2 // revert the frame's region to its prior contents
+)
+
+// APNG frame blend operations.
+const (
+	BlendSource uint8 = 0 // the frame replaces its region of the canvas
+	BlendOver   uint8 = 1 // the frame is alpha composited over its region
+)
+
+/*
+FrameDelta is an animation frame reduced to the region of the
+canvas that it changes, along with the dispose and blend
+operations that reproduce the original frame.
+*/
+type FrameDelta struct {
+	Image   *image.NRGBA // the changed region, with bounds starting at 0, 0
+	X, Y    uint32       // position of the region on the canvas
+	Dispose uint8
+	Blend   uint8
+}
+
+/*
+OptimizeFrames reduces a sequence of full-canvas frames to the
+smallest regions that reproduce them. Each frame is cropped to
+the pixels that differ from the canvas it's drawn onto, and the
+dispose operation of each frame is chosen to minimise the region
+of the frame that follows. Unchanged pixels within an opaque
+region are made transparent and blended over the canvas, which
+typically compresses considerably better.
+
+All frames must have the same bounds. The first frame is always
+kept whole as it's also the image shown by non-animated decoders.
+*/
+func OptimizeFrames(frames []image.Image) ([]FrameDelta, error) {
+
+	if len(frames) == 0 {
+		return nil, errors.New("pngutil: no frames to optimise")
+	}
+	bounds := frames[0].Bounds()
+	full := make([]*image.NRGBA, len(frames))
+	for i, f := range frames {
+		if f.Bounds().Size() != bounds.Size() {
+			return nil, errors.New("pngutil: frames must all be the same size")
+		}
+		full[i] = toNRGBA(f)
+	}
+
+	rect := full[0].Bounds()
+	deltas := make([]FrameDelta, len(frames))
+	deltas[0] = FrameDelta{Image: full[0], Blend: BlendSource}
+	canvas := image.NewNRGBA(rect) // canvas before the current frame is drawn
+
+	for i := range full {
+
+		region := rect
+		if i > 0 {
+			deltas[i] = cropFrame(canvas, full[i])
+			region = image.Rectangle{Min: image.Pt(int(deltas[i].X), int(deltas[i].Y))}
+			region.Max = region.Min.Add(deltas[i].Image.Rect.Size())
+		}
+		if i == len(full)-1 {
+			break
+		}
+
+		/*
+			Pick the dispose operation that leaves the canvas
+			closest to the next frame. DisposePrevious is treated
+			as DisposeBackground on the first frame so isn't
+			considered there.
+		*/
+		background := cloneNRGBA(full[i])
+		draw.Draw(background, region, image.Transparent, image.Point{}, draw.Src)
+		type candidate struct {
+			op     uint8
+			canvas *image.NRGBA
+		}
+		candidates := []candidate{
+			{DisposeNone, full[i]},
+			{DisposeBackground, background},
+		}
+		if i > 0 {
+			candidates = append(candidates, candidate{DisposePrevious, canvas})
+		}
+		best := -1
+		next := canvas
+		for _, c := range candidates {
+			area := diffRect(c.canvas, full[i+1])
+			if size := area.Dx() * area.Dy(); best < 0 || size < best {
+				best = size
+				deltas[i].Dispose = c.op
+				next = c.canvas
+			}
+		}
+		canvas = next
+	}
+
+	return deltas, nil
+}
+
+/*
+cropFrame returns the delta that draws frame onto canvas. Frames
+identical to the canvas are reduced to a single pixel since APNG
+frames can't be empty.
+*/
+func cropFrame(canvas, frame *image.NRGBA) FrameDelta {
+
+	r := diffRect(canvas, frame)
+	if r.Empty() {
+		r = image.Rect(0, 0, 1, 1)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(img, img.Rect, frame, r.Min, draw.Src)
+	d := FrameDelta{Image: img, X: uint32(r.Min.X), Y: uint32(r.Min.Y), Blend: BlendSource}
+
+	/*
+		Blending over is only equivalent where the frame's
+		changed pixels are opaque, in which case the unchanged
+		ones can be made transparent.
+	*/
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if !samePixel(canvas, frame, x, y) && frame.Pix[frame.PixOffset(x, y)+3] != 0xFF {
+				return d
+			}
+		}
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if samePixel(canvas, frame, x, y) {
+				i := img.PixOffset(x-r.Min.X, y-r.Min.Y)
+				copy(img.Pix[i:i+4], []byte{0, 0, 0, 0})
+			}
+		}
+	}
+	d.Blend = BlendOver
+	return d
+}
+
+// diffRect returns the smallest rectangle containing every differing pixel.
+func diffRect(a, b *image.NRGBA) image.Rectangle {
+	var r image.Rectangle
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		for x := a.Rect.Min.X; x < a.Rect.Max.X; x++ {
+			if !samePixel(a, b, x, y) {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return r
+}
+
+// samePixel reports whether a and b look the same at x, y.
+func samePixel(a, b *image.NRGBA, x, y int) bool {
+	pa := a.Pix[a.PixOffset(x, y):][:4]
+	pb := b.Pix[b.PixOffset(x, y):][:4]
+	if pa[3] == 0 && pb[3] == 0 {
+		return true // fully transparent regardless of colour
+	}
+	return pa[0] == pb[0] && pa[1] == pb[1] && pa[2] == pb[2] && pa[3] == pb[3]
+}
+
+// toNRGBA returns a copy of img as an NRGBA image with bounds starting at 0, 0.
+func toNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Rect, img, b.Min, draw.Src)
+	return out
+}
+
+func cloneNRGBA(img *image.NRGBA) *image.NRGBA {
+	out := *img
+	out.Pix = append([]byte(nil), img.Pix...)
+	return &out
+}
+
+/*
+IsAnimated reports whether rs is an animated PNG, which it is if
+an acTL chunk precedes the image data. Only the chunk headers up
+to the first IDAT chunk are read. The offset of rs is left
+unspecified.
+*/
+func IsAnimated(rs io.ReadSeeker) (bool, error) {
+
+	if err := Assert(rs); err != nil {
+		return false, err
+	}
+	p := make([]byte, 8)
+	for pos := int64(len(header)); ; {
+		if _, err := rs.Seek(pos, io.SeekStart); err != nil {
+			return false, err
+		}
+		if _, err := io.ReadFull(rs, p); err != nil {
+			return false, fmt.Errorf("pngutil: couldn't read chunk header at offset %d: %w", pos, err)
+		}
+		length := binary.BigEndian.Uint32(p[0:4])
+		if length > maxChunkLength {
+			return false, fmt.Errorf("pngutil: %s chunk at offset %d has invalid length %d", p[4:8], pos, length)
+		}
+		switch string(p[4:8]) {
+		case "acTL":
+			return true, nil
+		case "IDAT", "IEND":
+			return false, nil
+		}
+		pos += 12 + int64(length)
+	}
+}
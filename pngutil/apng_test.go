@@ -0,0 +1,362 @@
+package pngutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// renderDeltas composites deltas as an APNG decoder would, returning each frame.
+func renderDeltas(deltas []FrameDelta, bounds image.Rectangle) []*image.NRGBA {
+	canvas := image.NewNRGBA(bounds)
+	var out []*image.NRGBA
+	for _, d := range deltas {
+		r := d.Image.Rect.Add(image.Pt(int(d.X), int(d.Y)))
+		prev := cloneNRGBA(canvas)
+		op := draw.Src
+		if d.Blend == BlendOver {
+			op = draw.Over
+		}
+		draw.Draw(canvas, r, d.Image, image.Point{}, op)
+		out = append(out, cloneNRGBA(canvas))
+		switch d.Dispose {
+		case DisposeBackground:
+			draw.Draw(canvas, r, image.Transparent, image.Point{}, draw.Src)
+		case DisposePrevious:
+			canvas = prev
+		}
+	}
+	return out
+}
+
+func TestOptimizeFrames(t *testing.T) {
+
+	bounds := image.Rect(0, 0, 8, 8)
+	plain := image.NewNRGBA(bounds)
+	draw.Draw(plain, bounds, image.White, image.Point{}, draw.Src)
+	marked := cloneNRGBA(plain)
+	draw.Draw(marked, image.Rect(2, 3, 4, 5), image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	faded := cloneNRGBA(plain)
+	faded.Set(7, 7, color.NRGBA{0, 0, 255, 128})
+
+	frames := []image.Image{plain, marked, plain, faded}
+	deltas, err := OptimizeFrames(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, have := range renderDeltas(deltas, bounds) {
+		if diffRect(have, toNRGBA(frames[i])) != (image.Rectangle{}) {
+			t.Errorf("OptimizeFrames: frame %d doesn't render as the original", i)
+		}
+	}
+	sizes := []image.Point{{8, 8}, {2, 2}, {1, 1}, {1, 1}}
+	for i, d := range deltas {
+		if d.Image.Rect.Size() != sizes[i] {
+			t.Errorf("OptimizeFrames: frame %d has size %v, want %v", i, d.Image.Rect.Size(), sizes[i])
+		}
+	}
+	if deltas[1].Dispose != DisposePrevious || deltas[3].Blend != BlendSource {
+		t.Errorf("OptimizeFrames: unexpected operations %+v", deltas)
+	}
+}
+
+func TestOptimizeFramesOffset(t *testing.T) {
+
+	// Regions away from the origin must be disposed of where they were drawn.
+	bounds := image.Rect(0, 0, 8, 8)
+	red := image.NewUniform(color.NRGBA{255, 0, 0, 255})
+	green := image.NewUniform(color.NRGBA{0, 255, 0, 255})
+	a := image.NewNRGBA(bounds)
+	draw.Draw(a, image.Rect(0, 0, 2, 2), red, image.Point{}, draw.Src)
+	b := cloneNRGBA(a)
+	draw.Draw(b, image.Rect(5, 5, 7, 7), green, image.Point{}, draw.Src)
+	c := image.NewNRGBA(bounds)
+	draw.Draw(c, image.Rect(5, 5, 7, 7), green, image.Point{}, draw.Src)
+
+	frames := []image.Image{a, b, c, a}
+	deltas, err := OptimizeFrames(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, have := range renderDeltas(deltas, bounds) {
+		if r := diffRect(have, toNRGBA(frames[i])); r != (image.Rectangle{}) {
+			t.Errorf("OptimizeFrames: frame %d renders wrongly within %v", i, r)
+		}
+	}
+}
+
+func TestIsAnimated(t *testing.T) {
+
+	cases := []struct {
+		in   []byte
+		want bool
+	}{
+		{testPNG(t), false},
+		{testPNG(t, testChunk("acTL", make([]byte, 8))), true},
+		{testPNG(t, testChunk("tEXt", []byte("Title\x00x")), testChunk("acTL", make([]byte, 8))), true},
+	}
+	for i, c := range cases {
+		if have, err := IsAnimated(bytes.NewReader(c.in)); err != nil || have != c.want {
+			t.Errorf("IsAnimated(case %d)\n    have: %t, err: %v\n    want: %t\n", i, have, err, c.want)
+		}
+	}
+
+	// acTL after the image data doesn't make an APNG.
+	in := testPNG(t)
+	end := len(in) - 12
+	in = append(append(in[:end:end], testChunk("acTL", make([]byte, 8))...), in[end:]...)
+	if have, err := IsAnimated(bytes.NewReader(in)); err != nil || have {
+		t.Errorf("IsAnimated with acTL after IDAT\n    have: %t, err: %v\n    want: false\n", have, err)
+	}
+}
+
+/*
+testAPNG returns an animated PNG of a 4x4 red default image
+followed by a 2x2 blue frame at 1, 1 stored as fdAT chunks.
+*/
+func testAPNG(t *testing.T) []byte {
+	t.Helper()
+	fill := func(w, h int, c color.Color, typ string) []byte {
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(img, img.Rect, image.NewUniform(c), image.Point{}, draw.Src)
+		data, _, err := ChunkData(bytes.NewReader(encodePNG(t, img)), typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	control := func(fc FrameControl) []byte {
+		data, err := fc.MarshalChunk()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return testChunk("fcTL", data)
+	}
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	out := append([]byte{}, header...)
+	out = append(out, testChunk("IHDR", fill(4, 4, red, "IHDR"))...)
+	out = append(out, testChunk("acTL", []byte{0, 0, 0, 2, 0, 0, 0, 0})...)
+	out = append(out, control(FrameControl{Sequence: 0, Width: 4, Height: 4, DelayNum: 1})...)
+	out = append(out, testChunk("IDAT", fill(4, 4, red, "IDAT"))...)
+	out = append(out, control(FrameControl{Sequence: 1, Width: 2, Height: 2, X: 1, Y: 1, Blend: BlendOver})...)
+	out = append(out, testChunk("fdAT", append([]byte{0, 0, 0, 2}, fill(2, 2, blue, "IDAT")...))...)
+	return append(out, iend...)
+}
+
+func TestFrames(t *testing.T) {
+
+	frames, err := Frames(bytes.NewReader(testAPNG(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		control FrameControl
+		color   color.NRGBA
+	}{
+		{FrameControl{Sequence: 0, Width: 4, Height: 4, DelayNum: 1}, color.NRGBA{255, 0, 0, 255}},
+		{FrameControl{Sequence: 1, Width: 2, Height: 2, X: 1, Y: 1, Blend: BlendOver}, color.NRGBA{0, 0, 255, 255}},
+	}
+	if len(frames) != len(want) {
+		t.Fatalf("Frames: have %d frames, want %d", len(frames), len(want))
+	}
+	for i, f := range frames {
+		if f.Control != want[i].control {
+			t.Errorf("Frames: frame %d\n    have: %+v\n    want: %+v\n", i, f.Control, want[i].control)
+		}
+		img, err := png.Decode(f.Image)
+		if err != nil {
+			t.Errorf("Frames: frame %d doesn't decode: %v", i, err)
+			continue
+		}
+		size := image.Pt(int(want[i].control.Width), int(want[i].control.Height))
+		if img.Bounds().Size() != size || color.NRGBAModel.Convert(img.At(1, 1)) != want[i].color {
+			t.Errorf("Frames: frame %d has size %v and colour %v, want %v and %v",
+				i, img.Bounds().Size(), img.At(1, 1), size, want[i].color)
+		}
+	}
+
+	if _, err = Frames(bytes.NewReader(testPNG(t))); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("Frames(still image)\n    have: %v\n    want: %v\n", err, ErrNoChunk)
+	}
+}
+
+func TestWriteAnimation(t *testing.T) {
+
+	fill := func(w, h int, c color.NRGBA) []byte {
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(img, img.Rect, image.NewUniform(c), image.Point{}, draw.Src)
+		return encodePNG(t, img)
+	}
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	in := []Frame{
+		{FrameControl{DelayNum: 1, DelayDen: 10}, bytes.NewReader(fill(4, 4, red))},
+		{FrameControl{X: 2, Y: 1, DelayNum: 1, DelayDen: 10, Dispose: DisposePrevious}, bytes.NewReader(fill(2, 3, blue))},
+		{FrameControl{DelayNum: 1, DelayDen: 10, Blend: BlendOver}, bytes.NewReader(fill(1, 1, blue))},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAnimation(&buf, in, 3); err != nil {
+		t.Fatal(err)
+	}
+	rs := bytes.NewReader(buf.Bytes())
+	if err := ValidateOrder(rs); err != nil {
+		t.Errorf("WriteAnimation: output out of order: %v", err)
+	}
+	var ac AnimationControl
+	if err := getChunk(rs, &ac); err != nil || ac != (AnimationControl{Frames: 3, Plays: 3}) {
+		t.Errorf("WriteAnimation: acTL\n    have: %+v, err: %v\n    want: %+v\n", ac, err, AnimationControl{Frames: 3, Plays: 3})
+	}
+	frames, err := Frames(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != len(in) {
+		t.Fatalf("WriteAnimation: have %d frames, want %d", len(frames), len(in))
+	}
+	sizes := []image.Point{{4, 4}, {2, 3}, {1, 1}}
+	for i, f := range frames {
+		want := in[i].Control
+		want.Sequence = []uint32{0, 1, 3}[i]
+		want.Width, want.Height = uint32(sizes[i].X), uint32(sizes[i].Y)
+		if f.Control != want {
+			t.Errorf("WriteAnimation: frame %d\n    have: %+v\n    want: %+v\n", i, f.Control, want)
+		}
+		if img, err := png.Decode(f.Image); err != nil || img.Bounds().Size() != sizes[i] {
+			t.Errorf("WriteAnimation: frame %d doesn't decode to %v: %v", i, sizes[i], err)
+		}
+	}
+
+	bad := [][]Frame{
+		nil,
+		{{FrameControl{X: 1}, bytes.NewReader(fill(4, 4, red))}},
+		{in[0], {FrameControl{X: 3}, bytes.NewReader(fill(2, 2, blue))}},
+		{in[0], {FrameControl{}, bytes.NewReader(palettedPNG(t, 4))}},
+	}
+	for i, frames := range bad {
+		if err := WriteAnimation(io.Discard, frames, 0); err == nil {
+			t.Errorf("WriteAnimation(bad case %d): have nil error", i)
+		}
+	}
+}
+
+func TestReplaceMetaAnimation(t *testing.T) {
+
+	in := testAPNG(t)
+	cases := []struct {
+		policy Policy
+		want   []string
+	}{
+		{nil, []string{"IHDR", "iTXt", "acTL", "fcTL", "IDAT", "fcTL", "fdAT", "IEND"}},
+		{Keep("tEXt"), []string{"IHDR", "iTXt", "acTL", "fcTL", "IDAT", "fcTL", "fdAT", "IEND"}},
+		{StripAnimation, []string{"IHDR", "iTXt", "IDAT", "IEND"}},
+		{func(typ string) bool { return typ == "fdAT" }, []string{"IHDR", "iTXt", "IDAT", "IEND"}},
+	}
+	for i, c := range cases {
+		mrs, err := ReplaceMetaWithOptions(bytes.NewReader(in), Metadata{"Title": "x"}, Options{Policy: c.policy})
+		if err != nil {
+			t.Errorf("ReplaceMeta(case %d): %v", i, err)
+			continue
+		}
+		if have := chunkTypes(t, mrs); !reflect.DeepEqual(have, c.want) {
+			t.Errorf("ReplaceMeta(case %d)\n    have: %v\n    want: %v\n", i, have, c.want)
+		}
+	}
+
+	mrs, err := ReplaceMeta(bytes.NewReader(in), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames, err := Frames(mrs); err != nil || len(frames) != 2 {
+		t.Errorf("ReplaceMeta: output has %d frames, want 2: %v", len(frames), err)
+	}
+}
+
+func TestRenumberFrames(t *testing.T) {
+
+	in := testAPNG(t)
+	infos, err := Chunks(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := append([]byte{}, in...)
+	seq := uint32(7)
+	for _, c := range infos {
+		if c.Type == "fcTL" || c.Type == "fdAT" {
+			binary.BigEndian.PutUint32(bad[c.Offset+8:], seq)
+			seq += 2
+		}
+	}
+	mrs, _, err := RepairCRC(bytes.NewReader(bad))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bad, err = io.ReadAll(mrs); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name string
+		in   []byte
+	}{
+		{"numbered", in},
+		{"misnumbered", bad},
+	} {
+		mrs, err := RenumberFrames(bytes.NewReader(c.in))
+		if err != nil {
+			t.Errorf("RenumberFrames(%s): %v", c.name, err)
+			continue
+		}
+		have, err := io.ReadAll(mrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(have, in) {
+			t.Errorf("RenumberFrames(%s): output differs from correctly numbered input", c.name)
+		}
+	}
+}
+
+func TestAnimationInfo(t *testing.T) {
+
+	have, err := AnimationInfo(bytes.NewReader(testAPNG(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Animation{
+		Width:    4,
+		Height:   4,
+		Frames:   2,
+		Delays:   []time.Duration{10 * time.Millisecond, 0},
+		Duration: 10 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("AnimationInfo\n    have: %+v\n    want: %+v\n", have, want)
+	}
+	if _, err = AnimationInfo(bytes.NewReader(testPNG(t))); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("AnimationInfo(still image)\n    have: %v\n    want: %v\n", err, ErrNoChunk)
+	}
+
+	delays := []struct {
+		num, den uint16
+		want     time.Duration
+	}{
+		{0, 0, 0},
+		{5, 0, 50 * time.Millisecond},
+		{1, 3, time.Second / 3},
+		{3, 2, 1500 * time.Millisecond},
+	}
+	for _, c := range delays {
+		if have := (FrameControl{DelayNum: c.num, DelayDen: c.den}).Delay(); have != c.want {
+			t.Errorf("FrameControl{DelayNum: %d, DelayDen: %d}.Delay()\n    have: %v\n    want: %v\n", c.num, c.den, have, c.want)
+		}
+	}
+}
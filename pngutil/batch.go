@@ -0,0 +1,150 @@
+package pngutil
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Manifest maps file names to the metadata each file should be given.
+type Manifest map[string]Metadata
+
+/*
+ReadManifest reads a manifest from the file at name, which must
+have either a ".json" or ".csv" extension. See ReadManifestJSON
+and ReadManifestCSV for the expected formats.
+*/
+func ReadManifest(name string) (m Manifest, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("pngutil: %w", err)
+	}
+	defer closeFile(f, &err)
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return ReadManifestJSON(f)
+	case ".csv":
+		return ReadManifestCSV(f)
+	}
+	return nil, fmt.Errorf("pngutil: unknown manifest format %q", filepath.Ext(name))
+}
+
+/*
+ReadManifestJSON reads a manifest in the form of a JSON object
+whose keys are file names and whose values are objects mapping
+keywords to text:
+
+	{
+		"beach.png": {"Title": "Beach", "Author": "A. Photographer"},
+		"hills.png": {"Title": "Hills"}
+	}
+*/
+func ReadManifestJSON(r io.Reader) (Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("pngutil: reading manifest: %w", err)
+	}
+	return m, nil
+}
+
+/*
+ReadManifestCSV reads a manifest in CSV form. The first row is
+a header whose first column names the file and whose remaining
+columns are keywords. Empty cells are omitted from a file's
+metadata:
+
+	file,Title,Author
+	beach.png,Beach,A. Photographer
+	hills.png,Hills,
+*/
+func ReadManifestCSV(r io.Reader) (Manifest, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("pngutil: reading manifest: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("pngutil: manifest has no header row")
+	}
+	keywords := rows[0][1:]
+	m := make(Manifest, len(rows)-1)
+	for i, row := range rows[1:] {
+		if row[0] == "" {
+			return nil, fmt.Errorf("pngutil: manifest row %d has no file name", i+2)
+		}
+		meta := make(Metadata)
+		for j, v := range row[1:] {
+			if v != "" {
+				meta[keywords[j]] = v
+			}
+		}
+		m[row[0]] = meta
+	}
+	return m, nil
+}
+
+/*
+ApplyManifest gives every file listed in m its metadata using
+ReplaceMetaWithOptions with opts. Files are read from srcDir and
+written under the same name to dstDir, which may be the same
+directory. Relative names in m may not escape srcDir.
+
+If opts.Template is set, each file's name is made available to
+templates as Filename.
+
+A failure with one file doesn't stop the others being processed;
+all failures are joined into the returned error.
+*/
+func ApplyManifest(m Manifest, srcDir, dstDir string, opts Options) error {
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if err := opts.context().Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		if err := applyManifestFile(name, m[name], srcDir, dstDir, opts); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func applyManifestFile(name string, meta Metadata, srcDir, dstDir string, opts Options) (err error) {
+
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("pngutil: file name %q isn't local to the source directory", name)
+	}
+
+	f, err := os.Open(filepath.Join(srcDir, name))
+	if err != nil {
+		return fmt.Errorf("pngutil: %w", err)
+	}
+	defer closeFile(f, &err)
+
+	// The output may overwrite f so it can't be read lazily.
+	opts.Materialize = true
+	if opts.Template != nil {
+		data := *opts.Template
+		data.Filename = filepath.Base(name)
+		opts.Template = &data
+	}
+
+	mrs, err := ReplaceMetaWithOptions(f, meta, opts)
+	if err != nil {
+		return err
+	}
+	_, err = WriteFileWithOptions(filepath.Join(dstDir, name), mrs, opts)
+	return err
+}
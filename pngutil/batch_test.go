@@ -0,0 +1,99 @@
+package pngutil
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadManifest(t *testing.T) {
+
+	want := Manifest{
+		"beach.png": {MetaTitle: "Beach", MetaAuthor: "A. Photographer"},
+		"hills.png": {MetaTitle: "Hills"},
+	}
+	csv := "file,Title,Author\nbeach.png,Beach,A. Photographer\nhills.png,Hills,\n"
+	json := `{"beach.png": {"Title": "Beach", "Author": "A. Photographer"}, "hills.png": {"Title": "Hills"}}`
+
+	if have, err := ReadManifestCSV(strings.NewReader(csv)); err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("ReadManifestCSV\n    have: %v, err: %v\n    want: %v\n", have, err, want)
+	}
+	if have, err := ReadManifestJSON(strings.NewReader(json)); err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("ReadManifestJSON\n    have: %v, err: %v\n    want: %v\n", have, err, want)
+	}
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{"m.csv": csv, "m.JSON": json, "m.txt": csv} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		have, err := ReadManifest(filepath.Join(dir, name))
+		if name == "m.txt" {
+			if err == nil {
+				t.Errorf("ReadManifest(%q) succeeded, want error", name)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(have, want) {
+			t.Errorf("ReadManifest(%q)\n    have: %v, err: %v\n    want: %v\n", name, have, err, want)
+		}
+	}
+
+	bad := []string{
+		"",
+		"file,Title\n,Beach\n",
+		"file,Title\nbeach.png\n",
+		"file,Title\nbeach.png,\"Beach\n",
+	}
+	for _, in := range bad {
+		if _, err := ReadManifestCSV(strings.NewReader(in)); err == nil {
+			t.Errorf("ReadManifestCSV(%q) succeeded, want error", in)
+		}
+	}
+	if _, err := ReadManifestJSON(strings.NewReader(`{"beach.png": "Beach"}`)); err == nil {
+		t.Errorf("ReadManifestJSON accepted a file without a metadata object")
+	}
+}
+
+func TestApplyManifest(t *testing.T) {
+
+	src, dst := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a.png"), testPNG(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	abs := filepath.Join(src, "a.png")
+	m := Manifest{
+		"a.png":       {MetaTitle: "A"},
+		"missing.png": {MetaTitle: "Missing"},
+		"../x.png":    {MetaTitle: "Escaped"},
+		abs:           {MetaTitle: "Absolute"},
+	}
+
+	err := ApplyManifest(m, src, dst, Options{})
+	if err == nil {
+		t.Fatal("ApplyManifest succeeded, want error")
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(joined.Unwrap()) != 3 {
+		t.Fatalf("ApplyManifest\n    have err: %v\n    want: 3 joined errors\n", err)
+	}
+	for _, name := range []string{"missing.png", "../x.png", abs} {
+		if !strings.Contains(err.Error(), name+": ") {
+			t.Errorf("ApplyManifest error doesn't mention %q: %v", name, err)
+		}
+	}
+
+	out, err := os.ReadFile(filepath.Join(dst, "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := ReadMeta(bytes.NewReader(out)); err != nil || have[MetaTitle] != "A" {
+		t.Errorf("ApplyManifest wrote\n    have: %v, err: %v\n    want: %v\n", have, err, m["a.png"])
+	}
+	if _, err = os.Stat(filepath.Join(filepath.Dir(dst), "x.png")); !os.IsNotExist(err) {
+		t.Errorf("ApplyManifest wrote outside the destination directory")
+	}
+}
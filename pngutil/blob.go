@@ -0,0 +1,115 @@
+package pngutil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Compression methods prefixed to blob payloads.
+const (
+	blobStored  byte = 0
+	blobDeflate byte = 1
+)
+
+// MaxBlobSize is the largest payload StoreBlob and LoadBlob handle.
+const MaxBlobSize = maxChunkLength - 1
+
+// ErrNoChunk is returned when a requested chunk isn't present.
+var ErrNoChunk = errors.New("pngutil: chunk not found")
+
+/*
+StoreBlob returns rs with data stored in a chunk of type fourCC,
+replacing any existing chunks of that type. fourCC must name an
+ancillary, private chunk type (lower case first and second
+letters, upper case third letter) so that decoders ignore it,
+such as "myAp".
+
+The chunk is placed where the first existing chunk of its type
+was, or otherwise before IEND. Note ReplaceMeta discards it unless
+kept by Options.Policy or RegisterChunk.
+*/
+func StoreBlob(rs io.ReadSeeker, fourCC string, data []byte) (*multiReadSeeker, error) {
+	return StoreBlobWithOptions(rs, fourCC, data, Options{})
+}
+
+/*
+StoreBlobWithOptions is like StoreBlob but accepts Options. It
+consults Compress to deflate data before it is stored.
+*/
+func StoreBlobWithOptions(rs io.ReadSeeker, fourCC string, data []byte, opts Options) (*multiReadSeeker, error) {
+
+	if err := validPrivateType(fourCC); err != nil {
+		return nil, err
+	}
+	if len(data) > MaxBlobSize {
+		return nil, fmt.Errorf("%w: blob of %d bytes is over maximum of %d", ErrLimitExceeded, len(data), MaxBlobSize)
+	}
+
+	payload := append(make([]byte, 0, len(data)+1), blobStored)
+	payload = append(payload, data...)
+	if opts.Compress {
+		var buf bytes.Buffer
+		buf.WriteByte(blobDeflate)
+		zw := zlib.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		// Incompressible data is stored as is.
+		if buf.Len() < len(payload) {
+			payload = buf.Bytes()
+		}
+	}
+	if len(payload) > maxChunkLength {
+		return nil, fmt.Errorf("%w: blob of %d bytes is over maximum of %d", ErrLimitExceeded, len(data), MaxBlobSize)
+	}
+
+	return replaceChunk(rs, fourCC, payload)
+}
+
+/*
+LoadBlob returns the data stored by StoreBlob in the first chunk
+of type fourCC in rs. It returns ErrNoChunk if there's no such
+chunk.
+*/
+func LoadBlob(rs io.ReadSeeker, fourCC string) ([]byte, error) {
+
+	if err := validPrivateType(fourCC); err != nil {
+		return nil, err
+	}
+	payload, err := firstChunkData(rs, fourCC)
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		return nil, fmt.Errorf("%w: no %s chunk", ErrNoChunk, fourCC)
+	}
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("pngutil: %s chunk has no compression method", fourCC)
+	}
+
+	switch payload[0] {
+	case blobStored:
+		return payload[1:], nil
+	case blobDeflate:
+		zr, err := zlib.NewReader(bytes.NewReader(payload[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("pngutil: %s chunk: %w", fourCC, err)
+		}
+		defer zr.Close()
+		data, err := io.ReadAll(io.LimitReader(zr, MaxBlobSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("pngutil: %s chunk: %w", fourCC, err)
+		}
+		if len(data) > MaxBlobSize {
+			return nil, fmt.Errorf("%w: %s chunk inflates to over %d bytes", ErrLimitExceeded, fourCC, MaxBlobSize)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("pngutil: %s chunk has unknown compression method %d", fourCC, payload[0])
+}
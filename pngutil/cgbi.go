@@ -0,0 +1,246 @@
+package pngutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+ErrCgBI is returned by Assert, and so by most functions, for the
+PNGs Xcode writes when optimising an iOS app's images. These
+start with a CgBI chunk before IHDR, store their image data as
+raw deflate without a zlib header or checksum, and hold pixels
+as premultiplied BGRA, so standard decoders reject them or show
+the wrong colours. NormalizeCgBI converts them.
+*/
+var ErrCgBI = errors.New("pngutil: Apple CgBI PNG, not a standard PNG")
+
+/*
+IsCgBI reports whether rs is an Apple CgBI PNG, which it is if a
+CgBI chunk directly follows the PNG signature. Only the first 16
+bytes are read. The offset of rs is left unspecified.
+*/
+func IsCgBI(rs io.ReadSeeker) (bool, error) {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	p := make([]byte, 16)
+	if _, err := io.ReadFull(rs, p); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	return isCgBI(p), nil
+}
+
+// isCgBI reports whether p, the start of a stream, begins a CgBI PNG.
+func isCgBI(p []byte) bool {
+	return len(p) >= 16 && bytes.Equal(p[:8], header) && string(p[12:16]) == "CgBI"
+}
+
+// maxCgBIData is the most image data NormalizeCgBI holds in memory.
+const maxCgBIData = 1 << 30
+
+/*
+NormalizeCgBI returns the Apple CgBI PNG f rewritten as a
+standard PNG: the CgBI chunk is dropped, the image data is
+recompressed as a zlib stream, and for 8-bit truecolour images
+the pixels are converted from premultiplied BGR(A) to RGB(A).
+The image data is held in memory to do so, so images whose data
+inflates to more than 1 GiB are rejected with an error wrapping
+ErrLimitExceeded, as are those over the MaxDecodedBytes field of
+DefaultLimits if it's set. The data is written unfiltered
+in one or more IDAT chunks where the first IDAT chunk of f was.
+Every other chunk is kept byte for byte, except an Apple iDOT
+chunk, whose offsets no longer apply.
+
+As with ReplaceMeta, the result reads from f so f shouldn't be
+altered until it has been drained.
+*/
+func NormalizeCgBI(f io.ReadSeeker) (*multiReadSeeker, error) {
+
+	if ok, err := IsCgBI(f); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("%w: no CgBI chunk", ErrNoChunk)
+	}
+	opts := Options{}
+	idx, err := scanChunks(opts.context(), f, opts.limits())
+	if err != nil {
+		return nil, err
+	}
+	if len(idx) < 2 || idx[1].typ != "IHDR" || idx[len(idx)-1].typ != "IEND" {
+		return nil, errors.New("pngutil: CgBI PNG lacks IHDR or IEND chunk")
+	}
+	h, err := readIHDR(f, idx[1:])
+	if err != nil {
+		return nil, err
+	}
+	if err = h.Check(); err != nil {
+		return nil, err
+	}
+	if err = opts.limits().checkImage(h); err != nil {
+		return nil, err
+	}
+	swap := h.ColorType == ColorRGB || h.ColorType == ColorRGBA
+	if swap && h.BitDepth != 8 {
+		return nil, fmt.Errorf("pngutil: CgBI image with bit depth %d isn't supported", h.BitDepth)
+	}
+	want := h.rawSize()
+	if want > maxCgBIData {
+		return nil, fmt.Errorf("%w: image data of %d bytes is over maximum of %d", ErrLimitExceeded, want, int64(maxCgBIData))
+	}
+
+	// CgBI image data is raw deflate, lacking zlib's header and checksum.
+	a := newAssembler(f, len(idx))
+	for _, c := range idx {
+		if c.typ == "IDAT" {
+			a.copyRange(c.dataOffset(), c.end()-4)
+		}
+	}
+	data, err := a.finish()
+	if err != nil {
+		return nil, err
+	}
+
+	// The buffer grows with the data rather than trusting IHDR.
+	var inflated bytes.Buffer
+	n, err := inflated.ReadFrom(io.LimitReader(flate.NewReader(data), want+1))
+	if err != nil {
+		return nil, fmt.Errorf("pngutil: CgBI image data is corrupt: %w", err)
+	}
+	if n != want {
+		return nil, fmt.Errorf("pngutil: CgBI image data inflates to %d bytes, want %d", n, want)
+	}
+	raw := inflated.Bytes()
+
+	if swap {
+		if err = unfilter(raw, h); err != nil {
+			return nil, err
+		}
+		cgbiToRGB(raw, h)
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err = zw.Write(raw); err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+
+	a = newAssembler(f, len(idx)+2)
+	a.copyRange(0, idx[0].offset)
+	written := false
+	for _, c := range idx[1:] {
+		switch c.typ {
+		case "iDOT":
+		case "IDAT":
+			if written {
+				continue
+			}
+			for p := buf.Bytes(); len(p) > 0; {
+				n := len(p)
+				if n > maxChunkLength {
+					n = maxChunkLength
+				}
+				a.write("IDAT", AppendChunk(nil, "IDAT", p[:n]))
+				p = p[n:]
+			}
+			written = true
+		default:
+			a.copyChunk(c)
+		}
+	}
+	return a.finish()
+}
+
+/*
+unfilter reverses the filtering of the inflated image data raw
+of an image with header h in place, leaving each row's filter
+byte set to none.
+*/
+func unfilter(raw []byte, h ImageInfo) error {
+	bpp := (h.channels()*int(h.BitDepth) + 7) / 8
+	for _, p := range h.passes() {
+		n := int(h.rowBytes(p[0]))
+		prev := make([]byte, n) // row above, zero for the first
+		for y := uint64(0); y < p[1]; y++ {
+			filter, row := raw[0], raw[1:1+n]
+			for i := range row {
+				var left, upLeft byte
+				if i >= bpp {
+					left, upLeft = row[i-bpp], prev[i-bpp]
+				}
+				up := prev[i]
+				switch filter {
+				case 0:
+				case 1:
+					row[i] += left
+				case 2:
+					row[i] += up
+				case 3:
+					row[i] += byte((int(left) + int(up)) / 2)
+				case 4:
+					row[i] += paeth(left, up, upLeft)
+				default:
+					return fmt.Errorf("pngutil: unknown filter type %d", filter)
+				}
+			}
+			raw[0] = 0
+			prev, raw = row, raw[1+n:]
+		}
+	}
+	return nil
+}
+
+// paeth returns whichever of a, b and c is closest to a + b - c.
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+/*
+cgbiToRGB converts the unfiltered 8-bit pixels in raw from the
+premultiplied BGR(A) of CgBI to the RGB(A) of the spec.
+*/
+func cgbiToRGB(raw []byte, h ImageInfo) {
+	bpp := h.channels()
+	for _, p := range h.passes() {
+		n := int(h.rowBytes(p[0]))
+		for y := uint64(0); y < p[1]; y++ {
+			row := raw[1 : 1+n]
+			for i := 0; i < n; i += bpp {
+				px := row[i : i+bpp]
+				px[0], px[2] = px[2], px[0]
+				if bpp == 4 && px[3] != 0 && px[3] != 0xFF {
+					a := int(px[3])
+					for j := 0; j < 3; j++ {
+						if v := (int(px[j])*0xFF + a/2) / a; v < 0xFF {
+							px[j] = byte(v)
+						} else {
+							px[j] = 0xFF
+						}
+					}
+				}
+			}
+			raw = raw[1+n:]
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package pngutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// cgbiPNG returns in with a CgBI chunk inserted before IHDR.
+func cgbiPNG(t *testing.T, in []byte) []byte {
+	t.Helper()
+	out := append([]byte{}, header...)
+	out = append(out, testChunk("CgBI", []byte{0x50, 0x00, 0x20, 0x02})...)
+	return append(out, in[len(header):]...)
+}
+
+func TestIsCgBI(t *testing.T) {
+
+	in := testPNG(t)
+	cgbi := cgbiPNG(t, in)
+	cases := []struct {
+		name string
+		in   []byte
+		want bool
+	}{
+		{"png", in, false},
+		{"cgbi", cgbi, true},
+		{"short", header, false},
+	}
+	for _, c := range cases {
+		if have, err := IsCgBI(bytes.NewReader(c.in)); err != nil || have != c.want {
+			t.Errorf("IsCgBI(%s)\n    have: %t, err: %v\n    want: %t\n", c.name, have, err, c.want)
+		}
+	}
+
+	if err := Assert(bytes.NewReader(cgbi)); !errors.Is(err, ErrCgBI) {
+		t.Errorf("Assert(cgbi)\n    have: %v\n    want: %v\n", err, ErrCgBI)
+	}
+	if err := Validate(bytes.NewReader(cgbi)); !errors.Is(err, ErrCgBI) {
+		t.Errorf("Validate(cgbi)\n    have: %v\n    want: %v\n", err, ErrCgBI)
+	}
+	if _, err := ReplaceMeta(bytes.NewReader(cgbi), nil); !errors.Is(err, ErrCgBI) {
+		t.Errorf("ReplaceMeta(cgbi)\n    have: %v\n    want: %v\n", err, ErrCgBI)
+	}
+}
+
+func TestNormalizeCgBI(t *testing.T) {
+
+	// Each row uses a different filter type to exercise unfilter.
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 5))
+	colors := []color.NRGBA{{255, 0, 0, 255}, {0, 255, 255, 128}, {255, 255, 0, 0}, {0, 0, 255, 255}, {255, 0, 255, 128}}
+	for i := 0; i < 4*5; i++ {
+		c := colors[(i*3)%len(colors)]
+		if c.A == 0 {
+			c = color.NRGBA{}
+		}
+		img.SetNRGBA(i%4, i/4, c)
+	}
+	var raw []byte
+	prev := make([]byte, 16)
+	for y := 0; y < 5; y++ {
+		row := make([]byte, 16)
+		for x := 0; x < 4; x++ {
+			c := img.NRGBAAt(x, y)
+			pre := func(v uint8) byte { return byte((int(v)*int(c.A) + 127) / 255) }
+			copy(row[4*x:], []byte{pre(c.B), pre(c.G), pre(c.R), c.A})
+		}
+		filtered := make([]byte, 16)
+		for i := range row {
+			var left, upLeft byte
+			if i >= 4 {
+				left, upLeft = row[i-4], prev[i-4]
+			}
+			predict := []byte{0, left, prev[i], byte((int(left) + int(prev[i])) / 2), paeth(left, prev[i], upLeft)}[y]
+			filtered[i] = row[i] - predict
+		}
+		raw = append(append(raw, byte(y)), filtered...)
+		prev = row
+	}
+	var deflated bytes.Buffer
+	fw, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(raw)
+	fw.Close()
+
+	in := append([]byte{}, header...)
+	in = append(in, testChunk("CgBI", []byte{0x50, 0x00, 0x20, 0x02})...)
+	in = append(in, testChunk("IHDR", []byte{0, 0, 0, 4, 0, 0, 0, 5, 8, ColorRGBA, 0, 0, 0})...)
+	in = append(in, testChunk("tEXt", []byte("Title\x00x"))...)
+	in = append(in, testChunk("IDAT", deflated.Bytes())...)
+	in = append(in, iend...)
+
+	mrs, err := NormalizeCgBI(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(mrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Validate(bytes.NewReader(out)); err != nil {
+		t.Errorf("NormalizeCgBI: output fails Validate: %v", err)
+	}
+	if err = VerifyImageData(bytes.NewReader(out)); err != nil {
+		t.Errorf("NormalizeCgBI: output fails VerifyImageData: %v", err)
+	}
+	if have := chunkTypes(t, bytes.NewReader(out)); !reflect.DeepEqual(have, []string{"IHDR", "tEXt", "IDAT", "IEND"}) {
+		t.Errorf("NormalizeCgBI: chunks %v", have)
+	}
+	decoded, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 4; x++ {
+			if have, want := color.NRGBAModel.Convert(decoded.At(x, y)), img.NRGBAAt(x, y); have != want {
+				t.Errorf("NormalizeCgBI: pixel %d, %d\n    have: %v\n    want: %v\n", x, y, have, want)
+			}
+		}
+	}
+
+	if _, err = NormalizeCgBI(bytes.NewReader(testPNG(t))); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("NormalizeCgBI(standard PNG)\n    have: %v\n    want: %v\n", err, ErrNoChunk)
+	}
+}
+
+func TestNormalizeCgBIHugeIHDR(t *testing.T) {
+
+	var deflated bytes.Buffer
+	fw, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(make([]byte, 64))
+	fw.Close()
+
+	cases := []struct {
+		width, height uint32
+		limit         bool
+	}{
+		{1<<31 - 1, 1<<31 - 1, true},
+		{65535, 65535, true},
+		{4000, 4000, false},
+	}
+
+	for _, c := range cases {
+		ihdr := binary.BigEndian.AppendUint32(nil, c.width)
+		ihdr = binary.BigEndian.AppendUint32(ihdr, c.height)
+		ihdr = append(ihdr, 8, ColorRGBA, 0, 0, 0)
+		in := append([]byte{}, header...)
+		in = append(in, testChunk("CgBI", []byte{0x50, 0x00, 0x20, 0x02})...)
+		in = append(in, testChunk("IHDR", ihdr)...)
+		in = append(in, testChunk("IDAT", deflated.Bytes())...)
+		in = append(in, iend...)
+
+		_, err := NormalizeCgBI(bytes.NewReader(in))
+		if err == nil || errors.Is(err, ErrLimitExceeded) != c.limit {
+			t.Errorf("NormalizeCgBI(%dx%d)\n    have err: %v\n    want limit error: %t\n", c.width, c.height, err, c.limit)
+		}
+	}
+}
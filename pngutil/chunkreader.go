@@ -0,0 +1,217 @@
+package pngutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+Chunk is a single chunk of a PNG stream. Data excludes the
+chunk's length, type and CRC. CRC is the checksum as stored,
+which isn't verified; compare it with ChunkCRC to do so.
+*/
+type Chunk struct {
+	Type   string
+	Length uint32
+	Offset int64 // offset of the chunk's length field
+	Data   []byte
+	CRC    uint32
+}
+
+/*
+ChunkReader iterates over the chunks of a PNG stream. Create
+one with NewChunkReader and call NextChunk until it returns
+io.EOF.
+*/
+type ChunkReader struct {
+	rs   io.ReadSeeker
+	opts Options
+	pos  int64 // offset of the next chunk
+	n    int   // chunks read so far
+	done bool
+}
+
+/*
+NewChunkReader asserts that rs is a PNG and returns a reader of
+its chunks, starting with IHDR.
+*/
+func NewChunkReader(rs io.ReadSeeker) (*ChunkReader, error) {
+	return NewChunkReaderWithOptions(rs, Options{})
+}
+
+/*
+NewChunkReaderWithOptions is like NewChunkReader but accepts
+Options. It consults Context and the MaxChunks and MaxChunkSize
+fields of Limits, as well as those consulted by Assert.
+*/
+func NewChunkReaderWithOptions(rs io.ReadSeeker, opts Options) (*ChunkReader, error) {
+	if err := AssertWithOptions(rs, opts); err != nil {
+		return nil, err
+	}
+	return &ChunkReader{
+		rs:   rs,
+		opts: opts,
+		pos:  int64(len(header)),
+	}, nil
+}
+
+/*
+NextChunk reads the next chunk, including its data. It returns
+io.EOF once the IEND chunk has been read. The offset of the
+underlying reader is set before each chunk is read, so it may be
+used between calls.
+*/
+func (cr *ChunkReader) NextChunk() (c Chunk, err error) {
+
+	if cr.done {
+		return c, io.EOF
+	}
+	if err = cr.opts.context().Err(); err != nil {
+		return c, err
+	}
+	if _, err = cr.rs.Seek(cr.pos, io.SeekStart); err != nil {
+		return c, err
+	}
+
+	p := make([]byte, 8)
+	if _, err = io.ReadFull(cr.rs, p); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return c, fmt.Errorf("pngutil: couldn't read chunk header at offset %d: %w", cr.pos, err)
+	}
+	h := chunkHeader{
+		offset: cr.pos,
+		length: binary.BigEndian.Uint32(p[0:4]),
+		typ:    string(p[4:8]),
+	}
+	if h.length > maxChunkLength {
+		return c, fmt.Errorf("pngutil: %s chunk at offset %d has invalid length %d", h.typ, h.offset, h.length)
+	}
+	lim := cr.opts.limits()
+	if err = lim.checkChunkSize(h); err != nil {
+		return c, err
+	}
+	cr.n++
+	if err = lim.checkChunks(cr.n); err != nil {
+		return c, err
+	}
+
+	data := make([]byte, int(h.length)+4)
+	if _, err = io.ReadFull(cr.rs, data); err != nil {
+		return c, fmt.Errorf("pngutil: couldn't read %s chunk at offset %d: %w", h.typ, h.offset, err)
+	}
+
+	cr.pos = h.end()
+	cr.done = h.typ == "IEND"
+	return Chunk{
+		Type:   h.typ,
+		Length: h.length,
+		Offset: h.offset,
+		Data:   data[:h.length],
+		CRC:    binary.BigEndian.Uint32(data[h.length:]),
+	}, nil
+}
+
+/*
+ChunkInfo describes a chunk of a PNG stream without holding its
+data.
+*/
+type ChunkInfo struct {
+	Type     string
+	Offset   int64 // offset of the chunk's length field
+	Length   uint32
+	CRC      uint32 // CRC as stored
+	CRCValid bool
+}
+
+/*
+Chunks returns a description of every chunk in rs, from IHDR
+to IEND, in the order they appear. Chunk data is streamed to
+check each CRC but isn't held in memory, so Chunks is suitable
+for large files. The offset of rs is left unspecified.
+*/
+func Chunks(rs io.ReadSeeker) ([]ChunkInfo, error) {
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]ChunkInfo, len(idx))
+	for i, h := range idx {
+		stored, actual, err := checkCRC(rs, h)
+		if err != nil {
+			return nil, err
+		}
+		infos[i] = ChunkInfo{
+			Type:     h.typ,
+			Offset:   h.offset,
+			Length:   h.length,
+			CRC:      stored,
+			CRCValid: stored == actual,
+		}
+	}
+	return infos, nil
+}
+
+/*
+VerifyCRC checks the CRC of every chunk in rs, returning one
+*CRCError for each chunk whose stored CRC doesn't match its
+contents, in the order they appear. Unlike Assert, which only
+inspects the head and tail of the stream, VerifyCRC reads all
+of it. err is non-nil only if rs couldn't be read as a PNG.
+*/
+func VerifyCRC(rs io.ReadSeeker) (bad []*CRCError, err error) {
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range idx {
+		stored, actual, err := checkCRC(rs, h)
+		if err != nil {
+			return nil, err
+		}
+		if stored != actual {
+			bad = append(bad, &CRCError{
+				Type:   h.typ,
+				Offset: h.offset,
+				Stored: stored,
+				Actual: actual,
+			})
+		}
+	}
+	return bad, nil
+}
+
+/*
+ChunkData returns the data of every chunk of type typ in rs
+concatenated in the order they appear, such as the compressed
+image data when typ is "IDAT", along with the offset of each
+chunk's length field. An error wrapping ErrNoChunk is returned
+if rs has no chunks of type typ.
+*/
+func ChunkData(rs io.ReadSeeker, typ string) (data []byte, offsets []int64, err error) {
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, h := range idx {
+		if h.typ != typ {
+			continue
+		}
+		if int64(len(data))+int64(h.length) > int64(maxInt) {
+			return nil, nil, fmt.Errorf("%w: %s chunks are too large to hold in memory", ErrLimitExceeded, typ)
+		}
+		p, err := readChunkData(rs, h)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = append(data, p...)
+		offsets = append(offsets, h.offset)
+	}
+	if offsets == nil {
+		return nil, nil, fmt.Errorf("%w: no %s chunk", ErrNoChunk, typ)
+	}
+	return data, offsets, nil
+}
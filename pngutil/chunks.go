@@ -0,0 +1,363 @@
+package pngutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Size of the buffer scanChunks reads chunk headers through.
+const scanBufferSize = 32 * 1024
+
+// chunkHeader locates a single chunk within a PNG stream.
+type chunkHeader struct {
+	offset int64  // offset of the chunk's length field
+	length uint32 // length of the chunk's data
+	typ    string
+}
+
+// dataOffset returns the offset of the first byte of the chunk's data.
+func (h chunkHeader) dataOffset() int64 {
+	return h.offset + 8
+}
+
+// end returns the offset immediately following the chunk's CRC.
+func (h chunkHeader) end() int64 {
+	return h.offset + 12 + int64(h.length)
+}
+
+/*
+scanChunks walks rs from the end of the PNG signature up to
+and including the IEND chunk, returning the location of every
+chunk. Chunk data is seeked over rather than read so the cost
+is proportional to the number of chunks, not the file size.
+
+Chunk headers are read through a buffer so that runs of small
+chunks, such as the many IDAT chunks some encoders write, cost
+a single read rather than a read and a seek each. Chunks not
+already in the buffer are seeked over rather than read.
+
+The scan stops early if ctx is cancelled or lim is exceeded.
+The offset of rs is left wherever the scan finished.
+*/
+func scanChunks(ctx context.Context, rs io.ReadSeeker, lim Limits) (idx []chunkHeader, err error) {
+
+	pos := int64(len(header))
+	if _, err = rs.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReaderSize(rs, scanBufferSize)
+	p := make([]byte, 8, 8)
+	for {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, err := io.ReadFull(br, p)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pngutil: couldn't read chunk header at offset %d: %w", pos, err)
+		}
+		if n != 8 {
+			return nil, errors.New("pngutil: couldn't read next chunk length and type")
+		}
+
+		h := chunkHeader{
+			offset: pos,
+			length: binary.BigEndian.Uint32(p[0:4]),
+			typ:    string(p[4:8]),
+		}
+		if h.length > maxChunkLength {
+			return nil, fmt.Errorf("pngutil: %s chunk at offset %d has invalid length %d", h.typ, pos, h.length)
+		}
+		if err = lim.checkChunkSize(h); err != nil {
+			return nil, err
+		}
+		idx = append(idx, h)
+		if err = lim.checkChunks(len(idx)); err != nil {
+			return nil, err
+		}
+		if h.typ == "IEND" {
+			break
+		}
+
+		/*
+			Skip data and CRC, discarding them if they're
+			already buffered, otherwise seeking the underlying
+			reader and starting afresh.
+		*/
+		pos = h.end()
+		skip := int64(h.length) + 4
+		if skip <= int64(br.Buffered()) {
+			if _, err = br.Discard(int(skip)); err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			continue
+		}
+		if _, err = rs.Seek(pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+		br.Reset(rs)
+	}
+
+	return idx, nil
+}
+
+/*
+ChunkCRC returns the CRC of a chunk of type typ holding data,
+which is computed over the type and data but not the length.
+*/
+func ChunkCRC(typ string, data []byte) uint32 {
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	return crc.Sum32()
+}
+
+/*
+AppendChunk appends a complete chunk of type typ holding data
+to dst, computing its length and CRC, and returns the extended
+slice. Neither typ nor the length of data are validated; data
+must be no longer than 2^31-1 bytes to be a valid chunk.
+*/
+func AppendChunk(dst []byte, typ string, data []byte) []byte {
+	start := len(dst)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(data)))
+	dst = append(dst, typ...)
+	dst = append(dst, data...)
+	return binary.BigEndian.AppendUint32(dst, crc32.ChecksumIEEE(dst[start+4:]))
+}
+
+/*
+VerifyChunkCRC checks that chunk is a single complete chunk,
+including its length and CRC, whose stored CRC is correct. A
+mismatch is reported as a *CRCError with an Offset of zero.
+*/
+func VerifyChunkCRC(chunk []byte) error {
+	if len(chunk) < 12 {
+		return fmt.Errorf("pngutil: chunk of %d bytes is too short", len(chunk))
+	}
+	length := binary.BigEndian.Uint32(chunk[0:4])
+	if uint64(length) != uint64(len(chunk)-12) {
+		return fmt.Errorf("pngutil: chunk has length %d but holds %d bytes of data", length, len(chunk)-12)
+	}
+	end := len(chunk) - 4
+	stored := binary.BigEndian.Uint32(chunk[end:])
+	if actual := crc32.ChecksumIEEE(chunk[4:end]); stored != actual {
+		return &CRCError{
+			Type:   string(chunk[4:8]),
+			Stored: stored,
+			Actual: actual,
+		}
+	}
+	return nil
+}
+
+// readChunkData reads the data of the chunk located by h.
+func readChunkData(rs io.ReadSeeker, h chunkHeader) ([]byte, error) {
+	if _, err := rs.Seek(h.dataOffset(), io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, h.length)
+	if _, err := io.ReadFull(rs, data); err != nil {
+		return nil, fmt.Errorf("pngutil: couldn't read %s chunk at offset %d: %w", h.typ, h.offset, err)
+	}
+	return data, nil
+}
+
+/*
+validChunkType reports whether typ is made of four ASCII
+letters, as chunk type codes are required to be.
+*/
+func validChunkType(typ string) bool {
+	if len(typ) != 4 {
+		return false
+	}
+	for i := 0; i < 4; i++ {
+		c := typ[i] | 0x20 // lower case
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+assembler builds a multiReadSeeker out of byte ranges of a
+source PNG and new data. Consecutive ranges of the source are
+coalesced into a single reader.
+*/
+type assembler struct {
+	src     io.ReadSeeker
+	readers []*skipReadSeeker
+	srcLast bool // whether the last reader is a range of src
+}
+
+func newAssembler(src io.ReadSeeker, capacity int) *assembler {
+	return &assembler{
+		src:     src,
+		readers: make([]*skipReadSeeker, 0, capacity),
+	}
+}
+
+// copyRange appends bytes start to end of the source.
+func (a *assembler) copyRange(start, end int64) {
+	if last := len(a.readers) - 1; a.srcLast && a.readers[last].end == start {
+		a.readers[last].end = end
+		return
+	}
+	a.readers = append(a.readers, &skipReadSeeker{
+		name:  "chunk",
+		rs:    a.src,
+		start: start,
+		end:   end,
+	})
+	a.srcLast = true
+}
+
+// copyChunk appends the chunk located by h.
+func (a *assembler) copyChunk(h chunkHeader) {
+	a.copyRange(h.offset, h.end())
+}
+
+// write appends p, which mustn't be modified afterwards.
+func (a *assembler) write(name string, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	a.readers = append(a.readers, &skipReadSeeker{
+		name: name,
+		rs:   bytes.NewReader(p),
+		end:  int64(len(p)),
+	})
+	a.srcLast = false
+}
+
+func (a *assembler) finish() (*multiReadSeeker, error) {
+	return newMultiReadSeeker(a.readers...)
+}
+
+/*
+indexPNG asserts that rs is a PNG and returns the location of
+its chunks.
+*/
+func indexPNG(rs io.ReadSeeker, opts Options) ([]chunkHeader, error) {
+	if err := AssertWithOptions(rs, opts); err != nil {
+		return nil, err
+	}
+	return scanChunks(opts.context(), rs, opts.limits())
+}
+
+/*
+replaceChunk returns f with every chunk of type typ removed and
+a new chunk of that type holding data written in place of the
+first of them. If f has no chunks of type typ the new chunk is
+written before the first chunk whose type is one of before,
+which should list the chunks the spec requires typ to precede.
+A nil data only removes the existing chunks.
+
+All other chunks are kept byte for byte.
+*/
+func replaceChunk(f io.ReadSeeker, typ string, data []byte, before ...string) (*multiReadSeeker, error) {
+
+	if len(data) > maxChunkLength {
+		return nil, fmt.Errorf("%w: %s chunk data of %d bytes is over maximum of %d", ErrLimitExceeded, typ, len(data), maxChunkLength)
+	}
+
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Find where the new chunk goes.
+	at := -1
+	for i, h := range idx {
+		if h.typ == typ {
+			at = i
+			break
+		}
+	}
+	for i := 1; at < 0 && i < len(idx); i++ {
+		for _, b := range before {
+			if idx[i].typ == b {
+				at = i
+				break
+			}
+		}
+	}
+	if at < 0 {
+		at = len(idx) - 1 // before IEND
+	}
+
+	a := newAssembler(f, len(idx)+2)
+	a.copyRange(0, idx[0].offset)
+	for i, h := range idx {
+		if i == at && data != nil {
+			a.write(typ, AppendChunk(nil, typ, data))
+		}
+		if h.typ != typ {
+			a.copyChunk(h)
+		}
+	}
+	return a.finish()
+}
+
+/*
+firstChunkData returns the data of the first chunk of type
+typ in rs, or nil if there isn't one.
+*/
+func firstChunkData(rs io.ReadSeeker, typ string) ([]byte, error) {
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range idx {
+		if h.typ == typ {
+			return readChunkData(rs, h)
+		}
+	}
+	return nil, nil
+}
+
+/*
+checkCRC computes the CRC of the chunk located by h, streaming
+its data rather than holding it, and returns it along with the
+CRC stored in rs.
+*/
+func checkCRC(rs io.ReadSeeker, h chunkHeader) (stored, actual uint32, err error) {
+	if _, err = rs.Seek(h.offset+4, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	crc := crc32.NewIEEE()
+	var p [4]byte
+	if _, err = io.CopyN(crc, rs, 4+int64(h.length)); err == nil {
+		_, err = io.ReadFull(rs, p[:])
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, fmt.Errorf("pngutil: %s chunk at offset %d is truncated: %w", h.typ, h.offset, err)
+	}
+	return binary.BigEndian.Uint32(p[:]), crc.Sum32(), nil
+}
+
+/*
+hashRange writes bytes start to end of rs to h, which is
+typically a CRC being computed over chunk data too large to
+hold in memory.
+*/
+func hashRange(h io.Writer, rs io.ReadSeeker, start, end int64) error {
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.CopyN(h, rs, end-start)
+	return err
+}
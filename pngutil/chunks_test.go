@@ -0,0 +1,658 @@
+package pngutil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChunkReader(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00x")), testChunk("prVt", []byte{1, 2, 3}))
+	cr, err := NewChunkReader(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var types []string
+	pos := int64(len(header))
+	for {
+		c, err := cr.NextChunk()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Offset != pos || int(c.Length) != len(c.Data) || c.CRC != ChunkCRC(c.Type, c.Data) {
+			t.Errorf("NextChunk: %s chunk has offset %d, length %d, CRC %08x; want offset %d", c.Type, c.Offset, c.Length, c.CRC, pos)
+		}
+		pos = c.Offset + 12 + int64(c.Length)
+		types = append(types, c.Type)
+	}
+	if len(types) < 5 || types[0] != "IHDR" || types[1] != "tEXt" || types[2] != "prVt" || types[len(types)-1] != "IEND" {
+		t.Errorf("NextChunk\n    have: %v\n    want: [IHDR tEXt prVt ... IEND]\n", types)
+	}
+
+	// A length overrunning the stream is an error, not the end.
+	bad := testPNG(t, []byte{0, 0, 0x10, 0, 'p', 'r', 'V', 't'})
+	cr, err = NewChunkReader(bytes.NewReader(bad))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, err = cr.NextChunk()
+	}
+	if errors.Is(err, io.EOF) {
+		t.Errorf("NextChunk on overrunning chunk\n    have: %v\n    want: unexpected EOF\n", err)
+	}
+}
+
+func TestChunkWriter(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00x")))
+	cr, err := NewChunkReader(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	cw := NewChunkWriter(&buf, true)
+	for {
+		c, err := cr.NextChunk()
+		if errors.Is(err, io.EOF) || c.Type == "IEND" {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = cw.WriteChunk(c.Type, c.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = cw.Close(); err != nil || !bytes.Equal(buf.Bytes(), in) {
+		t.Errorf("ChunkWriter didn't reproduce its input, err: %v", err)
+	}
+
+	cases := []struct {
+		types []string
+		err   bool
+	}{
+		{[]string{"IHDR", "gAMA", "PLTE", "tRNS", "IDAT", "IDAT", "tEXt", "IEND"}, false},
+		{[]string{"tEXt"}, true},
+		{[]string{"IHDR", "IHDR"}, true},
+		{[]string{"IHDR", "PLTE", "gAMA"}, true},
+		{[]string{"IHDR", "IDAT", "PLTE"}, true},
+		{[]string{"IHDR", "IDAT", "tRNS"}, true},
+		{[]string{"IHDR", "IDAT", "tEXt", "IDAT"}, true},
+		{[]string{"IHDR", "hIST"}, true},
+		{[]string{"IHDR", "IEND"}, true},
+		{[]string{"IHDR", "IDAT", "IEND", "tEXt"}, true},
+	}
+	for _, c := range cases {
+		cw := NewChunkWriter(io.Discard, true)
+		var err error
+		for _, typ := range c.types {
+			if err = cw.WriteChunk(typ, nil); err != nil {
+				break
+			}
+		}
+		if (err != nil) != c.err {
+			t.Errorf("ChunkWriter(%v)\n    have err: %v\n    want err: %t\n", c.types, err, c.err)
+		}
+	}
+	if err = NewChunkWriter(io.Discard, false).WriteChunk("ab1c", nil); err == nil {
+		t.Errorf("WriteChunk accepted invalid chunk type %q", "ab1c")
+	}
+}
+
+func TestChunks(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00x")), testChunk("prVt", []byte{1, 2, 3}))
+	at := int64(len(header)) + 25 + 12 + 7 // the prVt chunk
+	in[at+8] ^= 0xff
+
+	infos, err := Chunks(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ChunkInfo{
+		{"IHDR", 8, 13, ChunkCRC("IHDR", in[16:29]), true},
+		{"tEXt", 33, 7, ChunkCRC("tEXt", []byte("Title\x00x")), true},
+		{"prVt", at, 3, ChunkCRC("prVt", []byte{1, 2, 3}), false},
+	}
+	if len(infos) < 5 || infos[len(infos)-1].Type != "IEND" {
+		t.Fatalf("Chunks returned %d chunks: %v", len(infos), infos)
+	}
+	for i, w := range want {
+		if infos[i] != w {
+			t.Errorf("Chunks[%d]\n    have: %+v\n    want: %+v\n", i, infos[i], w)
+		}
+	}
+}
+
+func TestRemoveChunks(t *testing.T) {
+
+	in := testPNG(t,
+		testChunk("iCCP", []byte("p\x00\x00")),
+		testChunk("tEXt", []byte("Title\x00x")),
+		testChunk("tIME", make([]byte, 7)),
+	)
+	want := testPNG(t, testChunk("tEXt", []byte("Title\x00x")))
+
+	mrs, err := RemoveChunks(bytes.NewReader(in), "iCCP", "tIME", "sRGB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := io.ReadAll(mrs); !bytes.Equal(have, want) {
+		t.Errorf("RemoveChunks\n    have: %x\n    want: %x\n", have, want)
+	}
+	for _, typ := range []string{"IDAT", "PLTE", "bad!"} {
+		if _, err = RemoveChunks(bytes.NewReader(in), typ); err == nil {
+			t.Errorf("RemoveChunks(%q) succeeded, want error", typ)
+		}
+	}
+}
+
+func TestInsertChunk(t *testing.T) {
+
+	in := testPNG(t)
+	chunk := testChunk("prVt", []byte{1, 2, 3})
+
+	cases := []struct {
+		at   Anchor
+		want []string
+		err  bool
+	}{
+		{Anchor{"IHDR", true}, []string{"IHDR", "prVt", "IDAT", "IEND"}, false},
+		{Anchor{"IDAT", false}, []string{"IHDR", "prVt", "IDAT", "IEND"}, false},
+		{Anchor{"IDAT", true}, []string{"IHDR", "IDAT", "prVt", "IEND"}, false},
+		{Anchor{"IEND", false}, []string{"IHDR", "IDAT", "prVt", "IEND"}, false},
+		{Anchor{"IEND", true}, nil, true},
+		{Anchor{"IHDR", false}, nil, true},
+		{Anchor{"PLTE", false}, nil, true},
+	}
+
+	for _, c := range cases {
+		mrs, err := InsertChunk(bytes.NewReader(in), "prVt", []byte{1, 2, 3}, c.at)
+		if (err != nil) != c.err {
+			t.Errorf("InsertChunk(%+v)\n    have err: %v\n    want err: %t\n", c.at, err, c.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		out, _ := io.ReadAll(mrs)
+		types := chunkTypes(t, bytes.NewReader(out))
+		if !reflect.DeepEqual(types, c.want) || !bytes.Contains(out, chunk) {
+			t.Errorf("InsertChunk(%+v)\n    have: %v\n    want: %v\n", c.at, types, c.want)
+		}
+	}
+}
+
+func TestVerifyCRC(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00x")), testChunk("prVt", []byte{1, 2, 3}))
+	bad, err := VerifyCRC(bytes.NewReader(in))
+	if err != nil || len(bad) != 0 {
+		t.Errorf("VerifyCRC on valid PNG\n    have: %v, err: %v\n    want: none\n", bad, err)
+	}
+
+	in[len(header)+8] ^= 0xff       // IHDR data
+	in[len(header)+25+12+5] ^= 0xff // tEXt data
+	bad, err = VerifyCRC(bytes.NewReader(in))
+	if err != nil || len(bad) != 2 {
+		t.Fatalf("VerifyCRC\n    have: %v, err: %v\n    want: 2 errors\n", bad, err)
+	}
+	if bad[0].Type != "IHDR" || bad[0].Offset != 8 || bad[1].Type != "tEXt" || bad[1].Offset != 33 {
+		t.Errorf("VerifyCRC\n    have: %v\n    want: IHDR at 8, tEXt at 33\n", bad)
+	}
+}
+
+func TestRepairCRC(t *testing.T) {
+
+	want := testPNG(t, testChunk("tEXt", []byte("Title\x00x")), testChunk("prVt", []byte{1, 2, 3}))
+	in := append([]byte{}, want...)
+	in[len(in)-16] ^= 0xff // IDAT CRC
+	in[47] = 'y'           // tEXt data
+	want[47] = 'y'
+	binary.BigEndian.PutUint32(want[48:], ChunkCRC("tEXt", []byte("Title\x00y")))
+
+	mrs, fixed, err := RepairCRC(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := io.ReadAll(mrs); !bytes.Equal(have, want) {
+		t.Errorf("RepairCRC\n    have: %x\n    want: %x\n", have, want)
+	}
+	if len(fixed) != 2 || fixed[0].Type != "tEXt" || fixed[1].Type != "IDAT" {
+		t.Errorf("RepairCRC\n    have fixed: %v\n    want: tEXt, IDAT\n", fixed)
+	}
+}
+
+func TestWalkChunks(t *testing.T) {
+
+	in := testPNG(t,
+		testChunk("tEXt", []byte("Title\x00x")),
+		testChunk("prVt", []byte{1, 2, 3}),
+		testChunk("tEXt", []byte("Author\x00y")),
+	)
+	want := testPNG(t, testChunk("tEXt", []byte("Title\x00x")))
+
+	var seen []string
+	mrs, err := WalkChunks(bytes.NewReader(in), func(c Chunk) (bool, error) {
+		seen = append(seen, c.Type)
+		return c.Type != "prVt" && !bytes.HasPrefix(c.Data, []byte("Author")), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := io.ReadAll(mrs); !bytes.Equal(have, want) {
+		t.Errorf("WalkChunks\n    have: %x\n    want: %x\n", have, want)
+	}
+	if len(seen) != 6 || seen[0] != "IHDR" || seen[5] != "IEND" {
+		t.Errorf("WalkChunks visited %v", seen)
+	}
+
+	stop := errors.New("stop")
+	_, err = WalkChunks(bytes.NewReader(in), func(c Chunk) (bool, error) {
+		return false, stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("WalkChunks\n    have err: %v\n    want err: %v\n", err, stop)
+	}
+}
+
+func TestChunkData(t *testing.T) {
+
+	in := testPNG(t,
+		testChunk("prVt", []byte{1, 2}),
+		testChunk("tEXt", []byte("Title\x00x")),
+		testChunk("prVt", []byte{3}),
+	)
+	data, offsets, err := ChunkData(bytes.NewReader(in), "prVt")
+	if err != nil || !bytes.Equal(data, []byte{1, 2, 3}) || !reflect.DeepEqual(offsets, []int64{33, 66}) {
+		t.Errorf("ChunkData\n    have: %v, %v, err: %v\n    want: [1 2 3], [33 66]\n", data, offsets, err)
+	}
+	if _, _, err = ChunkData(bytes.NewReader(in), "iCCP"); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("ChunkData on missing chunk\n    have err: %v\n    want err: %v\n", err, ErrNoChunk)
+	}
+}
+
+func TestValidateOrder(t *testing.T) {
+
+	if err := ValidateOrder(bytes.NewReader(testPNG(t, testChunk("gAMA", make([]byte, 4))))); err != nil {
+		t.Errorf("ValidateOrder on valid PNG: %v", err)
+	}
+
+	// The test image has a single IDAT chunk directly before IEND.
+	in := testPNG(t, testChunk("tRNS", []byte{0, 0}), testChunk("PLTE", make([]byte, 3)))
+	end := len(in) - 12
+	in = append(append(in[:end:end], testChunk("gAMA", make([]byte, 4))...), in[end:]...)
+
+	err := ValidateOrder(bytes.NewReader(in))
+	var errs []*ChunkError
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var ce *ChunkError
+		if errors.As(e, &ce) {
+			errs = append(errs, ce)
+		}
+	}
+	if len(errs) != 2 || errs[0].Type != "PLTE" || errs[0].Offset != 47 || errs[1].Type != "gAMA" || errs[1].Offset != int64(end) {
+		t.Errorf("ValidateOrder\n    have: %v\n    want: PLTE at 47 and gAMA at %d\n", err, end)
+	}
+}
+
+func TestValidateOrderDuplicates(t *testing.T) {
+
+	gama := testChunk("gAMA", make([]byte, 4))
+	text := testChunk("tEXt", []byte("Title\x00x"))
+	in := testPNG(t, gama, text, text, testChunk("tIME", make([]byte, 7)), gama, testChunk("tIME", make([]byte, 7)))
+
+	err := ValidateOrder(bytes.NewReader(in))
+	var have []string
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			var ce *ChunkError
+			if errors.As(e, &ce) {
+				have = append(have, fmt.Sprintf("%s@%d", ce.Type, ce.Offset))
+			}
+		}
+	}
+	// Offsets follow IHDR, gAMA, two tEXt and tIME.
+	want := []string{"gAMA@" + fmt.Sprint(33+16+19+19+19), "tIME@" + fmt.Sprint(33+16+19+19+19+16)}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("ValidateOrder\n    have: %v\n    want: %v\n", err, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	// The test image has a single IDAT chunk directly before IEND.
+	in := testPNG(t)
+	end := len(in) - 12
+	corrupt := append([]byte{}, in...)
+	corrupt[end-5]++
+	badIHDR := append([]byte{}, in...)
+	copy(badIHDR[8:33], testChunk("IHDR", []byte{0, 0, 0, 4, 0, 0, 0, 4, 3, 6, 0, 0, 0}))
+	split := append(append([]byte{}, in[:end]...), testChunk("tEXt", []byte("Title\x00x"))...)
+	split = append(append(split, testChunk("IDAT", nil)...), in[end:]...)
+
+	cases := []struct {
+		name string
+		in   []byte
+		errs []string
+	}{
+		{"valid", in, nil},
+		{"signature", in[1:], []string{"missing PNG signature"}},
+		{"crc", corrupt, []string{"CRC mismatch in IDAT"}},
+		{"ihdr", badIHDR, []string{"bit depth 3"}},
+		{"idat", split, []string{"IDAT chunk at offset " + fmt.Sprint(end+19) + ": isn't consecutive"}},
+		{"trailing", append(append([]byte{}, in...), "junk"...), []string{"data after IEND"}},
+		{"truncated", in[:end-1], []string{"runs past end", "missing IEND"}},
+		{"type", append(append(append([]byte{}, in[:33]...), testChunk("t*Xt", nil)...), in[33:]...), []string{"invalid chunk type"}},
+	}
+	for _, c := range cases {
+		err := Validate(bytes.NewReader(c.in))
+		var have []error
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			have = joined.Unwrap()
+		} else if err != nil {
+			have = []error{err}
+		}
+		if len(have) != len(c.errs) {
+			t.Errorf("Validate(%s)\n    have: %v\n    want: %q\n", c.name, err, c.errs)
+			continue
+		}
+		for i, e := range have {
+			if !strings.Contains(e.Error(), c.errs[i]) {
+				t.Errorf("Validate(%s)\n    have: %v\n    want: %q\n", c.name, e, c.errs[i])
+			}
+		}
+	}
+
+	var ce *CRCError
+	if err := Validate(bytes.NewReader(corrupt)); !errors.As(err, &ce) || ce.Offset != int64(33) {
+		t.Errorf("Validate(crc)\n    have: %v\n    want: *CRCError at offset 33\n", err)
+	}
+	var chunkErr *ChunkError
+	if err := Validate(bytes.NewReader(badIHDR)); !errors.As(err, &chunkErr) || chunkErr.Type != "IHDR" || chunkErr.Offset != int64(len(header)) {
+		t.Errorf("Validate(ihdr)\n    have: %v\n    want: *ChunkError in IHDR at offset %d\n", err, len(header))
+	}
+}
+
+func TestChunkType(t *testing.T) {
+
+	cases := []struct {
+		typ                                      ChunkType
+		ancillary, private, reserved, safeToCopy bool
+	}{
+		{"IDAT", false, false, false, false},
+		{"tEXt", true, false, false, true},
+		{"gAMA", true, false, false, false},
+		{"prVt", true, true, false, true},
+		{"abcd", true, true, true, true},
+		{"ab1d", false, false, false, false},
+		{"IDA", false, false, false, false},
+	}
+	for _, c := range cases {
+		have := [4]bool{c.typ.Ancillary(), c.typ.Private(), c.typ.Reserved(), c.typ.SafeToCopy()}
+		want := [4]bool{c.ancillary, c.private, c.reserved, c.safeToCopy}
+		if have != want {
+			t.Errorf("ChunkType(%q) bits\n    have: %v\n    want: %v\n", c.typ, have, want)
+		}
+	}
+}
+
+func TestPrivateChunk(t *testing.T) {
+
+	mrs, err := SetPrivateChunk(bytes.NewReader(testPNG(t)), "prVt", []byte("engine state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(mrs)
+	if data, err := PrivateChunk(bytes.NewReader(b), "prVt"); err != nil || string(data) != "engine state" {
+		t.Errorf("PrivateChunk\n    have: %q, err: %v\n    want: %q\n", data, err, "engine state")
+	}
+	if _, err = PrivateChunk(bytes.NewReader(b), "edIt"); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("PrivateChunk on missing chunk\n    have err: %v\n    want err: %v\n", err, ErrNoChunk)
+	}
+	for _, typ := range []string{"tEXt", "IDAT", "prvt"} {
+		if _, err = SetPrivateChunk(bytes.NewReader(b), typ, nil); err == nil {
+			t.Errorf("SetPrivateChunk(%q) succeeded, want error", typ)
+		}
+	}
+}
+
+func TestMergeIDAT(t *testing.T) {
+
+	want := testPNG(t, testChunk("tEXt", []byte("Title\x00x")))
+	idat, _, err := ChunkData(bytes.NewReader(want), "IDAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Split the image data across three chunks, preceded by an iDOT.
+	var split bytes.Buffer
+	cw := NewChunkWriter(&split, true)
+	cw.WriteChunk("IHDR", want[16:29])
+	cw.WriteChunk("tEXt", []byte("Title\x00x"))
+	cw.WriteChunk("iDOT", make([]byte, 28))
+	cw.WriteChunk("IDAT", idat[:1])
+	cw.WriteChunk("IDAT", idat[1:5])
+	cw.WriteChunk("IDAT", idat[5:])
+	if err = cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, in := range [][]byte{split.Bytes(), want} {
+		mrs, err := MergeIDAT(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, _ := io.ReadAll(mrs); !bytes.Equal(have, want) {
+			t.Errorf("MergeIDAT\n    have: %x\n    want: %x\n", have, want)
+		}
+	}
+
+	// Corrupt the data of the middle IDAT chunk, leaving its CRC.
+	bad := append([]byte{}, split.Bytes()...)
+	mid := bytes.Index(bad, append([]byte("IDAT"), idat[1:5]...))
+	bad[mid+4] ^= 0xff
+	var crcErr *CRCError
+	if _, err = MergeIDAT(bytes.NewReader(bad)); !errors.As(err, &crcErr) || crcErr.Offset != int64(mid-4) {
+		t.Errorf("MergeIDAT(corrupt IDAT)\n    have err: %v\n    want: CRCError at offset %d\n", err, mid-4)
+	}
+}
+
+func TestTrimTrailingData(t *testing.T) {
+
+	in := testPNG(t)
+	cases := []struct {
+		in      []byte
+		trimmed int64
+		err     bool
+	}{
+		{in, 0, false},
+		{append(append([]byte{}, in...), "payload"...), 7, false},
+		{append(append([]byte{}, in...), in...), int64(len(in)), false},
+		{in[:len(in)-12], 0, true},
+		{in[1:], 0, true},
+	}
+	for i, c := range cases {
+		end, n, err := TrailingData(bytes.NewReader(c.in))
+		if (err != nil) != c.err {
+			t.Errorf("TrailingData(case %d): unexpected error %v", i, err)
+			continue
+		}
+		if c.err {
+			continue
+		}
+		if end != int64(len(in)) || n != c.trimmed {
+			t.Errorf("TrailingData(case %d)\n    have: %d, %d\n    want: %d, %d\n", i, end, n, len(in), c.trimmed)
+		}
+		mrs, trimmed, err := TrimTrailingData(bytes.NewReader(c.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, err := io.ReadAll(mrs); err != nil || !bytes.Equal(have, in) || trimmed != c.trimmed {
+			t.Errorf("TrimTrailingData(case %d): trimmed %d bytes, output matches input: %t, err: %v", i, trimmed, bytes.Equal(have, in), err)
+		}
+	}
+}
+
+func TestValidateColor(t *testing.T) {
+
+	indexed := palettedPNG(t, 4)
+	gray := encodePNG(t, image.NewGray(image.Rect(0, 0, 4, 4)))
+	infos, err := Chunks(bytes.NewReader(indexed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plte ChunkInfo
+	for _, c := range infos {
+		if c.Type == "PLTE" {
+			plte = c
+		}
+	}
+	withPLTE := func(data []byte) []byte {
+		out := append([]byte{}, indexed[:plte.Offset]...)
+		if data != nil {
+			out = append(out, testChunk("PLTE", data)...)
+		}
+		return append(out, indexed[plte.Offset+12+int64(plte.Length):]...)
+	}
+	insert := func(in []byte, typ string, data []byte) []byte {
+		mrs, err := InsertChunk(bytes.NewReader(in), typ, data, Anchor{"IDAT", false})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := io.ReadAll(mrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	cases := []struct {
+		name string
+		in   []byte
+		err  string
+	}{
+		{"indexed", indexed, ""},
+		{"truecolour with PLTE", testPNG(t, testChunk("PLTE", make([]byte, 6))), ""},
+		{"indexed with tRNS", insert(indexed, "tRNS", []byte{0, 128}), ""},
+		{"no PLTE", withPLTE(nil), "indexed image has no PLTE chunk"},
+		{"PLTE length", withPLTE(make([]byte, 10)), "PLTE chunk has length 10"},
+		{"PLTE entries", withPLTE(make([]byte, 15)), "5 entries, more than bit depth 2"},
+		{"greyscale with PLTE", insert(gray, "PLTE", make([]byte, 3)), "PLTE chunk isn't permitted for colour type 0"},
+		{"tRNS entries", insert(indexed, "tRNS", make([]byte, 5)), "tRNS chunk has 5 entries but palette has 4"},
+		{"tRNS colour", insert(gray, "tRNS", make([]byte, 6)), "tRNS chunk has length 6, want 2"},
+	}
+	for _, c := range cases {
+		err := Validate(bytes.NewReader(c.in))
+		if (err == nil) != (c.err == "") || (err != nil && !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("Validate(%s)\n    have: %v\n    want: %q\n", c.name, err, c.err)
+		}
+	}
+}
+
+/*
+idatPNG returns in, which must have a single IDAT chunk directly
+before IEND, with its image data replaced by raw compressed.
+*/
+func idatPNG(t *testing.T, in []byte, raw []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := Chunks(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idat := infos[len(infos)-2].Offset
+	out := append(append([]byte{}, in[:idat]...), testChunk("IDAT", buf.Bytes())...)
+	return append(out, in[len(in)-12:]...)
+}
+
+func TestVerifyImageData(t *testing.T) {
+
+	// The test image is 4x4 RGBA, so 4 rows of a filter byte and 16 bytes.
+	in := testPNG(t)
+	truncated := idatPNG(t, in, make([]byte, 68))
+	end := len(truncated) - 12
+	truncated = append(append([]byte{}, truncated[:33]...), testChunk("IDAT", truncated[41:end-8])...)
+	truncated = append(truncated, in[len(in)-12:]...)
+
+	cases := []struct {
+		name string
+		in   []byte
+		err  string
+	}{
+		{"valid", in, ""},
+		{"recompressed", idatPNG(t, in, make([]byte, 68)), ""},
+		{"short", idatPNG(t, in, make([]byte, 67)), "inflates to 67 bytes, want 68"},
+		{"long", idatPNG(t, in, make([]byte, 69)), "more than the 68 bytes"},
+		{"truncated", truncated, "image data is corrupt"},
+	}
+	for _, c := range cases {
+		err := VerifyImageData(bytes.NewReader(c.in))
+		if (err == nil) != (c.err == "") || (err != nil && !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("VerifyImageData(%s)\n    have: %v\n    want: %q\n", c.name, err, c.err)
+		}
+	}
+}
+
+func TestValidateReport(t *testing.T) {
+
+	// The tEXt chunk directly follows IHDR, and IDAT directly precedes IEND.
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00x")))
+	in[33+8]++
+	in = append(in, "junk"...)
+	end := len(in) - 4 - 12
+
+	type finding struct {
+		Severity Severity
+		Type     string
+		Offset   int64
+	}
+	report := func(in []byte) (have []finding, ok bool) {
+		r, err := ValidateReport(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range r.Findings {
+			if f.Message != f.Err.Error() {
+				t.Errorf("ValidateReport: finding message %q doesn't match its error %q", f.Message, f.Err)
+			}
+			have = append(have, finding{f.Severity, f.Type, f.Offset})
+		}
+		return have, r.OK()
+	}
+
+	have, ok := report(in)
+	want := []finding{{SeverityWarning, "tEXt", 33}, {SeverityWarning, "", -1}}
+	if !reflect.DeepEqual(have, want) || !ok {
+		t.Errorf("ValidateReport\n    have: %v, ok: %t\n    want: %v, ok: true\n", have, ok, want)
+	}
+
+	in[end-5]++
+	have, ok = report(in)
+	if len(have) != 3 || have[1].Severity != SeverityError || have[1].Type != "IDAT" || ok {
+		t.Errorf("ValidateReport(corrupt IDAT)\n    have: %v, ok: %t\n    want: an IDAT error, ok: false\n", have, ok)
+	}
+	if SeverityWarning.String() != "warning" || SeverityError.String() != "error" {
+		t.Errorf("Severity.String: have %q and %q", SeverityWarning, SeverityError)
+	}
+}
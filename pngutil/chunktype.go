@@ -0,0 +1,105 @@
+package pngutil
+
+import (
+	"fmt"
+	"io"
+)
+
+/*
+ChunkType is a four letter chunk type code, such as "IDAT" or
+"prVt". The case of each letter carries one of the chunk's
+property bits, which ChunkType's methods report. Each reports
+false if the type isn't valid.
+*/
+type ChunkType string
+
+// Valid reports whether t is made of four ASCII letters.
+func (t ChunkType) Valid() bool {
+	return validChunkType(string(t))
+}
+
+/*
+Ancillary reports whether t is an ancillary chunk, which a
+decoder may ignore, rather than a critical one.
+*/
+func (t ChunkType) Ancillary() bool {
+	return t.bit(0)
+}
+
+/*
+Private reports whether t is a private chunk type rather than
+one defined by the spec or registered for public use.
+*/
+func (t ChunkType) Private() bool {
+	return t.bit(1)
+}
+
+/*
+Reserved reports whether t's reserved bit is set. It must not
+be in chunks conforming to the current spec.
+*/
+func (t ChunkType) Reserved() bool {
+	return t.bit(2)
+}
+
+/*
+SafeToCopy reports whether an editor unaware of t may copy
+chunks of that type into a modified file regardless of the
+changes it made.
+*/
+func (t ChunkType) SafeToCopy() bool {
+	return t.bit(3)
+}
+
+// bit reports whether letter i of t is lower case.
+func (t ChunkType) bit(i int) bool {
+	return t.Valid() && t[i]&0x20 != 0
+}
+
+/*
+validPrivateType returns an error unless typ is a valid chunk
+type which is ancillary, private and not reserved.
+*/
+func validPrivateType(typ string) error {
+	t := ChunkType(typ)
+	if !t.Valid() {
+		return fmt.Errorf("pngutil: invalid chunk type %q", typ)
+	}
+	if !t.Ancillary() || !t.Private() || t.Reserved() {
+		return fmt.Errorf("pngutil: chunk type %q isn't ancillary and private", typ)
+	}
+	return nil
+}
+
+/*
+SetPrivateChunk returns f with data stored as is in a chunk of
+type typ, which must be ancillary and private, such as "prVt",
+replacing any existing chunks of that type. The chunk is placed
+as StoreBlob places it. Use StoreBlob instead to have data
+compressed.
+*/
+func SetPrivateChunk(f io.ReadSeeker, typ string, data []byte) (*multiReadSeeker, error) {
+	if err := validPrivateType(typ); err != nil {
+		return nil, err
+	}
+	return replaceChunk(f, typ, data)
+}
+
+/*
+PrivateChunk returns the data of the first chunk of type typ in
+rs, which must be ancillary and private. It returns an error
+wrapping ErrNoChunk if there's no such chunk.
+*/
+func PrivateChunk(rs io.ReadSeeker, typ string) ([]byte, error) {
+	if err := validPrivateType(typ); err != nil {
+		return nil, err
+	}
+	data, err := firstChunkData(rs, typ)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%w: no %s chunk", ErrNoChunk, typ)
+	}
+	return data, nil
+}
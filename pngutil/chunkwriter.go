@@ -0,0 +1,74 @@
+package pngutil
+
+import (
+	"fmt"
+	"io"
+)
+
+/*
+ChunkWriter writes a PNG stream chunk by chunk, computing the
+length and CRC of each. The PNG signature is written before the
+first chunk.
+*/
+type ChunkWriter struct {
+	w      io.Writer
+	strict bool
+	order  orderState
+	offset int64 // bytes written
+	ended  bool
+}
+
+/*
+NewChunkWriter returns a ChunkWriter writing to w. If strict is
+true, chunks written out of the order the spec requires, such
+as a PLTE chunk after IDAT or a chunk before IHDR, are rejected
+with a *ChunkError without being written.
+*/
+func NewChunkWriter(w io.Writer, strict bool) *ChunkWriter {
+	return &ChunkWriter{w: w, strict: strict}
+}
+
+/*
+WriteChunk writes a chunk of type typ holding data, which
+excludes the chunk's length, type and CRC.
+*/
+func (cw *ChunkWriter) WriteChunk(typ string, data []byte) error {
+
+	if !validChunkType(typ) {
+		return fmt.Errorf("pngutil: invalid chunk type %q", typ)
+	}
+	if len(data) > maxChunkLength {
+		return fmt.Errorf("%w: %s chunk data of %d bytes is over maximum of %d", ErrLimitExceeded, typ, len(data), maxChunkLength)
+	}
+
+	var p []byte
+	if cw.offset == 0 {
+		p = append(p, header...)
+	}
+	if cw.strict {
+		if err := cw.order.next(typ); err != nil {
+			return &ChunkError{Type: typ, Offset: cw.offset + int64(len(p)), Err: err}
+		}
+	}
+
+	p = AppendChunk(p, typ, data)
+	n, err := cw.w.Write(p)
+	cw.offset += int64(n)
+	if err != nil {
+		return err
+	}
+	cw.ended = cw.ended || typ == "IEND"
+	return nil
+}
+
+/*
+Close writes an IEND chunk if one hasn't been written, which if
+strict is set fails unless IDAT has been. It doesn't close the
+underlying writer.
+*/
+func (cw *ChunkWriter) Close() error {
+	if cw.ended {
+		return nil
+	}
+	return cw.WriteChunk("IEND", nil)
+}
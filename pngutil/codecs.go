@@ -0,0 +1,173 @@
+package pngutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+func init() {
+	for typ, newValue := range map[string]func() ChunkUnmarshaler{
+		"cHRM": func() ChunkUnmarshaler { return new(Chromaticities) },
+		"cICP": func() ChunkUnmarshaler { return new(CICP) },
+		"cLLI": func() ChunkUnmarshaler { return new(ContentLightLevel) },
+		"gAMA": func() ChunkUnmarshaler { return new(Gamma) },
+		"hIST": func() ChunkUnmarshaler { return new(Histogram) },
+		"iCCP": func() ChunkUnmarshaler { return new(ICCProfile) },
+		"mDCV": func() ChunkUnmarshaler { return new(MasteringDisplay) },
+		"oFFs": func() ChunkUnmarshaler { return new(ImageOffset) },
+		"pCAL": func() ChunkUnmarshaler { return new(PixelCalibration) },
+		"pHYs": func() ChunkUnmarshaler { return new(PhysicalDims) },
+		"sBIT": func() ChunkUnmarshaler { return new(SignificantBits) },
+		"sCAL": func() ChunkUnmarshaler { return new(PhysicalScale) },
+		"sPLT": func() ChunkUnmarshaler { return new(SuggestedPalette) },
+		"sRGB": func() ChunkUnmarshaler { return new(RenderingIntent) },
+		"sTER": func() ChunkUnmarshaler { return new(StereoMode) },
+		"tIME": func() ChunkUnmarshaler { return new(ModTime) },
+		"tRNS": func() ChunkUnmarshaler { return new(Transparency) },
+	} {
+		if err := RegisterChunk(typ, CodecHandler(newValue), false); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Units for PhysicalDims.
+const (
+	UnitUnknown uint8 = 0 // only the aspect ratio is meaningful
+	UnitMetre   uint8 = 1 // pixels per metre
+)
+
+/*
+PhysicalDims represents a pHYs chunk, which gives the intended
+pixel size or aspect ratio of the image.
+*/
+type PhysicalDims struct {
+	X    uint32 // pixels per unit along the x axis
+	Y    uint32 // pixels per unit along the y axis
+	Unit uint8  // UnitUnknown or UnitMetre
+}
+
+func (p PhysicalDims) ChunkType() string {
+	return "pHYs"
+}
+
+func (p PhysicalDims) MarshalChunk() ([]byte, error) {
+	if p.Unit > UnitMetre {
+		return nil, fmt.Errorf("pngutil: invalid pHYs unit %d", p.Unit)
+	}
+	data := make([]byte, 9)
+	binary.BigEndian.PutUint32(data[0:4], p.X)
+	binary.BigEndian.PutUint32(data[4:8], p.Y)
+	data[8] = p.Unit
+	return data, nil
+}
+
+func (p *PhysicalDims) UnmarshalChunk(data []byte) error {
+	if len(data) != 9 {
+		return fmt.Errorf("pngutil: pHYs chunk has length %d, want 9", len(data))
+	}
+	p.X = binary.BigEndian.Uint32(data[0:4])
+	p.Y = binary.BigEndian.Uint32(data[4:8])
+	p.Unit = data[8]
+	return nil
+}
+
+/*
+ModTime represents a tIME chunk, which records when the image
+was last modified. The spec requires the time to be in UTC so
+Time is converted to UTC when marshalled.
+*/
+type ModTime struct {
+	Time time.Time
+}
+
+func (m ModTime) ChunkType() string {
+	return "tIME"
+}
+
+func (m ModTime) MarshalChunk() ([]byte, error) {
+	t := m.Time.UTC()
+	if t.Year() < 0 || t.Year() > 0xFFFF {
+		return nil, fmt.Errorf("pngutil: year %d out of range for tIME chunk", t.Year())
+	}
+	data := make([]byte, 7)
+	binary.BigEndian.PutUint16(data[0:2], uint16(t.Year()))
+	data[2] = byte(t.Month())
+	data[3] = byte(t.Day())
+	data[4] = byte(t.Hour())
+	data[5] = byte(t.Minute())
+	data[6] = byte(t.Second())
+	return data, nil
+}
+
+func (m *ModTime) UnmarshalChunk(data []byte) error {
+	if len(data) != 7 {
+		return fmt.Errorf("pngutil: tIME chunk has length %d, want 7", len(data))
+	}
+	year := int(binary.BigEndian.Uint16(data[0:2]))
+	month, day, hour, min, sec := data[2], data[3], data[4], data[5], data[6]
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || min > 59 || sec > 60 {
+		return fmt.Errorf("pngutil: invalid tIME chunk %d-%d-%d %d:%d:%d", year, month, day, hour, min, sec)
+	}
+	m.Time = time.Date(year, time.Month(month), int(day), int(hour), int(min), int(sec), 0, time.UTC)
+	return nil
+}
+
+/*
+SetModTime returns f with its tIME chunk set to t, which is
+converted to UTC and truncated to the second. An existing tIME
+chunk is replaced in place, otherwise one is added before IEND.
+All other chunks are kept byte for byte.
+
+ReplaceMeta discards tIME by default, so to keep the time across
+a rewrite either pass a Policy keeping "tIME" or call SetModTime
+on the result.
+*/
+func SetModTime(f io.ReadSeeker, t time.Time) (*multiReadSeeker, error) {
+	return setChunk(f, ModTime{t})
+}
+
+/*
+GetModTime returns the time recorded in the tIME chunk of rs,
+in UTC, or ErrNoChunk if it has none.
+*/
+func GetModTime(rs io.ReadSeeker) (time.Time, error) {
+	var m ModTime
+	if err := getChunk(rs, &m); err != nil {
+		return time.Time{}, err
+	}
+	return m.Time, nil
+}
+
+/*
+setChunk returns f with a chunk marshalled from m in place of
+any chunks of its type, as replaceChunk does.
+*/
+func setChunk(f io.ReadSeeker, m ChunkMarshaler, before ...string) (*multiReadSeeker, error) {
+	data, err := m.MarshalChunk()
+	if err != nil {
+		return nil, err
+	}
+	return replaceChunk(f, m.ChunkType(), data, before...)
+}
+
+/*
+getChunk unmarshals the first chunk of v's type in rs into v,
+returning an error wrapping ErrNoChunk if rs has none.
+*/
+func getChunk(rs io.ReadSeeker, v interface {
+	ChunkMarshaler
+	ChunkUnmarshaler
+}) error {
+	typ := v.ChunkType()
+	data, err := firstChunkData(rs, typ)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return fmt.Errorf("%w: no %s chunk", ErrNoChunk, typ)
+	}
+	return v.UnmarshalChunk(data)
+}
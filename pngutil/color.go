@@ -0,0 +1,385 @@
+package pngutil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+/*
+Gamma represents a gAMA chunk, which gives the exponent relating
+stored sample values to the intended output intensity, such as
+1/2.2 (0.45455). The spec stores it to five decimal places.
+*/
+type Gamma float64
+
+func (g Gamma) ChunkType() string {
+	return "gAMA"
+}
+
+func (g Gamma) MarshalChunk() ([]byte, error) {
+	v := math.Round(float64(g) * 100000)
+	if !(v > 0 && v <= math.MaxUint32) {
+		return nil, fmt.Errorf("pngutil: gamma %v out of range for gAMA chunk", float64(g))
+	}
+	return binary.BigEndian.AppendUint32(nil, uint32(v)), nil
+}
+
+func (g *Gamma) UnmarshalChunk(data []byte) error {
+	if len(data) != 4 {
+		return fmt.Errorf("pngutil: gAMA chunk has length %d, want 4", len(data))
+	}
+	v := binary.BigEndian.Uint32(data)
+	if v == 0 {
+		return fmt.Errorf("pngutil: gAMA chunk has gamma of zero")
+	}
+	*g = Gamma(float64(v) / 100000)
+	return nil
+}
+
+/*
+SetGamma returns f with its gAMA chunk set to gamma, which is
+placed before PLTE and IDAT as the spec requires. All other
+chunks are kept byte for byte.
+
+ReplaceMeta discards gAMA by default, which changes how many
+viewers render the image; pass a Policy keeping "gAMA" to
+preserve it.
+*/
+func SetGamma(f io.ReadSeeker, gamma float64) (*multiReadSeeker, error) {
+	return setChunk(f, Gamma(gamma), "PLTE", "IDAT")
+}
+
+/*
+GetGamma returns the gamma recorded in the gAMA chunk of rs, or
+an error wrapping ErrNoChunk if it has none.
+*/
+func GetGamma(rs io.ReadSeeker) (float64, error) {
+	var g Gamma
+	if err := getChunk(rs, &g); err != nil {
+		return 0, err
+	}
+	return float64(g), nil
+}
+
+/*
+Chromaticities represents a cHRM chunk, which gives the CIE 1931
+x,y chromaticities of the white point and the red, green and
+blue primaries. The spec stores each to five decimal places.
+*/
+type Chromaticities struct {
+	WhiteX, WhiteY float64
+	RedX, RedY     float64
+	GreenX, GreenY float64
+	BlueX, BlueY   float64
+}
+
+func (c Chromaticities) ChunkType() string {
+	return "cHRM"
+}
+
+func (c Chromaticities) MarshalChunk() ([]byte, error) {
+	data := make([]byte, 0, 32)
+	for _, f := range c.fields() {
+		v := math.Round(*f * 100000)
+		if !(v >= 0 && v <= math.MaxInt32) {
+			return nil, fmt.Errorf("pngutil: chromaticity %v out of range for cHRM chunk", *f)
+		}
+		data = binary.BigEndian.AppendUint32(data, uint32(v))
+	}
+	return data, nil
+}
+
+func (c *Chromaticities) UnmarshalChunk(data []byte) error {
+	if len(data) != 32 {
+		return fmt.Errorf("pngutil: cHRM chunk has length %d, want 32", len(data))
+	}
+	for i, f := range c.fields() {
+		*f = float64(binary.BigEndian.Uint32(data[i*4:])) / 100000
+	}
+	return nil
+}
+
+// fields returns pointers to the fields of c in the order they're stored.
+func (c *Chromaticities) fields() []*float64 {
+	return []*float64{
+		&c.WhiteX, &c.WhiteY,
+		&c.RedX, &c.RedY,
+		&c.GreenX, &c.GreenY,
+		&c.BlueX, &c.BlueY,
+	}
+}
+
+/*
+SetChromaticities returns f with its cHRM chunk set to c, which
+is placed before PLTE and IDAT as the spec requires. All other
+chunks are kept byte for byte.
+*/
+func SetChromaticities(f io.ReadSeeker, c Chromaticities) (*multiReadSeeker, error) {
+	return setChunk(f, c, "PLTE", "IDAT")
+}
+
+/*
+GetChromaticities returns the chromaticities recorded in the
+cHRM chunk of rs, or an error wrapping ErrNoChunk if it has none.
+*/
+func GetChromaticities(rs io.ReadSeeker) (Chromaticities, error) {
+	var c Chromaticities
+	err := getChunk(rs, &c)
+	return c, err
+}
+
+/*
+RenderingIntent is the content of an sRGB chunk, which declares
+that the image's samples are in the sRGB colour space and how
+they should be mapped to a display's gamut.
+*/
+type RenderingIntent uint8
+
+// Rendering intents defined by the ICC.
+const (
+	IntentPerceptual           RenderingIntent = 0
+	IntentRelativeColorimetric RenderingIntent = 1
+	IntentSaturation           RenderingIntent = 2
+	IntentAbsoluteColorimetric RenderingIntent = 3
+)
+
+/*
+sRGB's gamma and chromaticities, which the spec recommends
+writing alongside an sRGB chunk for decoders that don't
+understand it.
+*/
+const srgbGamma = 0.45455
+
+var srgbChromaticities = Chromaticities{0.3127, 0.329, 0.64, 0.33, 0.3, 0.6, 0.15, 0.06}
+
+func (ri RenderingIntent) ChunkType() string {
+	return "sRGB"
+}
+
+func (ri RenderingIntent) MarshalChunk() ([]byte, error) {
+	if ri > IntentAbsoluteColorimetric {
+		return nil, fmt.Errorf("pngutil: invalid sRGB rendering intent %d", ri)
+	}
+	return []byte{byte(ri)}, nil
+}
+
+func (ri *RenderingIntent) UnmarshalChunk(data []byte) error {
+	if len(data) != 1 {
+		return fmt.Errorf("pngutil: sRGB chunk has length %d, want 1", len(data))
+	}
+	if data[0] > byte(IntentAbsoluteColorimetric) {
+		return fmt.Errorf("pngutil: invalid sRGB rendering intent %d", data[0])
+	}
+	*ri = RenderingIntent(data[0])
+	return nil
+}
+
+/*
+SetSRGB returns f with an sRGB chunk declaring the rendering
+intent ri. As the spec recommends, gAMA and cHRM chunks holding
+sRGB's gamma and chromaticities are written too, replacing any
+existing ones, and since an image can't have both an sRGB chunk
+and an embedded ICC profile, any iCCP chunk is removed. The
+chunks are placed before PLTE and IDAT; all others are kept byte
+for byte.
+*/
+func SetSRGB(f io.ReadSeeker, ri RenderingIntent) (*multiReadSeeker, error) {
+	if _, err := ri.MarshalChunk(); err != nil {
+		return nil, err
+	}
+	mrs, err := RemoveChunks(f, "iCCP")
+	if err == nil {
+		mrs, err = setChunk(mrs, ri, "PLTE", "IDAT")
+	}
+	if err == nil {
+		mrs, err = setChunk(mrs, Gamma(srgbGamma), "PLTE", "IDAT")
+	}
+	if err == nil {
+		mrs, err = setChunk(mrs, srgbChromaticities, "PLTE", "IDAT")
+	}
+	return mrs, err
+}
+
+/*
+GetSRGB returns the rendering intent recorded in the sRGB chunk
+of rs, or an error wrapping ErrNoChunk if it has none.
+*/
+func GetSRGB(rs io.ReadSeeker) (RenderingIntent, error) {
+	var ri RenderingIntent
+	err := getChunk(rs, &ri)
+	return ri, err
+}
+
+/*
+ICCProfile represents an iCCP chunk, which embeds an ICC colour
+profile. Name follows the rules for text chunk keywords. Profile
+holds the profile uncompressed; it's compressed when marshalled.
+*/
+type ICCProfile struct {
+	Name    string
+	Profile []byte
+}
+
+func (p ICCProfile) ChunkType() string {
+	return "iCCP"
+}
+
+func (p ICCProfile) MarshalChunk() ([]byte, error) {
+	name, err := encodeKeyword(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(name)
+	buf.Write([]byte{0, 0}) // null separator and compression method
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(p.Profile); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// maxICCProfile is the largest ICC profile UnmarshalChunk will inflate.
+const maxICCProfile = 32 << 20
+
+func (p *ICCProfile) UnmarshalChunk(data []byte) error {
+	name, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return errors.New("pngutil: iCCP chunk has no name separator")
+	}
+	if len(rest) < 1 || rest[0] != 0 {
+		return fmt.Errorf("pngutil: iCCP chunk %q has unknown compression method", name)
+	}
+	profile, err := inflate(rest[1:], maxICCProfile)
+	if errors.Is(err, ErrLimitExceeded) {
+		return fmt.Errorf("%w: iCCP chunk %q inflates to more than %d bytes", ErrLimitExceeded, name, maxICCProfile)
+	}
+	if err != nil {
+		return fmt.Errorf("pngutil: iCCP chunk %q: %w", name, err)
+	}
+	p.Name = latin1ToUTF8(name)
+	p.Profile = profile
+	return nil
+}
+
+/*
+SetICCProfile returns f with p embedded in its iCCP chunk, which
+is placed before PLTE and IDAT as the spec requires. Since an
+image can't have both an embedded profile and an sRGB chunk, any
+sRGB chunk is removed. All other chunks are kept byte for byte.
+
+ReplaceMeta discards iCCP by default; pass a Policy keeping
+"iCCP" to preserve the profile.
+*/
+func SetICCProfile(f io.ReadSeeker, p ICCProfile) (*multiReadSeeker, error) {
+	data, err := p.MarshalChunk()
+	if err != nil {
+		return nil, err
+	}
+	mrs, err := RemoveChunks(f, "sRGB")
+	if err != nil {
+		return nil, err
+	}
+	return replaceChunk(mrs, "iCCP", data, "PLTE", "IDAT")
+}
+
+/*
+GetICCProfile returns the profile embedded in the iCCP chunk of
+rs, decompressed, or an error wrapping ErrNoChunk if it has none.
+*/
+func GetICCProfile(rs io.ReadSeeker) (ICCProfile, error) {
+	var p ICCProfile
+	err := getChunk(rs, &p)
+	return p, err
+}
+
+/*
+SignificantBits represents an sBIT chunk, which records how many
+bits of each sample were significant in the original image.
+It holds one value per channel in the order the colour type of
+the image stores them: grey; red, green and blue; grey and
+alpha; or red, green, blue and alpha. Indexed images give the
+red, green and blue significant bits of the palette.
+*/
+type SignificantBits []uint8
+
+func (sb SignificantBits) ChunkType() string {
+	return "sBIT"
+}
+
+func (sb SignificantBits) MarshalChunk() ([]byte, error) {
+	if len(sb) < 1 || len(sb) > 4 {
+		return nil, fmt.Errorf("pngutil: sBIT chunk has %d channels, want 1 to 4", len(sb))
+	}
+	return append([]byte{}, sb...), nil
+}
+
+func (sb *SignificantBits) UnmarshalChunk(data []byte) error {
+	if len(data) < 1 || len(data) > 4 {
+		return fmt.Errorf("pngutil: sBIT chunk has length %d, want 1 to 4", len(data))
+	}
+	*sb = append(SignificantBits{}, data...)
+	return nil
+}
+
+/*
+check returns an error unless sb suits an image with header h:
+a value for each channel, each at least one and no more than the
+bit depth, or eight for indexed images.
+*/
+func (sb SignificantBits) check(h ImageInfo) error {
+	want, depth := h.channels(), h.BitDepth
+	if h.ColorType == ColorIndexed {
+		want, depth = 3, 8
+	}
+	if len(sb) != want {
+		return fmt.Errorf("pngutil: sBIT chunk has %d channels, want %d for colour type %d", len(sb), want, h.ColorType)
+	}
+	for _, b := range sb {
+		if b < 1 || b > depth {
+			return fmt.Errorf("pngutil: sBIT chunk has %d significant bits, want 1 to %d", b, depth)
+		}
+	}
+	return nil
+}
+
+/*
+SetSignificantBits returns f with its sBIT chunk set to sb, which
+is checked against the colour type and bit depth of f and placed
+before PLTE and IDAT as the spec requires. All other chunks are
+kept byte for byte.
+*/
+func SetSignificantBits(f io.ReadSeeker, sb SignificantBits) (*multiReadSeeker, error) {
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, err
+	}
+	h, err := readIHDR(f, idx)
+	if err != nil {
+		return nil, err
+	}
+	if err = sb.check(h); err != nil {
+		return nil, err
+	}
+	return setChunk(f, sb, "PLTE", "IDAT")
+}
+
+/*
+GetSignificantBits returns the significant bits recorded in the
+sBIT chunk of rs, or an error wrapping ErrNoChunk if it has none.
+*/
+func GetSignificantBits(rs io.ReadSeeker) (SignificantBits, error) {
+	var sb SignificantBits
+	if err := getChunk(rs, &sb); err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
@@ -0,0 +1,132 @@
+package pngutil
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGamma(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00x")))
+	mrs, err := SetGamma(bytes.NewReader(in), 1/2.2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := GetGamma(mrs); err != nil || have != 0.45455 {
+		t.Errorf("GetGamma\n    have: %v, err: %v\n    want: 0.45455\n", have, err)
+	}
+	want := []string{"IHDR", "tEXt", "gAMA", "IDAT", "IEND"}
+	if have := chunkTypes(t, mrs); !reflect.DeepEqual(have, want) {
+		t.Errorf("SetGamma\n    have: %v\n    want: %v\n", have, want)
+	}
+
+	for _, g := range []float64{0, -1, 1e6} {
+		if _, err = SetGamma(bytes.NewReader(in), g); err == nil {
+			t.Errorf("SetGamma(%v) succeeded, want error", g)
+		}
+	}
+	if _, err = GetGamma(bytes.NewReader(in)); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("GetGamma without gAMA\n    have err: %v\n    want err: %v\n", err, ErrNoChunk)
+	}
+}
+
+func TestChromaticities(t *testing.T) {
+
+	want := srgbChromaticities
+	in := testPNG(t, testChunk("PLTE", make([]byte, 3)))
+	mrs, err := SetChromaticities(bytes.NewReader(in), want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := GetChromaticities(mrs); err != nil || have != want {
+		t.Errorf("GetChromaticities\n    have: %v, err: %v\n    want: %v\n", have, err, want)
+	}
+	if have := chunkTypes(t, mrs); have[1] != "cHRM" {
+		t.Errorf("SetChromaticities\n    have: %v\n    want: cHRM before PLTE\n", have)
+	}
+	if _, err = SetChromaticities(bytes.NewReader(in), Chromaticities{WhiteX: -0.1}); err == nil {
+		t.Errorf("SetChromaticities accepted a negative chromaticity")
+	}
+}
+
+func TestSRGB(t *testing.T) {
+
+	in := testPNG(t,
+		testChunk("gAMA", []byte{0, 1, 0, 0}),
+		testChunk("iCCP", []byte("p\x00\x00")),
+		testChunk("tEXt", []byte("Title\x00x")),
+	)
+	mrs, err := SetSRGB(bytes.NewReader(in), IntentRelativeColorimetric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := GetSRGB(mrs); err != nil || have != IntentRelativeColorimetric {
+		t.Errorf("GetSRGB\n    have: %v, err: %v\n    want: %v\n", have, err, IntentRelativeColorimetric)
+	}
+	if have, err := GetGamma(mrs); err != nil || have != srgbGamma {
+		t.Errorf("SetSRGB gamma\n    have: %v, err: %v\n    want: %v\n", have, err, srgbGamma)
+	}
+	want := []string{"IHDR", "gAMA", "tEXt", "sRGB", "cHRM", "IDAT", "IEND"}
+	if have := chunkTypes(t, mrs); !reflect.DeepEqual(have, want) {
+		t.Errorf("SetSRGB\n    have: %v\n    want: %v\n", have, want)
+	}
+	if _, err = SetSRGB(bytes.NewReader(in), 4); err == nil {
+		t.Errorf("SetSRGB accepted rendering intent 4")
+	}
+}
+
+func TestICCProfile(t *testing.T) {
+
+	in := testPNG(t, testChunk("sRGB", []byte{0}), testChunk("tEXt", []byte("Title\x00x")))
+	want := ICCProfile{"Display P3", bytes.Repeat([]byte("profile "), 100)}
+	mrs, err := SetICCProfile(bytes.NewReader(in), want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := GetICCProfile(mrs); err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("GetICCProfile\n    have: %v, err: %v\n    want: %v\n", have, err, want)
+	}
+	types := []string{"IHDR", "tEXt", "iCCP", "IDAT", "IEND"}
+	if have := chunkTypes(t, mrs); !reflect.DeepEqual(have, types) {
+		t.Errorf("SetICCProfile\n    have: %v\n    want: %v\n", have, types)
+	}
+
+	var kwErr *KeywordError
+	for _, name := range []string{"", " padded", strings.Repeat("n", 80)} {
+		if _, err = SetICCProfile(bytes.NewReader(in), ICCProfile{Name: name}); !errors.As(err, &kwErr) {
+			t.Errorf("SetICCProfile(%q)\n    have err: %v\n    want: *KeywordError\n", name, err)
+		}
+	}
+}
+
+func TestSignificantBits(t *testing.T) {
+
+	// The test image is 8 bit RGBA.
+	in := testPNG(t)
+	cases := []struct {
+		sb  SignificantBits
+		err bool
+	}{
+		{SignificantBits{5, 6, 5, 1}, false},
+		{SignificantBits{8, 8, 8, 8}, false},
+		{SignificantBits{5, 6, 5}, true},
+		{SignificantBits{5, 6, 5, 0}, true},
+		{SignificantBits{5, 6, 5, 9}, true},
+	}
+	for _, c := range cases {
+		mrs, err := SetSignificantBits(bytes.NewReader(in), c.sb)
+		if (err != nil) != c.err {
+			t.Errorf("SetSignificantBits(%v)\n    have err: %v\n    want err: %t\n", c.sb, err, c.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if have, err := GetSignificantBits(mrs); err != nil || !reflect.DeepEqual(have, c.sb) {
+			t.Errorf("GetSignificantBits\n    have: %v, err: %v\n    want: %v\n", have, err, c.sb)
+		}
+	}
+}
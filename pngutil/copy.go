@@ -0,0 +1,186 @@
+package pngutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+/*
+CopyVerified copies the PNG in src to dst one chunk at a time,
+checking the signature, the framing and CRC of every chunk, that
+IHDR comes first, and that nothing follows IEND. It returns the
+number of bytes written to dst.
+
+Each chunk is verified before any of it is written, so when
+corruption is found dst holds the signature and the whole,
+valid chunks preceding it and nothing more; n is the length of
+that prefix. A CRC mismatch is reported as a *CRCError.
+
+src is read twice per chunk, once to verify it and once to copy
+it, so it mustn't be altered during the copy.
+*/
+func CopyVerified(dst io.Writer, src io.ReadSeeker) (n int64, err error) {
+
+	if _, err = src.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	sig := make([]byte, len(header))
+	if _, err = io.ReadFull(src, sig); err != nil || !bytes.Equal(sig, header) {
+		return 0, errors.New("pngutil: missing PNG signature")
+	}
+	if n, err = copyN(dst, src, 0, int64(len(sig))); err != nil {
+		return n, err
+	}
+
+	var chead [8]byte
+	crc := crc32.NewIEEE()
+	for first := true; ; first = false {
+
+		offset := n
+		if _, err = io.ReadFull(src, chead[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, fmt.Errorf("pngutil: stream ended at offset %d before IEND chunk: %w", offset, err)
+		}
+		length := binary.BigEndian.Uint32(chead[0:4])
+		typ := string(chead[4:8])
+		if length > maxChunkLength {
+			return n, fmt.Errorf("pngutil: chunk length %d at offset %d exceeds maximum", length, offset)
+		}
+		if !validChunkType(typ) {
+			return n, fmt.Errorf("pngutil: invalid chunk type %q at offset %d", typ, offset)
+		}
+		if first && typ != "IHDR" {
+			return n, fmt.Errorf("pngutil: first chunk is %s, want IHDR", typ)
+		}
+
+		crc.Reset()
+		crc.Write(chead[4:8])
+		var stored [4]byte
+		if _, err = io.CopyN(crc, src, int64(length)); err == nil {
+			_, err = io.ReadFull(src, stored[:])
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, fmt.Errorf("pngutil: %s chunk at offset %d is truncated: %w", typ, offset, err)
+		}
+		if s := binary.BigEndian.Uint32(stored[:]); s != crc.Sum32() {
+			return n, &CRCError{Type: typ, Offset: offset, Stored: s, Actual: crc.Sum32()}
+		}
+
+		c, err := copyN(dst, src, offset, 12+int64(length))
+		n += c
+		if err != nil {
+			return n, err
+		}
+
+		if typ == "IEND" {
+			break
+		}
+	}
+
+	if _, err = io.ReadFull(src, make([]byte, 1)); !errors.Is(err, io.EOF) {
+		return n, fmt.Errorf("pngutil: unexpected data after IEND chunk at offset %d", n)
+	}
+	return n, nil
+}
+
+// copyN seeks src to offset and copies length bytes from it to dst.
+func copyN(dst io.Writer, src io.ReadSeeker, offset, length int64) (int64, error) {
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.CopyN(dst, src, length)
+	if errors.Is(err, io.EOF) {
+		err = fmt.Errorf("pngutil: source changed during copy: %w", io.ErrUnexpectedEOF)
+	}
+	return n, err
+}
+
+/*
+CopyMeta returns dst with the text chunks of src copied into
+it, for restoring metadata that a tool re-encoding an image has
+dropped. The chunks of src are copied byte for byte after the
+IHDR chunk of dst, replacing any text chunks in dst with the
+same keywords; the rest of dst is kept byte for byte.
+
+Chunks of the types in extra, such as "eXIf" and "tIME", are
+copied in the same way if src has them, replacing those of dst.
+As they're written after IHDR, extra shouldn't name chunks that
+the spec requires to follow PLTE.
+
+The result reads from both src and dst so neither should be
+altered until it has been drained.
+*/
+func CopyMeta(src, dst io.ReadSeeker, extra ...string) (*multiReadSeeker, error) {
+
+	idx, err := indexPNG(src, Options{})
+	if err != nil {
+		return nil, err
+	}
+	copied := make(map[string]bool, len(extra))
+	for _, typ := range extra {
+		copied[typ] = false
+	}
+
+	var meta []byte
+	keywords := make(map[string]bool)
+	for _, h := range idx {
+		_, isExtra := copied[h.typ]
+		if !textChunks[h.typ] && !isExtra {
+			continue
+		}
+		if err = DefaultLimits.checkText(int64(len(meta)) + h.end() - h.offset); err != nil {
+			return nil, err
+		}
+		data, err := readChunkData(src, h)
+		if err != nil {
+			return nil, err
+		}
+		if isExtra {
+			copied[h.typ] = true
+		} else {
+			k, err := textKeyword(data)
+			if err != nil {
+				return nil, err
+			}
+			keywords[k] = true
+		}
+		meta = AppendChunk(meta, h.typ, data)
+	}
+
+	idx, err = indexPNG(dst, Options{})
+	if err != nil {
+		return nil, err
+	}
+	a := newAssembler(dst, len(idx)+2)
+	a.copyRange(0, idx[0].end())
+	a.write("metadata", meta)
+	for _, h := range idx[1:] {
+		if copied[h.typ] {
+			continue
+		}
+		if textChunks[h.typ] {
+			data, err := readChunkData(dst, h)
+			if err != nil {
+				return nil, err
+			}
+			k, err := textKeyword(data)
+			if err != nil {
+				return nil, err
+			}
+			if keywords[k] {
+				continue
+			}
+		}
+		a.copyChunk(h)
+	}
+	return a.finish()
+}
@@ -0,0 +1,107 @@
+package pngutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+FromDataURI decodes a base64 data URI with the media type
+image/png, such as those found in HTML or copied from a browser,
+and returns a reader ready to be passed to ReplaceMeta. Media type
+parameters are permitted and whitespace within the encoded data
+is ignored. The decoded bytes are checked with Assert.
+*/
+func FromDataURI(s string) (io.ReadSeeker, error) {
+
+	s = strings.TrimSpace(s)
+	if len(s) < 5 || !strings.EqualFold(s[:5], "data:") {
+		return nil, errors.New("pngutil: not a data URI")
+	}
+	head, data, ok := strings.Cut(s[5:], ",")
+	if !ok {
+		return nil, errors.New("pngutil: data URI has no data")
+	}
+
+	params := strings.Split(head, ";")
+	if !strings.EqualFold(strings.TrimSpace(params[0]), "image/png") {
+		return nil, fmt.Errorf("pngutil: data URI has media type %q, want image/png", params[0])
+	}
+	if !strings.EqualFold(params[len(params)-1], "base64") {
+		return nil, errors.New("pngutil: data URI isn't base64 encoded")
+	}
+
+	data = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" \t\r\n", r) {
+			return -1
+		}
+		return r
+	}, data)
+	enc := base64.StdEncoding
+	if !strings.HasSuffix(data, "=") && len(data)%4 != 0 {
+		enc = base64.RawStdEncoding
+	}
+	b, err := enc.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("pngutil: decoding data URI: %w", err)
+	}
+
+	rs := bytes.NewReader(b)
+	if err = Assert(rs); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+/*
+MaxDataURISize is the largest image, in bytes before encoding,
+that ToDataURI will encode. Data URIs are held entirely in memory
+and embedded in documents, so large ones are rarely intended.
+*/
+const MaxDataURISize = 8 << 20
+
+/*
+ToDataURI drains r, which should be a PNG such as the output of
+ReplaceMeta, and returns it as a base64 data URI suitable for
+embedding in HTML, CSS or SVG. It returns an error wrapping
+ErrLimitExceeded if r holds more than MaxDataURISize bytes.
+*/
+func ToDataURI(r io.Reader) (string, error) {
+	return ToDataURIWithOptions(r, Options{})
+}
+
+/*
+ToDataURIWithOptions is like ToDataURI but accepts Options. If
+the MaxTotalSize field of Limits is set it's used in place of
+MaxDataURISize.
+*/
+func ToDataURIWithOptions(r io.Reader, opts Options) (string, error) {
+
+	limit := opts.limits().MaxTotalSize
+	if limit <= 0 {
+		limit = MaxDataURISize
+	}
+
+	// Read one byte past the limit to detect oversized input.
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return "", fmt.Errorf("pngutil: %w", err)
+	}
+	if int64(len(b)) > limit {
+		return "", fmt.Errorf("%w: image is over %d bytes", ErrLimitExceeded, limit)
+	}
+	if !bytes.HasPrefix(b, header) {
+		return "", errors.New("pngutil: missing PNG signature")
+	}
+
+	const prefix = "data:image/png;base64,"
+	var sb strings.Builder
+	sb.Grow(len(prefix) + base64.StdEncoding.EncodedLen(len(b)))
+	sb.WriteString(prefix)
+	sb.WriteString(base64.StdEncoding.EncodeToString(b))
+	return sb.String(), nil
+}
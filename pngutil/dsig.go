@@ -0,0 +1,136 @@
+package pngutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+Signature is a pair of dSIG chunks as defined by the registered
+PNG extension. The first dSIG chunk immediately follows IHDR and
+the second immediately precedes IEND. Both hold the same data, a
+digital signature covering every chunk between them.
+
+Since the signature covers the chunks between the pair, any
+rewrite that alters them invalidates it. ReplaceMeta discards
+dSIG chunks unless its Policy keeps them, in which case the
+metadata is written inside the signed span and the image should
+be signed again with Sign.
+*/
+type Signature struct {
+	Data  []byte // contents of each dSIG chunk, e.g. a PKCS #7 signature
+	Start int64  // offset of the first signed chunk
+	End   int64  // offset immediately after the last signed chunk
+}
+
+/*
+ReadSignature returns the dSIG chunk pair of rs, or nil if rs
+has no dSIG chunks. It returns an error if the dSIG chunks don't
+correctly bracket the signed chunks or hold differing data.
+*/
+func ReadSignature(rs io.ReadSeeker) (*Signature, error) {
+
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	var sigs []chunkHeader
+	for _, h := range idx {
+		if h.typ == "dSIG" {
+			sigs = append(sigs, h)
+		}
+	}
+	if len(sigs) == 0 {
+		return nil, nil
+	}
+
+	last := len(idx) - 1
+	if len(sigs) != 2 || idx[1] != sigs[0] || idx[last-1] != sigs[1] || last < 3 {
+		return nil, errors.New("pngutil: dSIG chunks must be a pair bracketing the chunks between IHDR and IEND")
+	}
+	open, err := readChunkData(rs, sigs[0])
+	if err != nil {
+		return nil, err
+	}
+	closing, err := readChunkData(rs, sigs[1])
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(open, closing) {
+		return nil, errors.New("pngutil: dSIG chunks hold different signatures")
+	}
+
+	return &Signature{
+		Data:  open,
+		Start: sigs[0].end(),
+		End:   sigs[1].offset,
+	}, nil
+}
+
+/*
+SignedContent returns a reader over the bytes of rs covered by
+sig. Reading it moves the offset of rs.
+*/
+func SignedContent(rs io.ReadSeeker, sig *Signature) (io.Reader, error) {
+	if _, err := rs.Seek(sig.Start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &skipReadSeeker{
+		name:   "signed",
+		rs:     rs,
+		start:  sig.Start,
+		end:    sig.End,
+		offset: sig.Start,
+	}, nil
+}
+
+/*
+Sign returns f with a new dSIG chunk pair bracketing every chunk
+between IHDR and IEND, replacing any dSIG chunks f already has.
+sign is called once with a reader over the chunks to be signed
+and returns the signature to store, e.g. a detached PKCS #7
+signature produced with the signer's certificate.
+*/
+func Sign(f io.ReadSeeker, sign func(content io.Reader) ([]byte, error)) (*multiReadSeeker, error) {
+
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the content to be signed without any existing dSIG chunks.
+	content := newAssembler(f, len(idx))
+	for _, h := range idx[1 : len(idx)-1] {
+		if h.typ != "dSIG" {
+			content.copyChunk(h)
+		}
+	}
+	if len(content.readers) == 0 {
+		return nil, errors.New("pngutil: nothing to sign between IHDR and IEND")
+	}
+	cr, err := content.finish()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sign(cr)
+	if err != nil {
+		return nil, fmt.Errorf("pngutil: signing: %w", err)
+	}
+	if len(sig) > maxChunkLength {
+		return nil, errors.New("pngutil: signature is too large")
+	}
+	dsig := AppendChunk(nil, "dSIG", sig)
+
+	a := newAssembler(f, len(content.readers)+4)
+	a.copyRange(0, idx[0].end())
+	a.write("dSIG", dsig)
+	for _, r := range content.readers {
+		a.copyRange(r.start, r.end)
+	}
+	a.write("dSIG", dsig)
+	a.copyChunk(idx[len(idx)-1])
+	return a.finish()
+}
@@ -0,0 +1,42 @@
+package pngutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+
+	digest := func(r io.Reader) ([]byte, error) {
+		h := sha256.New()
+		_, err := io.Copy(h, r)
+		return h.Sum(nil), err
+	}
+
+	signed, err := Sign(bytes.NewReader(testPNG(t)), digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ReadSignature(signed)
+	if err != nil || sig == nil {
+		t.Fatalf("ReadSignature: %v, %v", sig, err)
+	}
+	content, err := SignedContent(signed, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum, _ := digest(content); !bytes.Equal(sum, sig.Data) {
+		t.Errorf("SignedContent doesn't match the signed data")
+	}
+
+	// Metadata goes inside the signed span when dSIG is kept.
+	mrs, err := ReplaceMetaWithOptions(signed, Metadata{MetaTitle: "T"}, Options{Policy: Keep("dSIG")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig, err = ReadSignature(mrs); err != nil || sig == nil {
+		t.Errorf("ReadSignature after ReplaceMeta: %v, %v", sig, err)
+	}
+}
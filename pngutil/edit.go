@@ -0,0 +1,335 @@
+package pngutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+/*
+RemoveChunks returns f without any chunks of the given types,
+keeping every other chunk byte for byte and in place. Critical
+chunks, such as IDAT, can't be removed as the result wouldn't
+be a valid PNG. Removing fcTL or fdAT chunks requires
+renumbering those left with RenumberFrames.
+
+As with ReplaceMeta, the result reads from f so f shouldn't be
+altered until it has been drained.
+*/
+func RemoveChunks(f io.ReadSeeker, types ...string) (*multiReadSeeker, error) {
+
+	remove := make(map[string]bool, len(types))
+	for _, typ := range types {
+		if !validChunkType(typ) {
+			return nil, fmt.Errorf("pngutil: invalid chunk type %q", typ)
+		}
+		if !ChunkType(typ).Ancillary() {
+			return nil, fmt.Errorf("pngutil: can't remove critical chunk type %s", typ)
+		}
+		remove[typ] = true
+	}
+
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, err
+	}
+	a := newAssembler(f, len(idx))
+	a.copyRange(0, idx[0].offset)
+	for _, h := range idx {
+		if !remove[h.typ] {
+			a.copyChunk(h)
+		}
+	}
+	return a.finish()
+}
+
+/*
+Anchor is a position in a PNG stream relative to an existing
+chunk: before the first chunk of type Type, or if After is set,
+after the last. For example Anchor{"IHDR", true} is directly
+after IHDR and Anchor{"IDAT", false} directly before the image
+data.
+*/
+type Anchor struct {
+	Type  string
+	After bool
+}
+
+/*
+InsertChunk returns f with a new chunk of type typ holding data
+inserted at the position given by at, computing its length and
+CRC. Every existing chunk is kept byte for byte. An error
+wrapping ErrNoChunk is returned if f has no chunk of type
+at.Type.
+
+InsertChunk doesn't check that typ may appear where it's put,
+nor that f doesn't already have a chunk of that type. Inserting
+fcTL or fdAT chunks requires renumbering with RenumberFrames.
+*/
+func InsertChunk(f io.ReadSeeker, typ string, data []byte, at Anchor) (*multiReadSeeker, error) {
+
+	if !validChunkType(typ) {
+		return nil, fmt.Errorf("pngutil: invalid chunk type %q", typ)
+	}
+	if len(data) > maxChunkLength {
+		return nil, fmt.Errorf("%w: %s chunk data of %d bytes is over maximum of %d", ErrLimitExceeded, typ, len(data), maxChunkLength)
+	}
+	if (at.Type == "IHDR" && !at.After) || (at.Type == "IEND" && at.After) {
+		return nil, fmt.Errorf("pngutil: can't insert chunk outside IHDR and IEND")
+	}
+
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, err
+	}
+	pos := -1 // index of the chunk the new one precedes
+	for i, h := range idx {
+		if h.typ != at.Type {
+			continue
+		}
+		pos = i
+		if !at.After {
+			break
+		}
+		pos++
+	}
+	if pos < 0 {
+		return nil, fmt.Errorf("%w: no %s chunk to insert %s chunk beside", ErrNoChunk, at.Type, typ)
+	}
+
+	a := newAssembler(f, len(idx)+2)
+	a.copyRange(0, idx[0].offset)
+	for i, h := range idx {
+		if i == pos {
+			a.write(typ, AppendChunk(nil, typ, data))
+		}
+		a.copyChunk(h)
+	}
+	return a.finish()
+}
+
+/*
+RepairCRC returns f with the stored CRC of every chunk whose CRC
+doesn't match its contents replaced by the correct one, along
+with a *CRCError describing each chunk repaired. Chunk data is
+kept as is, so a chunk whose data was corrupted rather than
+edited deliberately remains corrupt, only undetectably so.
+
+As with ReplaceMeta, the result reads from f so f shouldn't be
+altered until it has been drained.
+*/
+func RepairCRC(f io.ReadSeeker) (mrs *multiReadSeeker, fixed []*CRCError, err error) {
+
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, nil, err
+	}
+	a := newAssembler(f, len(idx))
+	a.copyRange(0, idx[0].offset)
+	for _, h := range idx {
+		stored, actual, err := checkCRC(f, h)
+		if err != nil {
+			return nil, nil, err
+		}
+		if stored == actual {
+			a.copyChunk(h)
+			continue
+		}
+		fixed = append(fixed, &CRCError{
+			Type:   h.typ,
+			Offset: h.offset,
+			Stored: stored,
+			Actual: actual,
+		})
+		a.copyRange(h.offset, h.end()-4)
+		a.write("crc", binary.BigEndian.AppendUint32(nil, actual))
+	}
+	mrs, err = a.finish()
+	return mrs, fixed, err
+}
+
+/*
+WalkChunks calls fn with each chunk of f in turn, from IHDR to
+IEND, and returns f with only the chunks for which fn returned
+true, each kept byte for byte. Walking stops at the first error
+fn returns, which WalkChunks returns. fn mustn't retain c.Data.
+
+The result is only a valid PNG if fn keeps the critical chunks.
+As with ReplaceMeta, it reads from f so f shouldn't be altered
+until it has been drained.
+*/
+func WalkChunks(f io.ReadSeeker, fn func(c Chunk) (keep bool, err error)) (*multiReadSeeker, error) {
+
+	cr, err := NewChunkReader(f)
+	if err != nil {
+		return nil, err
+	}
+	a := newAssembler(f, 8)
+	a.copyRange(0, int64(len(header)))
+	for {
+		c, err := cr.NextChunk()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		keep, err := fn(c)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			a.copyRange(c.Offset, c.Offset+12+int64(c.Length))
+		}
+	}
+	return a.finish()
+}
+
+/*
+MergeIDAT returns f with its IDAT chunks coalesced into one,
+saving 12 bytes per chunk removed. The image data is streamed
+from f rather than held in memory. Every other chunk is kept
+byte for byte, except an Apple iDOT chunk, which locates bands
+of the image by the IDAT chunks they start in and so is removed.
+f is returned unchanged if it has a single IDAT chunk.
+
+An error wrapping ErrLimitExceeded is returned if the image data
+is too large for a single chunk, and a *CRCError if any IDAT
+chunk is corrupt, as its CRC would otherwise be lost in the merge.
+*/
+func MergeIDAT(f io.ReadSeeker) (*multiReadSeeker, error) {
+
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	first, last := -1, -1
+	var length int64
+	for i, h := range idx {
+		if h.typ != "IDAT" {
+			continue
+		}
+		if first < 0 {
+			first = i
+		} else if last != i-1 {
+			return nil, &ChunkError{Type: h.typ, Offset: h.offset, Err: errors.New("isn't consecutive with other IDAT chunks")}
+		}
+		last = i
+		length += int64(h.length)
+	}
+	if first < 0 {
+		return nil, fmt.Errorf("%w: no IDAT chunk", ErrNoChunk)
+	}
+	if length > maxChunkLength {
+		return nil, fmt.Errorf("%w: %d bytes of image data is over maximum chunk size of %d", ErrLimitExceeded, length, maxChunkLength)
+	}
+
+	a := newAssembler(f, len(idx)+2)
+	a.copyRange(0, idx[0].offset)
+	if first == last {
+		for _, h := range idx {
+			a.copyChunk(h)
+		}
+		return a.finish()
+	}
+
+	// Each chunk's own CRC is checked in the same pass.
+	crc := crc32.NewIEEE()
+	crc.Write([]byte("IDAT"))
+	own := crc32.NewIEEE()
+	var stored [4]byte
+	for _, h := range idx[first : last+1] {
+		own.Reset()
+		own.Write([]byte("IDAT"))
+		err = hashRange(io.MultiWriter(crc, own), f, h.dataOffset(), h.end()-4)
+		if err == nil {
+			_, err = io.ReadFull(f, stored[:])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pngutil: couldn't read IDAT chunk at offset %d: %w", h.offset, err)
+		}
+		if s := binary.BigEndian.Uint32(stored[:]); s != own.Sum32() {
+			return nil, &CRCError{Type: h.typ, Offset: h.offset, Stored: s, Actual: own.Sum32()}
+		}
+	}
+
+	for i, h := range idx {
+		if h.typ == "iDOT" {
+			continue
+		}
+		if i < first || i > last {
+			a.copyChunk(h)
+			continue
+		}
+		if i == first {
+			a.write("IDAT", append(binary.BigEndian.AppendUint32(nil, uint32(length)), "IDAT"...))
+		}
+		a.copyRange(h.dataOffset(), h.end()-4)
+		if i == last {
+			a.write("crc", binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+		}
+	}
+	return a.finish()
+}
+
+/*
+TrailingData locates the IEND chunk of rs by walking its chunks
+from the start, returning the offset at which IEND ends and the
+number of bytes following it. Assert rejects PNGs with data
+after IEND, which may be junk left by a careless writer or a
+payload appended deliberately; in the latter case it can be read
+from rs at offset end. An error wrapping ErrNoChunk is returned
+if the walk doesn't reach an IEND chunk. The offset of rs is left
+unspecified.
+*/
+func TrailingData(rs io.ReadSeeker) (end, n int64, err error) {
+
+	if _, err = rs.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	p := make([]byte, 16)
+	if _, err = io.ReadFull(rs, p); err != nil || !bytes.Equal(p, append(header, ihdr...)) {
+		return 0, 0, errors.New("pngutil: missing header or IHDR chunk")
+	}
+
+	opts := Options{}
+	idx, err := scanChunks(opts.context(), rs, opts.limits())
+	if err != nil {
+		return 0, 0, err
+	}
+	last := idx[len(idx)-1]
+	if last.typ != "IEND" {
+		return 0, 0, fmt.Errorf("%w: no IEND chunk", ErrNoChunk)
+	}
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, 0, err
+	}
+	if last.end() > size {
+		return 0, 0, fmt.Errorf("pngutil: IEND chunk at offset %d is truncated", last.offset)
+	}
+	return last.end(), size - last.end(), nil
+}
+
+/*
+TrimTrailingData returns f without any data following its IEND
+chunk, along with the number of bytes trimmed. f is returned
+unchanged if nothing follows IEND.
+
+As with ReplaceMeta, the result reads from f so f shouldn't be
+altered until it has been drained.
+*/
+func TrimTrailingData(f io.ReadSeeker) (mrs *multiReadSeeker, trimmed int64, err error) {
+	end, trimmed, err := TrailingData(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	a := newAssembler(f, 1)
+	a.copyRange(0, end)
+	mrs, err = a.finish()
+	return mrs, trimmed, err
+}
@@ -0,0 +1,261 @@
+package pngutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Header identifying the APP1 segment of a JPEG that holds EXIF.
+var exifJPEGHeader = []byte("Exif\x00\x00")
+
+/*
+ImportEXIF returns f with its eXIf chunk replaced by the EXIF
+data found in jpeg, so that camera metadata isn't lost when a
+photograph is converted to PNG. All other chunks of f are kept
+unchanged. It returns an error if jpeg has no EXIF data.
+*/
+func ImportEXIF(f io.ReadSeeker, jpeg io.Reader) (*multiReadSeeker, error) {
+	return ImportEXIFWithOptions(f, jpeg, Options{})
+}
+
+/*
+jpegEXIF returns the TIFF-structured EXIF payload of the APP1
+segment of a JPEG stream, without its "Exif" header.
+*/
+func jpegEXIF(r io.Reader) ([]byte, error) {
+
+	br := bufio.NewReader(r)
+	p := make([]byte, 4)
+	if _, err := io.ReadFull(br, p[:2]); err != nil || p[0] != 0xFF || p[1] != 0xD8 {
+		return nil, errors.New("pngutil: not a JPEG")
+	}
+
+	for {
+		if _, err := io.ReadFull(br, p[:2]); err != nil {
+			return nil, fmt.Errorf("pngutil: reading JPEG marker: %w", err)
+		}
+		if p[0] != 0xFF {
+			return nil, errors.New("pngutil: invalid JPEG marker")
+		}
+		marker := p[1]
+		switch {
+		case marker == 0xFF: // fill byte
+			br.UnreadByte()
+			continue
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD8:
+			continue // markers without a length
+		case marker == 0xD9 || marker == 0xDA: // EOI or start of scan
+			return nil, errors.New("pngutil: JPEG has no EXIF data")
+		}
+
+		if _, err := io.ReadFull(br, p[2:4]); err != nil {
+			return nil, fmt.Errorf("pngutil: reading JPEG segment: %w", err)
+		}
+		length := int(binary.BigEndian.Uint16(p[2:4])) - 2
+		if length < 0 {
+			return nil, errors.New("pngutil: invalid JPEG segment length")
+		}
+		seg := make([]byte, length)
+		if _, err := io.ReadFull(br, seg); err != nil {
+			return nil, fmt.Errorf("pngutil: reading JPEG segment: %w", err)
+		}
+		if marker == 0xE1 && bytes.HasPrefix(seg, exifJPEGHeader) {
+			return seg[len(exifJPEGHeader):], nil
+		}
+	}
+}
+
+// EXIF tags that hold values of interest, keyed by tag number.
+var exifTags = map[uint16]string{
+	0x010E: "ImageDescription",
+	0x010F: "Make",
+	0x0110: "Model",
+	0x0112: "Orientation",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013B: "Artist",
+	0x8298: "Copyright",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+}
+
+// Tag of the pointer from IFD0 to the EXIF sub-IFD.
+const exifIFDPointer = 0x8769
+
+// tiffByteOrder checks the TIFF header of data and returns its byte order.
+func tiffByteOrder(data []byte) (binary.ByteOrder, error) {
+	if len(data) < 8 {
+		return nil, errors.New("pngutil: EXIF data is truncated")
+	}
+	var bo binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return nil, errors.New("pngutil: EXIF data has invalid byte order")
+	}
+	if bo.Uint16(data[2:4]) != 42 {
+		return nil, errors.New("pngutil: EXIF data has invalid TIFF header")
+	}
+	return bo, nil
+}
+
+/*
+parseEXIF returns the values of the tags named in exifTags found
+in IFD0 and the EXIF sub-IFD of a TIFF-structured EXIF payload.
+ASCII values are returned as is and SHORT and LONG values in
+decimal. Tags of other types are ignored.
+*/
+func parseEXIF(data []byte) (map[string]string, error) {
+
+	bo, err := tiffByteOrder(data)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	ifds := []uint32{bo.Uint32(data[4:8])}
+	seen := make(map[uint32]bool)
+
+	for len(ifds) > 0 {
+		off := ifds[0]
+		ifds = ifds[1:]
+		if seen[off] {
+			continue // guard against loops
+		}
+		seen[off] = true
+		if int64(off)+2 > int64(len(data)) {
+			return nil, errors.New("pngutil: EXIF IFD offset out of range")
+		}
+		count := int(bo.Uint16(data[off:]))
+		entries := data[off+2:]
+		if len(entries) < count*12 {
+			return nil, errors.New("pngutil: EXIF IFD is truncated")
+		}
+
+		for i := 0; i < count; i++ {
+			e := entries[i*12 : i*12+12]
+			tag, typ, n := bo.Uint16(e[0:2]), bo.Uint16(e[2:4]), bo.Uint32(e[4:8])
+			if tag == exifIFDPointer && typ == 4 {
+				ifds = append(ifds, bo.Uint32(e[8:12]))
+				continue
+			}
+			name, ok := exifTags[tag]
+			if !ok || n == 0 {
+				continue
+			}
+			switch typ {
+			case 2: // ASCII
+				// Values of 4 bytes or fewer are stored inline.
+				var val []byte
+				if n <= 4 {
+					val = e[8 : 8+n]
+				} else {
+					start := bo.Uint32(e[8:12])
+					if int64(start)+int64(n) > int64(len(data)) {
+						return nil, fmt.Errorf("pngutil: EXIF %s value out of range", name)
+					}
+					val = data[start : start+n]
+				}
+				val, _, _ = bytes.Cut(val, []byte{0})
+				fields[name] = string(bytes.TrimSpace(val))
+			case 3: // SHORT
+				fields[name] = fmt.Sprint(bo.Uint16(e[8:10]))
+			case 4: // LONG
+				fields[name] = fmt.Sprint(bo.Uint32(e[8:12]))
+			}
+		}
+	}
+
+	return fields, nil
+}
+
+// exifTimeLayout is the layout of EXIF date and time values.
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+/*
+EXIF holds the commonly wanted fields of an EXIF payload. Fields
+absent from the payload are left at their zero values.
+*/
+type EXIF struct {
+	Make        string // camera manufacturer
+	Model       string // camera model
+	Software    string
+	Artist      string
+	Copyright   string
+	Description string
+	Orientation int // 1 to 8 as defined by TIFF, or zero if unknown
+
+	/*
+		DateTime is when the photograph was taken, or failing
+		that when the file was last changed. EXIF has no time
+		zone so it's given in UTC.
+	*/
+	DateTime time.Time
+}
+
+/*
+ParseEXIF parses the common fields of a raw EXIF payload such as
+that returned by ReadEXIF.
+*/
+func ParseEXIF(data []byte) (*EXIF, error) {
+	fields, err := parseEXIF(data)
+	if err != nil {
+		return nil, err
+	}
+	e := &EXIF{
+		Make:        fields["Make"],
+		Model:       fields["Model"],
+		Software:    fields["Software"],
+		Artist:      fields["Artist"],
+		Copyright:   fields["Copyright"],
+		Description: fields["ImageDescription"],
+	}
+	if o, err := strconv.Atoi(fields["Orientation"]); err == nil && o >= 1 && o <= 8 {
+		e.Orientation = o
+	}
+	for _, name := range []string{"DateTimeOriginal", "DateTime"} {
+		if t, err := time.Parse(exifTimeLayout, fields[name]); err == nil {
+			e.DateTime = t
+			break
+		}
+	}
+	return e, nil
+}
+
+/*
+WriteEXIF returns f with exif, a raw TIFF-structured EXIF payload
+without the "Exif\x00\x00" prefix used in JPEGs, stored in its
+eXIf chunk. An existing eXIf chunk is replaced in place, otherwise
+the chunk is placed before the image data as the spec requires.
+All other chunks are kept byte for byte.
+*/
+func WriteEXIF(f io.ReadSeeker, exif []byte) (*multiReadSeeker, error) {
+	if _, err := tiffByteOrder(exif); err != nil {
+		return nil, err
+	}
+	return replaceChunk(f, "eXIf", exif, "IDAT")
+}
+
+/*
+ReadEXIF returns the raw EXIF payload of rs's eXIf chunk, or
+ErrNoChunk if it has none.
+*/
+func ReadEXIF(rs io.ReadSeeker) ([]byte, error) {
+	data, err := firstChunkData(rs, "eXIf")
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%w: no eXIf chunk", ErrNoChunk)
+	}
+	return data, nil
+}
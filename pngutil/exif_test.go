@@ -0,0 +1,220 @@
+package pngutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testJPEG returns a small JPEG with exif, if any, in its APP1 segment.
+func testJPEG(t *testing.T, exif []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if exif == nil {
+		return b
+	}
+	seg := append([]byte("Exif\x00\x00"), exif...)
+	app1 := []byte{0xFF, 0xE1, byte((len(seg) + 2) >> 8), byte(len(seg) + 2)}
+	out := append([]byte{}, b[:2]...)
+	out = append(out, app1...)
+	out = append(out, seg...)
+	return append(out, b[2:]...)
+}
+
+func TestImportEXIF(t *testing.T) {
+
+	exif := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x00")
+	in := testPNG(t, testChunk("eXIf", []byte("old")))
+
+	mrs, err := ImportEXIF(bytes.NewReader(in), bytes.NewReader(testJPEG(t, exif)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := firstChunkData(mrs, "eXIf")
+	if err != nil || !bytes.Equal(have, exif) || mrs.Size() != int64(len(in)-3+len(exif)) {
+		t.Errorf("ImportEXIF\n"+
+			"    have eXIf: %q, size: %d, err: %v\n"+
+			"    want eXIf: %q, size: %d, err: nil\n",
+			have, mrs.Size(), err, exif, len(in)-3+len(exif))
+	}
+
+	if _, err = ImportEXIF(bytes.NewReader(in), bytes.NewReader(testJPEG(t, nil))); err == nil {
+		t.Errorf("ImportEXIF accepted a JPEG without EXIF data")
+	}
+}
+
+// testEXIF returns a big endian EXIF payload holding ASCII tags in IFD0.
+func testEXIF(tags map[uint16]string) []byte {
+	var keys []uint16
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	ifd := []byte{byte(len(keys) >> 8), byte(len(keys))}
+	values := []byte{}
+	valuesStart := 8 + 2 + 12*len(keys) + 4
+	for _, k := range keys {
+		v := append([]byte(tags[k]), 0)
+		e := make([]byte, 12)
+		binary.BigEndian.PutUint16(e[0:2], k)
+		binary.BigEndian.PutUint16(e[2:4], 2)
+		binary.BigEndian.PutUint32(e[4:8], uint32(len(v)))
+		if len(v) <= 4 {
+			copy(e[8:], v)
+		} else {
+			binary.BigEndian.PutUint32(e[8:12], uint32(valuesStart+len(values)))
+			values = append(values, v...)
+		}
+		ifd = append(ifd, e...)
+	}
+	ifd = append(ifd, 0, 0, 0, 0) // no next IFD
+	out := append([]byte("MM\x00\x2a\x00\x00\x00\x08"), ifd...)
+	return append(out, values...)
+}
+
+func TestMapEXIF(t *testing.T) {
+
+	exif := testEXIF(map[uint16]string{
+		0x013B: "A. Photographer",
+		0x8298: "CC0",
+		0x0110: "X1",
+		0x9003: "2021:06:01 12:30:00",
+	})
+	want := Metadata{
+		MetaAuthor:       "A. Photographer",
+		MetaCopyright:    "CC0",
+		MetaCreationTime: "2021-06-01T12:30:00",
+	}
+
+	fm := DefaultFieldMap().With(FieldMap{"Model": ""})
+	have, err := MapEXIF(exif, fm)
+	if err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("MapEXIF\n"+
+			"    have: %v, err: %v\n"+
+			"    want: %v, err: nil\n",
+			have, err, want)
+	}
+}
+
+func TestMapXMP(t *testing.T) {
+
+	packet := []byte(`<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmp:CreatorTool="Editor 2">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Hills</rdf:li><rdf:li xml:lang="de">Hügel</rdf:li></rdf:Alt></dc:title>
+   <dc:creator><rdf:Seq><rdf:li>Ann</rdf:li><rdf:li>Bob</rdf:li></rdf:Seq></dc:creator>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`)
+	want := Metadata{
+		MetaTitle:    "Hills",
+		MetaAuthor:   "Ann; Bob",
+		MetaSoftware: "Editor 2",
+	}
+
+	have, err := MapXMP(packet, DefaultFieldMap())
+	if err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("MapXMP\n"+
+			"    have: %v, err: %v\n"+
+			"    want: %v, err: nil\n",
+			have, err, want)
+	}
+
+	// Changes to one DefaultFieldMap mustn't leak into the next.
+	delete(DefaultFieldMap(), "dc:title")
+	if have, err = MapXMP(packet, DefaultFieldMap()); err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("MapXMP after modifying DefaultFieldMap\n"+
+			"    have: %v, err: %v\n"+
+			"    want: %v, err: nil\n",
+			have, err, want)
+	}
+}
+
+func TestXMP(t *testing.T) {
+
+	packet := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"/></x:xmpmeta>`)
+	old := testChunk("iTXt", []byte(XMPKeyword+"\x00\x00\x00\x00\x00<old/>"))
+	in := testPNG(t, old, testChunk("tEXt", []byte("Title\x00Keep")))
+
+	mrs, err := WriteXMP(bytes.NewReader(in), packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := mrs.Bytes()
+	want := testPNG(t,
+		testChunk("iTXt", append([]byte(XMPKeyword+"\x00\x00\x00\x00\x00"), packet...)),
+		testChunk("tEXt", []byte("Title\x00Keep")),
+	)
+	if err != nil || !bytes.Equal(have, want) {
+		t.Errorf("WriteXMP\n    have: %q, err: %v\n    want: %q\n", have, err, want)
+	}
+
+	if got, err := ReadXMP(mrs); err != nil || !bytes.Equal(got, packet) {
+		t.Errorf("ReadXMP: have %q, err: %v, want %q", got, err, packet)
+	}
+	if _, err := ReadXMP(bytes.NewReader(testPNG(t))); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("ReadXMP without XMP: have %v, want ErrNoChunk", err)
+	}
+}
+
+func TestEXIFChunk(t *testing.T) {
+
+	exif := testEXIF(map[uint16]string{
+		0x010F: "Canon",
+		0x0110: "EOS R5",
+		0x0112: "6",
+		0x0132: "2024:05:06 07:08:09",
+		0x9003: "2024:05:01 10:11:12",
+	})
+	mrs, err := WriteEXIF(bytes.NewReader(testPNG(t, testChunk("tEXt", []byte("Title\x00x")))), exif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadEXIF(mrs)
+	if err != nil || !bytes.Equal(data, exif) {
+		t.Fatalf("ReadEXIF: have %x, err: %v, want %x", data, err, exif)
+	}
+	idx, _ := indexPNG(mrs, Options{})
+	for _, h := range idx {
+		if h.typ == "IDAT" {
+			t.Errorf("WriteEXIF put eXIf after IDAT")
+		}
+		if h.typ == "eXIf" {
+			break
+		}
+	}
+
+	have, err := ParseEXIF(data)
+	want := &EXIF{
+		Make:        "Canon",
+		Model:       "EOS R5",
+		Orientation: 6,
+		DateTime:    time.Date(2024, time.May, 1, 10, 11, 12, 0, time.UTC),
+	}
+	if err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("ParseEXIF\n    have: %+v, err: %v\n    want: %+v\n", have, err, want)
+	}
+
+	if _, err = WriteEXIF(bytes.NewReader(testPNG(t)), []byte("Exif\x00\x00MM")); err == nil {
+		t.Errorf("WriteEXIF accepted a payload with a JPEG prefix")
+	}
+	if _, err = ReadEXIF(bytes.NewReader(testPNG(t))); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("ReadEXIF without eXIf: have %v, want ErrNoChunk", err)
+	}
+}
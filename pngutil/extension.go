@@ -0,0 +1,325 @@
+package pngutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Units for ImageOffset.
+const (
+	OffsetPixel      uint8 = 0
+	OffsetMicrometre uint8 = 1
+)
+
+/*
+ImageOffset represents an oFFs chunk, which gives the position
+of the image on a page or within a larger image.
+*/
+type ImageOffset struct {
+	X, Y int32
+	Unit uint8 // OffsetPixel or OffsetMicrometre
+}
+
+func (o ImageOffset) ChunkType() string {
+	return "oFFs"
+}
+
+func (o ImageOffset) MarshalChunk() ([]byte, error) {
+	if o.Unit > OffsetMicrometre {
+		return nil, fmt.Errorf("pngutil: invalid oFFs unit %d", o.Unit)
+	}
+	data := make([]byte, 0, 9)
+	data = binary.BigEndian.AppendUint32(data, uint32(o.X))
+	data = binary.BigEndian.AppendUint32(data, uint32(o.Y))
+	return append(data, o.Unit), nil
+}
+
+func (o *ImageOffset) UnmarshalChunk(data []byte) error {
+	if len(data) != 9 {
+		return fmt.Errorf("pngutil: oFFs chunk has length %d, want 9", len(data))
+	}
+	o.X = int32(binary.BigEndian.Uint32(data[0:4]))
+	o.Y = int32(binary.BigEndian.Uint32(data[4:8]))
+	o.Unit = data[8]
+	return nil
+}
+
+/*
+SetImageOffset returns f with its oFFs chunk set to o, placed
+before IDAT as the spec requires. All other chunks are kept
+byte for byte.
+*/
+func SetImageOffset(f io.ReadSeeker, o ImageOffset) (*multiReadSeeker, error) {
+	return setChunk(f, o, "IDAT")
+}
+
+/*
+GetImageOffset returns the offset recorded in the oFFs chunk of
+rs, or an error wrapping ErrNoChunk if it has none.
+*/
+func GetImageOffset(rs io.ReadSeeker) (ImageOffset, error) {
+	var o ImageOffset
+	err := getChunk(rs, &o)
+	return o, err
+}
+
+// Units for PhysicalScale.
+const (
+	ScaleMetre  uint8 = 1
+	ScaleRadian uint8 = 2
+)
+
+/*
+PhysicalScale represents an sCAL chunk, which gives the physical
+width and height covered by each pixel, such as on a map or
+scanned document, or the angle subtended by it.
+*/
+type PhysicalScale struct {
+	Unit          uint8 // ScaleMetre or ScaleRadian
+	Width, Height float64
+}
+
+func (s PhysicalScale) ChunkType() string {
+	return "sCAL"
+}
+
+func (s PhysicalScale) MarshalChunk() ([]byte, error) {
+	if s.Unit != ScaleMetre && s.Unit != ScaleRadian {
+		return nil, fmt.Errorf("pngutil: invalid sCAL unit %d", s.Unit)
+	}
+	for _, v := range [2]float64{s.Width, s.Height} {
+		if !(v > 0) || math.IsInf(v, 1) {
+			return nil, fmt.Errorf("pngutil: sCAL pixel size %v isn't positive and finite", v)
+		}
+	}
+	data := []byte{s.Unit}
+	data = strconv.AppendFloat(data, s.Width, 'g', -1, 64)
+	data = append(data, 0)
+	return strconv.AppendFloat(data, s.Height, 'g', -1, 64), nil
+}
+
+func (s *PhysicalScale) UnmarshalChunk(data []byte) error {
+	if len(data) < 1 {
+		return errors.New("pngutil: sCAL chunk is empty")
+	}
+	w, h, ok := bytes.Cut(data[1:], []byte{0})
+	if !ok {
+		return errors.New("pngutil: sCAL chunk has no separator")
+	}
+	width, err := parseASCIIFloat("sCAL", w)
+	if err != nil {
+		return err
+	}
+	height, err := parseASCIIFloat("sCAL", h)
+	if err != nil {
+		return err
+	}
+	*s = PhysicalScale{Unit: data[0], Width: width, Height: height}
+	return nil
+}
+
+/*
+parseASCIIFloat parses a floating point number as written in
+chunks such as sCAL and pCAL.
+*/
+func parseASCIIFloat(typ string, p []byte) (float64, error) {
+	for _, c := range p {
+		if !(c >= '0' && c <= '9' || c == '.' || c == '+' || c == '-' || c == 'e' || c == 'E') {
+			return 0, fmt.Errorf("pngutil: %s chunk has invalid number %q", typ, p)
+		}
+	}
+	v, err := strconv.ParseFloat(string(p), 64)
+	if err != nil {
+		return 0, fmt.Errorf("pngutil: %s chunk has invalid number %q", typ, p)
+	}
+	return v, nil
+}
+
+/*
+SetPhysicalScale returns f with its sCAL chunk set to s, placed
+before IDAT as the spec requires. All other chunks are kept byte
+for byte.
+*/
+func SetPhysicalScale(f io.ReadSeeker, s PhysicalScale) (*multiReadSeeker, error) {
+	return setChunk(f, s, "IDAT")
+}
+
+/*
+GetPhysicalScale returns the scale recorded in the sCAL chunk of
+rs, or an error wrapping ErrNoChunk if it has none.
+*/
+func GetPhysicalScale(rs io.ReadSeeker) (PhysicalScale, error) {
+	var s PhysicalScale
+	err := getChunk(rs, &s)
+	return s, err
+}
+
+// Equation types for PixelCalibration.
+const (
+	EquationLinear      uint8 = 0
+	EquationExponential uint8 = 1
+	EquationArbitrary   uint8 = 2 // exponential with an arbitrary base
+	EquationHyperbolic  uint8 = 3
+)
+
+// Number of parameters each equation type takes.
+var equationParams = [...]int{2, 3, 4, 4}
+
+/*
+PixelCalibration represents a pCAL chunk, which maps stored
+sample values to physical quantities, such as temperatures in a
+thermal image. Samples are first mapped linearly from 0 and the
+maximum sample value to X0 and X1, then through the equation
+with the given Params to a value in Unit. Name follows the rules
+for text chunk keywords.
+*/
+type PixelCalibration struct {
+	Name     string
+	X0, X1   int32
+	Equation uint8
+	Unit     string
+	Params   []float64
+}
+
+func (c PixelCalibration) ChunkType() string {
+	return "pCAL"
+}
+
+func (c PixelCalibration) MarshalChunk() ([]byte, error) {
+	name, err := encodeKeyword(c.Name)
+	if err != nil {
+		return nil, err
+	}
+	if c.X0 == c.X1 {
+		return nil, fmt.Errorf("pngutil: pCAL calibration %q has equal X0 and X1", c.Name)
+	}
+	if int(c.Equation) >= len(equationParams) {
+		return nil, fmt.Errorf("pngutil: pCAL calibration %q has unknown equation type %d", c.Name, c.Equation)
+	}
+	if want := equationParams[c.Equation]; len(c.Params) != want {
+		return nil, fmt.Errorf("pngutil: pCAL calibration %q has %d parameters, want %d", c.Name, len(c.Params), want)
+	}
+	unit, ok := utf8ToLatin1(c.Unit)
+	if !ok || bytes.IndexByte(unit, 0) >= 0 {
+		return nil, fmt.Errorf("pngutil: pCAL unit %q isn't Latin-1 text", c.Unit)
+	}
+
+	data := append(name, 0)
+	data = binary.BigEndian.AppendUint32(data, uint32(c.X0))
+	data = binary.BigEndian.AppendUint32(data, uint32(c.X1))
+	data = append(data, c.Equation, uint8(len(c.Params)))
+	data = append(data, unit...)
+	for _, p := range c.Params {
+		if math.IsNaN(p) || math.IsInf(p, 0) {
+			return nil, fmt.Errorf("pngutil: pCAL parameter %v isn't finite", p)
+		}
+		data = append(data, 0)
+		data = strconv.AppendFloat(data, p, 'g', -1, 64)
+	}
+	return data, nil
+}
+
+func (c *PixelCalibration) UnmarshalChunk(data []byte) error {
+	name, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(rest) < 10 {
+		return errors.New("pngutil: pCAL chunk is truncated")
+	}
+	*c = PixelCalibration{
+		Name:     latin1ToUTF8(name),
+		X0:       int32(binary.BigEndian.Uint32(rest[0:4])),
+		X1:       int32(binary.BigEndian.Uint32(rest[4:8])),
+		Equation: rest[8],
+	}
+	n := int(rest[9])
+	fields := bytes.Split(rest[10:], []byte{0})
+	if len(fields) != n+1 {
+		return fmt.Errorf("pngutil: pCAL calibration %q has %d parameters, want %d", c.Name, len(fields)-1, n)
+	}
+	c.Unit = latin1ToUTF8(fields[0])
+	for _, p := range fields[1:] {
+		v, err := parseASCIIFloat("pCAL", p)
+		if err != nil {
+			return err
+		}
+		c.Params = append(c.Params, v)
+	}
+	return nil
+}
+
+/*
+SetPixelCalibration returns f with its pCAL chunk set to c,
+placed before IDAT as the spec requires. All other chunks are
+kept byte for byte.
+*/
+func SetPixelCalibration(f io.ReadSeeker, c PixelCalibration) (*multiReadSeeker, error) {
+	return setChunk(f, c, "IDAT")
+}
+
+/*
+GetPixelCalibration returns the calibration recorded in the pCAL
+chunk of rs, or an error wrapping ErrNoChunk if it has none.
+*/
+func GetPixelCalibration(rs io.ReadSeeker) (PixelCalibration, error) {
+	var c PixelCalibration
+	err := getChunk(rs, &c)
+	return c, err
+}
+
+/*
+StereoMode represents an sTER chunk, which marks the image as a
+stereo pair of left and right eye subimages side by side, and
+gives their layout.
+*/
+type StereoMode uint8
+
+// Layouts for StereoMode.
+const (
+	StereoCrossFuse     StereoMode = 0 // right eye image on the left
+	StereoDivergingFuse StereoMode = 1 // left eye image on the left
+)
+
+func (m StereoMode) ChunkType() string {
+	return "sTER"
+}
+
+func (m StereoMode) MarshalChunk() ([]byte, error) {
+	if m > StereoDivergingFuse {
+		return nil, fmt.Errorf("pngutil: invalid sTER mode %d", m)
+	}
+	return []byte{byte(m)}, nil
+}
+
+func (m *StereoMode) UnmarshalChunk(data []byte) error {
+	if len(data) != 1 {
+		return fmt.Errorf("pngutil: sTER chunk has length %d, want 1", len(data))
+	}
+	if data[0] > byte(StereoDivergingFuse) {
+		return fmt.Errorf("pngutil: invalid sTER mode %d", data[0])
+	}
+	*m = StereoMode(data[0])
+	return nil
+}
+
+/*
+SetStereoMode returns f with its sTER chunk set to m, placed
+before IDAT as the spec requires. All other chunks are kept
+byte for byte.
+*/
+func SetStereoMode(f io.ReadSeeker, m StereoMode) (*multiReadSeeker, error) {
+	return setChunk(f, m, "IDAT")
+}
+
+/*
+GetStereoMode returns the layout recorded in the sTER chunk of
+rs, or an error wrapping ErrNoChunk if it has none.
+*/
+func GetStereoMode(rs io.ReadSeeker) (StereoMode, error) {
+	var m StereoMode
+	err := getChunk(rs, &m)
+	return m, err
+}
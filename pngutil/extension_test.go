@@ -0,0 +1,77 @@
+package pngutil
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestExtensionChunks(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00x")))
+
+	offset := ImageOffset{-20, 300, OffsetMicrometre}
+	scale := PhysicalScale{ScaleMetre, 0.5, 2.5e-5}
+	calib := PixelCalibration{"temperature", 0, 65535, EquationLinear, "°C", []float64{-40, 0.01}}
+
+	mrs, err := SetImageOffset(bytes.NewReader(in), offset)
+	if err == nil {
+		mrs, err = SetPhysicalScale(mrs, scale)
+	}
+	if err == nil {
+		mrs, err = SetPixelCalibration(mrs, calib)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if have, err := GetImageOffset(mrs); err != nil || have != offset {
+		t.Errorf("GetImageOffset\n    have: %v, err: %v\n    want: %v\n", have, err, offset)
+	}
+	if have, err := GetPhysicalScale(mrs); err != nil || have != scale {
+		t.Errorf("GetPhysicalScale\n    have: %v, err: %v\n    want: %v\n", have, err, scale)
+	}
+	if have, err := GetPixelCalibration(mrs); err != nil || !reflect.DeepEqual(have, calib) {
+		t.Errorf("GetPixelCalibration\n    have: %v, err: %v\n    want: %v\n", have, err, calib)
+	}
+	if err = ValidateOrder(mrs); err != nil {
+		t.Errorf("extension chunks misplaced: %v", err)
+	}
+
+	bad := []ChunkMarshaler{
+		ImageOffset{Unit: 2},
+		PhysicalScale{ScaleRadian, 0, 1},
+		PhysicalScale{3, 1, 1},
+		PixelCalibration{"c", 1, 1, EquationLinear, "", []float64{0, 1}},
+		PixelCalibration{"c", 0, 1, EquationHyperbolic, "", []float64{0, 1}},
+		PixelCalibration{"c", 0, 1, 4, "", nil},
+	}
+	for _, m := range bad {
+		if _, err := MarshalChunk(m); err == nil {
+			t.Errorf("MarshalChunk(%+v) succeeded, want error", m)
+		}
+	}
+
+	var s PhysicalScale
+	if err = s.UnmarshalChunk([]byte("\x011,5\x002")); err == nil {
+		t.Errorf("PhysicalScale.UnmarshalChunk accepted %q", "1,5")
+	}
+}
+
+func TestStereoMode(t *testing.T) {
+
+	in := testPNG(t, testChunk("sTER", []byte{0}))
+	mrs, err := SetStereoMode(bytes.NewReader(in), StereoDivergingFuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := GetStereoMode(mrs); err != nil || have != StereoDivergingFuse {
+		t.Errorf("GetStereoMode\n    have: %v, err: %v\n    want: %v\n", have, err, StereoDivergingFuse)
+	}
+	if sc, err := NewSidecar(mrs); err != nil || sc.Chunks["sTER"] != 1 {
+		t.Errorf("SetStereoMode left %d sTER chunks, err: %v", sc.Chunks["sTER"], err)
+	}
+	if _, err = SetStereoMode(bytes.NewReader(in), 2); err == nil {
+		t.Errorf("SetStereoMode accepted mode 2")
+	}
+}
@@ -0,0 +1,93 @@
+package pngutil
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+/*
+NameError is returned by WriteFile when a file name can't be
+used, such as one that is empty or reserved on Windows.
+*/
+type NameError struct {
+	Name   string
+	Reason string
+}
+
+func (e *NameError) Error() string {
+	return fmt.Sprintf("pngutil: unusable file name %q: %s", e.Name, e.Reason)
+}
+
+// Names Windows reserves for devices, with or without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Characters Windows forbids in file names, besides control characters.
+const invalidNameChars = `<>:"/\|?*`
+
+/*
+pngName returns name with a ".png" extension, compared without
+regard to case, adding one if needed. Trailing dots and spaces
+are removed from the final element first since Windows drops
+them anyway.
+
+If sanitize is true characters and names that are unusable on
+any platform are replaced so the result is portable. Otherwise
+a name unusable on the current platform is a *NameError.
+*/
+func pngName(name string, sanitize bool) (string, error) {
+
+	dir, base := filepath.Split(name)
+	if strings.ContainsRune(name, 0) {
+		return "", &NameError{name, "contains a NUL byte"}
+	}
+
+	if !strings.EqualFold(filepath.Ext(base), ".png") {
+		base = strings.TrimRight(base, ". ")
+		if base == "" {
+			return "", &NameError{name, "no file name before extension"}
+		}
+		base += ".png"
+	}
+	stem := base[:len(base)-len(".png")]
+
+	if sanitize {
+		stem = strings.Map(func(r rune) rune {
+			if r < 0x20 || strings.ContainsRune(invalidNameChars, r) {
+				return '_'
+			}
+			return r
+		}, stem)
+		if reservedName(stem) {
+			stem = "_" + stem
+		}
+		return dir + stem + base[len(base)-len(".png"):], nil
+	}
+
+	if runtime.GOOS == "windows" {
+		if i := strings.IndexFunc(stem, func(r rune) bool {
+			return r < 0x20 || strings.ContainsRune(invalidNameChars, r)
+		}); i >= 0 {
+			return "", &NameError{name, fmt.Sprintf("invalid character %q", stem[i])}
+		}
+		if reservedName(stem) {
+			return "", &NameError{name, "reserved by Windows"}
+		}
+	}
+	return dir + base, nil
+}
+
+// reservedName reports whether stem is a reserved device name, ignoring any extension.
+func reservedName(stem string) bool {
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	return reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))]
+}
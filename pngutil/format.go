@@ -0,0 +1,77 @@
+package pngutil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Format is an image or document format recognised by Sniff.
+type Format string
+
+// Formats recognised by Sniff. FormatUnknown is the zero value.
+const (
+	FormatUnknown Format = ""
+	FormatPNG     Format = "PNG"
+	FormatCgBI    Format = "Apple CgBI PNG"
+	FormatJPEG    Format = "JPEG"
+	FormatGIF     Format = "GIF"
+	FormatWebP    Format = "WebP"
+	FormatAVIF    Format = "AVIF"
+	FormatTIFF    Format = "TIFF"
+	FormatBMP     Format = "BMP"
+	FormatPDF     Format = "PDF"
+)
+
+// sniffLen is the number of bytes Sniff needs to recognise every format.
+const sniffLen = 16
+
+/*
+Sniff identifies the format of a file from its first bytes, of
+which it needs at most 16, returning FormatUnknown if it doesn't
+recognise them. Only the signature is checked so a recognised
+file may still be corrupt.
+*/
+func Sniff(p []byte) Format {
+	has := func(offset int, sig string) bool {
+		return len(p) >= offset+len(sig) && string(p[offset:offset+len(sig)]) == sig
+	}
+	switch {
+	case isCgBI(p):
+		return FormatCgBI
+	case bytes.HasPrefix(p, header):
+		return FormatPNG
+	case has(0, "\xFF\xD8\xFF"):
+		return FormatJPEG
+	case has(0, "GIF87a"), has(0, "GIF89a"):
+		return FormatGIF
+	case has(0, "RIFF") && has(8, "WEBP"):
+		return FormatWebP
+	case has(4, "ftypavif"), has(4, "ftypavis"):
+		return FormatAVIF
+	case has(0, "II*\x00"), has(0, "MM\x00*"):
+		return FormatTIFF
+	case has(0, "BM"):
+		return FormatBMP
+	case has(0, "%PDF-"):
+		return FormatPDF
+	}
+	return FormatUnknown
+}
+
+/*
+DetectFormat identifies the format of rs from its first bytes as
+Sniff does, which is useful for explaining why Assert rejected
+it. The offset of rs is left unspecified.
+*/
+func DetectFormat(rs io.ReadSeeker) (Format, error) {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return FormatUnknown, err
+	}
+	p := make([]byte, sniffLen)
+	n, err := io.ReadFull(rs, p)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return FormatUnknown, err
+	}
+	return Sniff(p[:n]), nil
+}
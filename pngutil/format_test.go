@@ -0,0 +1,42 @@
+package pngutil
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+
+	cases := []struct {
+		in   string
+		want Format
+	}{
+		{string(header) + "\x00\x00\x00\x0DIHDR", FormatPNG},
+		{string(header) + "\x00\x00\x00\x04CgBI", FormatCgBI},
+		{"\xFF\xD8\xFF\xE0\x00\x10JFIF", FormatJPEG},
+		{"GIF89a\x01\x00", FormatGIF},
+		{"RIFF\x24\x00\x00\x00WEBPVP8 ", FormatWebP},
+		{"\x00\x00\x00\x1CftypavifXXXX", FormatAVIF},
+		{"II*\x00\x08\x00\x00\x00", FormatTIFF},
+		{"MM\x00*\x00\x00\x00\x08", FormatTIFF},
+		{"BM\x36\x00\x00\x00", FormatBMP},
+		{"%PDF-1.7\n", FormatPDF},
+		{"RIFF\x24\x00\x00\x00WAVE", FormatUnknown},
+		{"\x89PN", FormatUnknown},
+		{"", FormatUnknown},
+	}
+	for _, c := range cases {
+		if have := Sniff([]byte(c.in)); have != c.want {
+			t.Errorf("Sniff(%q)\n    have: %q\n    want: %q\n", c.in, have, c.want)
+		}
+	}
+
+	jpeg := testJPEG(t, nil)
+	if have, err := DetectFormat(bytes.NewReader(jpeg)); err != nil || have != FormatJPEG {
+		t.Errorf("DetectFormat(jpeg)\n    have: %q, err: %v\n    want: %q\n", have, err, FormatJPEG)
+	}
+	if err := Assert(bytes.NewReader(jpeg)); err == nil || !strings.Contains(err.Error(), "JPEG") {
+		t.Errorf("Assert(jpeg)\n    have: %v\n    want: error naming JPEG\n", err)
+	}
+}
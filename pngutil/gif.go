@@ -0,0 +1,181 @@
+package pngutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"time"
+)
+
+func init() {
+	for typ, newValue := range map[string]func() ChunkUnmarshaler{
+		"gIFg": func() ChunkUnmarshaler { return new(GIFControl) },
+		"gIFx": func() ChunkUnmarshaler { return new(GIFApplication) },
+		"gIFt": func() ChunkUnmarshaler { return new(GIFText) },
+	} {
+		if err := RegisterChunk(typ, CodecHandler(newValue), false); err != nil {
+			panic(err)
+		}
+	}
+}
+
+/*
+KeepGIF is a Policy that keeps the gIFg, gIFx and gIFt chunks
+written by GIF to PNG converters in addition to the chunks kept
+by DefaultPolicy.
+*/
+var KeepGIF = Keep("gIFg", "gIFx", "gIFt")
+
+/*
+GIFControl represents a gIFg chunk, which preserves the Graphic
+Control Extension of the GIF the PNG was converted from.
+*/
+type GIFControl struct {
+	Disposal  uint8         // GIF disposal method
+	UserInput bool          // whether user input is expected before continuing
+	Delay     time.Duration // delay time, stored in hundredths of a second
+}
+
+func (g GIFControl) ChunkType() string {
+	return "gIFg"
+}
+
+func (g GIFControl) MarshalChunk() ([]byte, error) {
+	delay := g.Delay / (10 * time.Millisecond)
+	if delay < 0 || delay > 0xFFFF {
+		return nil, fmt.Errorf("pngutil: gIFg delay %v out of range", g.Delay)
+	}
+	data := []byte{g.Disposal, 0, 0, 0}
+	if g.UserInput {
+		data[1] = 1
+	}
+	binary.BigEndian.PutUint16(data[2:4], uint16(delay))
+	return data, nil
+}
+
+func (g *GIFControl) UnmarshalChunk(data []byte) error {
+	if len(data) != 4 {
+		return fmt.Errorf("pngutil: gIFg chunk has length %d, want 4", len(data))
+	}
+	g.Disposal = data[0]
+	g.UserInput = data[1] != 0
+	g.Delay = time.Duration(binary.BigEndian.Uint16(data[2:4])) * 10 * time.Millisecond
+	return nil
+}
+
+/*
+GIFApplication represents a gIFx chunk, which preserves a GIF
+Application Extension.
+*/
+type GIFApplication struct {
+	Identifier [8]byte
+	AuthCode   [3]byte
+	Data       []byte
+}
+
+func (g GIFApplication) ChunkType() string {
+	return "gIFx"
+}
+
+func (g GIFApplication) MarshalChunk() ([]byte, error) {
+	data := make([]byte, 0, 11+len(g.Data))
+	data = append(data, g.Identifier[:]...)
+	data = append(data, g.AuthCode[:]...)
+	return append(data, g.Data...), nil
+}
+
+func (g *GIFApplication) UnmarshalChunk(data []byte) error {
+	if len(data) < 11 {
+		return fmt.Errorf("pngutil: gIFx chunk has length %d, want at least 11", len(data))
+	}
+	copy(g.Identifier[:], data[0:8])
+	copy(g.AuthCode[:], data[8:11])
+	g.Data = append([]byte(nil), data[11:]...)
+	return nil
+}
+
+/*
+GIFText represents a gIFt chunk, which preserves a GIF Plain Text
+Extension. The chunk type is deprecated by the PNG extensions
+spec but may still be found in old converted images.
+*/
+type GIFText struct {
+	Left, Top     int32 // text grid position in pixels
+	Width, Height uint32
+	CellWidth     uint8
+	CellHeight    uint8
+	Foreground    color.RGBA
+	Background    color.RGBA
+	Text          string
+}
+
+func (g GIFText) ChunkType() string {
+	return "gIFt"
+}
+
+func (g GIFText) MarshalChunk() ([]byte, error) {
+	data := make([]byte, 24, 24+len(g.Text))
+	binary.BigEndian.PutUint32(data[0:4], uint32(g.Left))
+	binary.BigEndian.PutUint32(data[4:8], uint32(g.Top))
+	binary.BigEndian.PutUint32(data[8:12], g.Width)
+	binary.BigEndian.PutUint32(data[12:16], g.Height)
+	data[16], data[17] = g.CellWidth, g.CellHeight
+	data[18], data[19], data[20] = g.Foreground.R, g.Foreground.G, g.Foreground.B
+	data[21], data[22], data[23] = g.Background.R, g.Background.G, g.Background.B
+	return append(data, g.Text...), nil
+}
+
+func (g *GIFText) UnmarshalChunk(data []byte) error {
+	if len(data) < 24 {
+		return fmt.Errorf("pngutil: gIFt chunk has length %d, want at least 24", len(data))
+	}
+	g.Left = int32(binary.BigEndian.Uint32(data[0:4]))
+	g.Top = int32(binary.BigEndian.Uint32(data[4:8]))
+	g.Width = binary.BigEndian.Uint32(data[8:12])
+	g.Height = binary.BigEndian.Uint32(data[12:16])
+	g.CellWidth, g.CellHeight = data[16], data[17]
+	g.Foreground = color.RGBA{data[18], data[19], data[20], 0xFF}
+	g.Background = color.RGBA{data[21], data[22], data[23], 0xFF}
+	g.Text = string(data[24:])
+	return nil
+}
+
+// GIFControls returns the contents of every gIFg chunk in rs.
+func GIFControls(rs io.ReadSeeker) ([]GIFControl, error) {
+	vals, err := DecodeChunks(rs, "gIFg")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]GIFControl, len(vals))
+	for i, v := range vals {
+		out[i] = *v.(*GIFControl)
+	}
+	return out, nil
+}
+
+// GIFApplications returns the contents of every gIFx chunk in rs.
+func GIFApplications(rs io.ReadSeeker) ([]GIFApplication, error) {
+	vals, err := DecodeChunks(rs, "gIFx")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]GIFApplication, len(vals))
+	for i, v := range vals {
+		out[i] = *v.(*GIFApplication)
+	}
+	return out, nil
+}
+
+// GIFTexts returns the contents of every gIFt chunk in rs.
+func GIFTexts(rs io.ReadSeeker) ([]GIFText, error) {
+	vals, err := DecodeChunks(rs, "gIFt")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]GIFText, len(vals))
+	for i, v := range vals {
+		out[i] = *v.(*GIFText)
+	}
+	return out, nil
+}
@@ -1,3 +1,3 @@
 module github.com/jakebowkett/go-pngutil/pngutil
 
-go 1.13
+go 1.20
@@ -0,0 +1,209 @@
+package pngutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+/*
+CICP represents a cICP chunk, which identifies the colour space
+of the image by the code points of ITU-T H.273, such as those
+of BT.2100 PQ for HDR images. PNG images are RGB, so the spec
+requires MatrixCoefficients to be zero.
+*/
+type CICP struct {
+	ColorPrimaries     uint8 // e.g. 1 for BT.709, 9 for BT.2020
+	TransferFunction   uint8 // e.g. 13 for sRGB, 16 for PQ, 18 for HLG
+	MatrixCoefficients uint8
+	FullRange          bool
+}
+
+func (c CICP) ChunkType() string {
+	return "cICP"
+}
+
+func (c CICP) MarshalChunk() ([]byte, error) {
+	if c.MatrixCoefficients != 0 {
+		return nil, fmt.Errorf("pngutil: cICP matrix coefficients %d, want 0", c.MatrixCoefficients)
+	}
+	data := []byte{c.ColorPrimaries, c.TransferFunction, c.MatrixCoefficients, 0}
+	if c.FullRange {
+		data[3] = 1
+	}
+	return data, nil
+}
+
+func (c *CICP) UnmarshalChunk(data []byte) error {
+	if len(data) != 4 {
+		return fmt.Errorf("pngutil: cICP chunk has length %d, want 4", len(data))
+	}
+	if data[3] > 1 {
+		return fmt.Errorf("pngutil: cICP chunk has invalid full range flag %d", data[3])
+	}
+	*c = CICP{
+		ColorPrimaries:     data[0],
+		TransferFunction:   data[1],
+		MatrixCoefficients: data[2],
+		FullRange:          data[3] == 1,
+	}
+	return nil
+}
+
+/*
+SetCICP returns f with its cICP chunk set to c, placed before
+PLTE and IDAT as the spec requires. All other chunks are kept
+byte for byte.
+
+DefaultPolicy keeps cICP, along with mDCV and cLLI, so HDR images
+keep their colorimetry when their metadata is replaced.
+*/
+func SetCICP(f io.ReadSeeker, c CICP) (*multiReadSeeker, error) {
+	return setChunk(f, c, "PLTE", "IDAT")
+}
+
+/*
+GetCICP returns the code points recorded in the cICP chunk of
+rs, or an error wrapping ErrNoChunk if it has none.
+*/
+func GetCICP(rs io.ReadSeeker) (CICP, error) {
+	var c CICP
+	err := getChunk(rs, &c)
+	return c, err
+}
+
+/*
+MasteringDisplay represents an mDCV chunk, which describes the
+colour volume of the display the image was mastered on: the
+CIE 1931 x,y chromaticities of its primaries and white point,
+stored to a precision of 0.00002, and its maximum and minimum
+luminance in cd/m², stored to a precision of 0.0001.
+*/
+type MasteringDisplay struct {
+	RedX, RedY     float64
+	GreenX, GreenY float64
+	BlueX, BlueY   float64
+	WhiteX, WhiteY float64
+	MaxLuminance   float64
+	MinLuminance   float64
+}
+
+func (md MasteringDisplay) ChunkType() string {
+	return "mDCV"
+}
+
+func (md MasteringDisplay) MarshalChunk() ([]byte, error) {
+	data := make([]byte, 0, 24)
+	for _, c := range md.chromaticities() {
+		v := math.Round(*c / 0.00002)
+		if !(v >= 0 && v <= math.MaxUint16) {
+			return nil, fmt.Errorf("pngutil: chromaticity %v out of range for mDCV chunk", *c)
+		}
+		data = binary.BigEndian.AppendUint16(data, uint16(v))
+	}
+	for _, l := range [2]float64{md.MaxLuminance, md.MinLuminance} {
+		v := math.Round(l / 0.0001)
+		if !(v >= 0 && v <= math.MaxUint32) {
+			return nil, fmt.Errorf("pngutil: luminance %v out of range for mDCV chunk", l)
+		}
+		data = binary.BigEndian.AppendUint32(data, uint32(v))
+	}
+	return data, nil
+}
+
+func (md *MasteringDisplay) UnmarshalChunk(data []byte) error {
+	if len(data) != 24 {
+		return fmt.Errorf("pngutil: mDCV chunk has length %d, want 24", len(data))
+	}
+	for i, c := range md.chromaticities() {
+		*c = float64(binary.BigEndian.Uint16(data[i*2:])) * 0.00002
+	}
+	md.MaxLuminance = float64(binary.BigEndian.Uint32(data[16:20])) * 0.0001
+	md.MinLuminance = float64(binary.BigEndian.Uint32(data[20:24])) * 0.0001
+	return nil
+}
+
+// chromaticities returns pointers to the chromaticities of md in the order they're stored.
+func (md *MasteringDisplay) chromaticities() []*float64 {
+	return []*float64{
+		&md.RedX, &md.RedY,
+		&md.GreenX, &md.GreenY,
+		&md.BlueX, &md.BlueY,
+		&md.WhiteX, &md.WhiteY,
+	}
+}
+
+/*
+SetMasteringDisplay returns f with its mDCV chunk set to md,
+placed before PLTE and IDAT. All other chunks are kept byte for
+byte.
+*/
+func SetMasteringDisplay(f io.ReadSeeker, md MasteringDisplay) (*multiReadSeeker, error) {
+	return setChunk(f, md, "PLTE", "IDAT")
+}
+
+/*
+GetMasteringDisplay returns the colour volume recorded in the
+mDCV chunk of rs, or an error wrapping ErrNoChunk if it has none.
+*/
+func GetMasteringDisplay(rs io.ReadSeeker) (MasteringDisplay, error) {
+	var md MasteringDisplay
+	err := getChunk(rs, &md)
+	return md, err
+}
+
+/*
+ContentLightLevel represents a cLLI chunk, which gives the
+maximum content light level (MaxCLL) and maximum frame-average
+light level (MaxFALL) of the image in cd/m², stored to a
+precision of 0.0001.
+*/
+type ContentLightLevel struct {
+	MaxCLL  float64
+	MaxFALL float64
+}
+
+func (cl ContentLightLevel) ChunkType() string {
+	return "cLLI"
+}
+
+func (cl ContentLightLevel) MarshalChunk() ([]byte, error) {
+	data := make([]byte, 0, 8)
+	for _, l := range [2]float64{cl.MaxCLL, cl.MaxFALL} {
+		v := math.Round(l / 0.0001)
+		if !(v >= 0 && v <= math.MaxUint32) {
+			return nil, fmt.Errorf("pngutil: light level %v out of range for cLLI chunk", l)
+		}
+		data = binary.BigEndian.AppendUint32(data, uint32(v))
+	}
+	return data, nil
+}
+
+func (cl *ContentLightLevel) UnmarshalChunk(data []byte) error {
+	if len(data) != 8 {
+		return fmt.Errorf("pngutil: cLLI chunk has length %d, want 8", len(data))
+	}
+	cl.MaxCLL = float64(binary.BigEndian.Uint32(data[0:4])) * 0.0001
+	cl.MaxFALL = float64(binary.BigEndian.Uint32(data[4:8])) * 0.0001
+	return nil
+}
+
+/*
+SetContentLightLevel returns f with its cLLI chunk set to cl,
+placed before PLTE and IDAT. All other chunks are kept byte for
+byte.
+*/
+func SetContentLightLevel(f io.ReadSeeker, cl ContentLightLevel) (*multiReadSeeker, error) {
+	return setChunk(f, cl, "PLTE", "IDAT")
+}
+
+/*
+GetContentLightLevel returns the light levels recorded in the
+cLLI chunk of rs, or an error wrapping ErrNoChunk if it has none.
+*/
+func GetContentLightLevel(rs io.ReadSeeker) (ContentLightLevel, error) {
+	var cl ContentLightLevel
+	err := getChunk(rs, &cl)
+	return cl, err
+}
@@ -0,0 +1,69 @@
+package pngutil
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCICP(t *testing.T) {
+
+	// BT.2100 PQ.
+	want := CICP{ColorPrimaries: 9, TransferFunction: 16, FullRange: true}
+	mrs, err := SetCICP(bytes.NewReader(testPNG(t)), want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := GetCICP(mrs); err != nil || have != want {
+		t.Errorf("GetCICP\n    have: %+v, err: %v\n    want: %+v\n", have, err, want)
+	}
+	if err = ValidateOrder(mrs); err != nil {
+		t.Errorf("SetCICP misplaced cICP: %v", err)
+	}
+	if _, err = SetCICP(bytes.NewReader(testPNG(t)), CICP{9, 16, 1, true}); err == nil {
+		t.Errorf("SetCICP accepted non-zero matrix coefficients")
+	}
+}
+
+func TestHDRMetadata(t *testing.T) {
+
+	md := MasteringDisplay{0.708, 0.292, 0.17, 0.797, 0.131, 0.046, 0.3127, 0.329, 1000, 0.0001}
+	cl := ContentLightLevel{MaxCLL: 1000, MaxFALL: 400.5}
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00old")), testChunk("gAMA", []byte{0, 0, 0xb1, 0x8f}))
+	mrs, err := SetMasteringDisplay(bytes.NewReader(in), md)
+	if err == nil {
+		mrs, err = SetContentLightLevel(mrs, cl)
+	}
+	if err == nil {
+		mrs, err = SetCICP(mrs, CICP{ColorPrimaries: 9, TransferFunction: 16})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	const eps = 1e-9
+	if have, err := GetMasteringDisplay(mrs); err != nil || math.Abs(have.RedX-md.RedX) > eps || math.Abs(have.MinLuminance-md.MinLuminance) > eps {
+		t.Errorf("GetMasteringDisplay\n    have: %+v, err: %v\n    want: %+v\n", have, err, md)
+	}
+	if have, err := GetContentLightLevel(mrs); err != nil || have != cl {
+		t.Errorf("GetContentLightLevel\n    have: %+v, err: %v\n    want: %+v\n", have, err, cl)
+	}
+
+	// ReplaceMeta keeps the HDR chunks by default.
+	out, err := ReplaceMeta(mrs, Metadata{MetaTitle: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"IHDR", "iTXt", "mDCV", "cLLI", "cICP", "IDAT", "IEND"}
+	if have := chunkTypes(t, out); !reflect.DeepEqual(have, want) {
+		t.Errorf("ReplaceMeta on HDR image\n    have: %v\n    want: %v\n", have, want)
+	}
+
+	if _, err = MarshalChunk(ContentLightLevel{MaxCLL: -1}); err == nil {
+		t.Errorf("MarshalChunk accepted a negative light level")
+	}
+	if _, err = MarshalChunk(MasteringDisplay{RedX: 2}); err == nil {
+		t.Errorf("MarshalChunk accepted an out of range chromaticity")
+	}
+}
@@ -0,0 +1,98 @@
+package pngutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// historyChunk is the private chunk type holding the provenance history.
+const historyChunk = "prOv"
+
+/*
+Event is one entry in an image's provenance history, recording
+an operation performed on it.
+*/
+type Event struct {
+	Time      time.Time `json:"time"`
+	Tool      string    `json:"tool"`            // software performing the operation, e.g. "pngutil"
+	Operation string    `json:"operation"`       // what was done, e.g. "replace metadata"
+	Actor     string    `json:"actor,omitempty"` // person or service responsible
+}
+
+/*
+AppendHistory returns rs with e added to the end of its history,
+which is stored in a private "prOv" chunk as one JSON object per
+line. Existing events are never altered. If e.Time is zero the
+current time is used.
+
+ReplaceMeta discards the history unless Options.History is set
+or Options.Policy keeps "prOv" chunks.
+*/
+func AppendHistory(rs io.ReadSeeker, e Event) (*multiReadSeeker, error) {
+	data, err := appendHistory(rs, e)
+	if err != nil {
+		return nil, err
+	}
+	return StoreBlob(rs, historyChunk, data)
+}
+
+// appendHistory returns the history of rs encoded with e added.
+func appendHistory(rs io.ReadSeeker, e Event) ([]byte, error) {
+	data, err := LoadBlob(rs, historyChunk)
+	if err != nil && !errors.Is(err, ErrNoChunk) {
+		return nil, err
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("pngutil: %w", err)
+	}
+	data = append(data, line...)
+	return append(data, '\n'), nil
+}
+
+// encodeHistory appends the history of rs with e added to dst as a chunk.
+func encodeHistory(dst []byte, rs io.ReadSeeker, e Event) ([]byte, error) {
+	data, err := appendHistory(rs, e)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)+1 > maxChunkLength {
+		return nil, fmt.Errorf("%w: history is too large for one chunk", ErrLimitExceeded)
+	}
+	return AppendChunk(dst, historyChunk, append([]byte{blobStored}, data...)), nil
+}
+
+/*
+ReadHistory returns the events recorded in rs by AppendHistory,
+oldest first. It returns no events and a nil error if rs has no
+history.
+*/
+func ReadHistory(rs io.ReadSeeker) ([]Event, error) {
+	data, err := LoadBlob(rs, historyChunk)
+	if errors.Is(err, ErrNoChunk) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	for n := 1; sc.Scan(); n++ {
+		var e Event
+		if err = json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("pngutil: history event %d: %w", n, err)
+		}
+		events = append(events, e)
+	}
+	return events, sc.Err()
+}
@@ -0,0 +1,169 @@
+package pngutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+/*
+IDOTSegment is one of the horizontal bands described by Apple's
+iDOT chunk, which lets decoders inflate each band in parallel.
+Offset is relative to the start of the iDOT chunk and locates
+the IDAT chunk where the band's data begins.
+*/
+type IDOTSegment struct {
+	FirstRow uint32
+	Rows     uint32
+	Offset   uint32
+}
+
+/*
+parseIDOT parses the data of an iDOT chunk. The format isn't
+publicly documented; it's understood to be a segment count and
+a reserved word followed by the row count and offset of the first
+segment, then the first row, row count and offset of each of the
+rest. The first segment implicitly starts at row zero.
+*/
+func parseIDOT(data []byte) ([]IDOTSegment, error) {
+	if len(data) < 16 {
+		return nil, fmt.Errorf("pngutil: iDOT chunk has length %d, want at least 16", len(data))
+	}
+	n := binary.BigEndian.Uint32(data[0:4])
+	if n == 0 || uint64(len(data)) != 4+12*uint64(n) {
+		return nil, fmt.Errorf("pngutil: iDOT chunk of length %d can't hold %d segments", len(data), n)
+	}
+	segs := []IDOTSegment{{
+		Rows:   binary.BigEndian.Uint32(data[8:12]),
+		Offset: binary.BigEndian.Uint32(data[12:16]),
+	}}
+	for p := data[16:]; len(p) > 0; p = p[12:] {
+		segs = append(segs, IDOTSegment{
+			FirstRow: binary.BigEndian.Uint32(p[0:4]),
+			Rows:     binary.BigEndian.Uint32(p[4:8]),
+			Offset:   binary.BigEndian.Uint32(p[8:12]),
+		})
+	}
+	return segs, nil
+}
+
+// encodeIDOT is the inverse of parseIDOT.
+func encodeIDOT(segs []IDOTSegment) []byte {
+	data := make([]byte, 0, 4+12*len(segs))
+	data = binary.BigEndian.AppendUint32(data, uint32(len(segs)))
+	data = binary.BigEndian.AppendUint32(data, 0)
+	for i, s := range segs {
+		if i > 0 {
+			data = binary.BigEndian.AppendUint32(data, s.FirstRow)
+		}
+		data = binary.BigEndian.AppendUint32(data, s.Rows)
+		data = binary.BigEndian.AppendUint32(data, s.Offset)
+	}
+	return data
+}
+
+/*
+HasIDOT reports whether rs contains an Apple iDOT chunk, which
+macOS and iOS write as a hint for decoding an image in parallel.
+*/
+func HasIDOT(rs io.ReadSeeker) (bool, error) {
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return false, err
+	}
+	for _, h := range idx {
+		if h.typ == "iDOT" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/*
+KeepIDOT is a Policy that keeps the iDOT chunk in addition to the
+chunks kept by DefaultPolicy. ReplaceMeta adjusts its offsets for
+any chunks it discards. To strip iDOT from a file otherwise left
+untouched, use RemoveChunks.
+*/
+var KeepIDOT = Keep("iDOT")
+
+/*
+GetIDOT returns the segments described by the iDOT chunk of rs,
+or an error wrapping ErrNoChunk if it has none.
+*/
+func GetIDOT(rs io.ReadSeeker) ([]IDOTSegment, error) {
+	data, err := firstChunkData(rs, "iDOT")
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%w: no iDOT chunk", ErrNoChunk)
+	}
+	return parseIDOT(data)
+}
+
+/*
+fixIDOT rewrites the iDOT chunk located by h, which is being
+kept by ReplaceMeta, so that its offsets still locate the same
+IDAT chunks after the chunks between them have been shuffled.
+readers is the layout of the output; the reader holding h is
+split so the patched chunk can be substituted.
+*/
+func fixIDOT(f io.ReadSeeker, h chunkHeader, readers []*skipReadSeeker) ([]*skipReadSeeker, error) {
+
+	data, err := readChunkData(f, h)
+	if err != nil {
+		return nil, err
+	}
+	segs, err := parseIDOT(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Maps an offset of f to its offset in the output.
+	mapOffset := func(in int64) (int64, bool) {
+		var out int64
+		for _, r := range readers {
+			if r.rs == f && in >= r.start && in < r.end {
+				return out + in - r.start, true
+			}
+			out += r.end - r.start
+		}
+		return 0, false
+	}
+
+	base, ok := mapOffset(h.offset)
+	if !ok {
+		return readers, nil
+	}
+	changed := false
+	for i, s := range segs {
+		out, ok := mapOffset(h.offset + int64(s.Offset))
+		if !ok {
+			return nil, fmt.Errorf("pngutil: iDOT segment %d refers to a discarded chunk", i)
+		}
+		if rel := uint32(out - base); rel != s.Offset {
+			segs[i].Offset = rel
+			changed = true
+		}
+	}
+	if !changed {
+		return readers, nil
+	}
+
+	out := make([]*skipReadSeeker, 0, len(readers)+2)
+	for _, r := range readers {
+		if r.rs != f || h.offset < r.start || h.offset >= r.end {
+			out = append(out, r)
+			continue
+		}
+		chunk := AppendChunk(nil, "iDOT", encodeIDOT(segs))
+		out = append(out,
+			&skipReadSeeker{name: "chunk", rs: f, start: r.start, end: h.offset},
+			&skipReadSeeker{name: "iDOT", rs: bytes.NewReader(chunk), end: int64(len(chunk))},
+			&skipReadSeeker{name: "chunk", rs: f, start: h.end(), end: r.end},
+		)
+	}
+	return out, nil
+}
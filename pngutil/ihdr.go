@@ -0,0 +1,186 @@
+package pngutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// Colour types of IHDR.
+const (
+	ColorGray      uint8 = 0
+	ColorRGB       uint8 = 2
+	ColorIndexed   uint8 = 3 // palette indices
+	ColorGrayAlpha uint8 = 4
+	ColorRGBA      uint8 = 6
+)
+
+// Interlace methods of IHDR.
+const (
+	InterlaceNone  uint8 = 0
+	InterlaceAdam7 uint8 = 1
+)
+
+/*
+ImageInfo holds the fields of an IHDR chunk, which describe the
+image data. The spec defines only method 0 for Compression and
+Filter.
+*/
+type ImageInfo struct {
+	Width, Height uint32
+	BitDepth      uint8 // bits per sample, or per palette index
+	ColorType     uint8 // ColorGray, ColorRGB, ColorIndexed, ColorGrayAlpha or ColorRGBA
+	Compression   uint8
+	Filter        uint8
+	Interlace     uint8 // InterlaceNone or InterlaceAdam7
+}
+
+/*
+Info returns the fields of the IHDR chunk of rs. Like Assert it
+doesn't read the entire file, so it's a cheap way to learn an
+image's dimensions without decoding it. The offset of rs is left
+unspecified.
+*/
+func Info(rs io.ReadSeeker) (ImageInfo, error) {
+	if err := Assert(rs); err != nil {
+		return ImageInfo{}, err
+	}
+	return readIHDR(rs, []chunkHeader{{offset: int64(len(header)), length: 13, typ: "IHDR"}})
+}
+
+// Bit depths permitted for each colour type.
+var bitDepths = map[uint8][]uint8{
+	ColorGray:      {1, 2, 4, 8, 16},
+	ColorRGB:       {8, 16},
+	ColorIndexed:   {1, 2, 4, 8},
+	ColorGrayAlpha: {8, 16},
+	ColorRGBA:      {8, 16},
+}
+
+/*
+Check returns an error describing the first field of h the spec
+doesn't permit: a width or height of zero or over 2^31-1, a bit
+depth not allowed for the colour type, or an unknown colour
+type, compression, filter or interlace method. Decoders reject
+such images although Assert doesn't.
+*/
+func (h ImageInfo) Check() error {
+	if h.Width == 0 || h.Height == 0 || h.Width > maxDimension || h.Height > maxDimension {
+		return fmt.Errorf("pngutil: IHDR dimensions %dx%d out of range", h.Width, h.Height)
+	}
+	depths, ok := bitDepths[h.ColorType]
+	if !ok {
+		return fmt.Errorf("pngutil: IHDR has unknown colour type %d", h.ColorType)
+	}
+	legal := false
+	for _, d := range depths {
+		legal = legal || d == h.BitDepth
+	}
+	if !legal {
+		return fmt.Errorf("pngutil: IHDR bit depth %d isn't permitted for colour type %d", h.BitDepth, h.ColorType)
+	}
+	if h.Compression != 0 {
+		return fmt.Errorf("pngutil: IHDR has unknown compression method %d", h.Compression)
+	}
+	if h.Filter != 0 {
+		return fmt.Errorf("pngutil: IHDR has unknown filter method %d", h.Filter)
+	}
+	if h.Interlace > InterlaceAdam7 {
+		return fmt.Errorf("pngutil: IHDR has unknown interlace method %d", h.Interlace)
+	}
+	return nil
+}
+
+func parseIHDR(data []byte) (h ImageInfo, err error) {
+	if len(data) != 13 {
+		return h, fmt.Errorf("pngutil: IHDR chunk has length %d, want 13", len(data))
+	}
+	return ImageInfo{
+		Width:       binary.BigEndian.Uint32(data[0:4]),
+		Height:      binary.BigEndian.Uint32(data[4:8]),
+		BitDepth:    data[8],
+		ColorType:   data[9],
+		Compression: data[10],
+		Filter:      data[11],
+		Interlace:   data[12],
+	}, nil
+}
+
+// readIHDR parses the IHDR chunk of rs, which idx locates.
+func readIHDR(rs io.ReadSeeker, idx []chunkHeader) (ImageInfo, error) {
+	data, err := readChunkData(rs, idx[0])
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	return parseIHDR(data)
+}
+
+/*
+channels returns the number of samples per pixel, counting an
+index into the palette as one.
+*/
+func (h ImageInfo) channels() int {
+	switch h.ColorType {
+	case ColorRGB:
+		return 3
+	case ColorGrayAlpha:
+		return 2
+	case ColorRGBA:
+		return 4
+	}
+	return 1
+}
+
+// Adam7 passes as the x and y of their first pixel and their spacing.
+var adam7 = [7][4]uint64{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+/*
+passes returns the width and height in pixels of each non-empty
+interlace pass, or of the whole image if it isn't interlaced.
+*/
+func (h ImageInfo) passes() [][2]uint64 {
+	layout := [][4]uint64{{0, 0, 1, 1}}
+	if h.Interlace == InterlaceAdam7 {
+		layout = adam7[:]
+	}
+	var out [][2]uint64
+	for _, p := range layout {
+		w, rows := uint64(h.Width), uint64(h.Height)
+		if w <= p[0] || rows <= p[1] {
+			continue
+		}
+		out = append(out, [2]uint64{(w - p[0] + p[2] - 1) / p[2], (rows - p[1] + p[3] - 1) / p[3]})
+	}
+	return out
+}
+
+// rowBytes returns the bytes of a row w pixels wide, excluding its filter byte.
+func (h ImageInfo) rowBytes(w uint64) uint64 {
+	return (w*uint64(h.channels())*uint64(h.BitDepth) + 7) / 8
+}
+
+/*
+rawSize returns the size of the image data once inflated, that
+is the rows of each interlace pass preceded by their filter
+bytes. It saturates rather than overflowing.
+*/
+func (h ImageInfo) rawSize() int64 {
+	var total uint64
+	for _, p := range h.passes() {
+		hi, size := bits.Mul64(1+h.rowBytes(p[0]), p[1])
+		if total += size; hi != 0 || size > math.MaxInt64 || total > math.MaxInt64 {
+			return math.MaxInt64
+		}
+	}
+	return int64(total)
+}
@@ -0,0 +1,110 @@
+package pngutil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxKeywordLength is the longest keyword the spec permits, in Latin-1 bytes.
+const maxKeywordLength = 79
+
+/*
+KeywordError is returned when a keyword breaks the rules the PNG
+spec sets for text chunk keywords.
+*/
+type KeywordError struct {
+	Keyword string
+	Reason  string
+}
+
+func (e *KeywordError) Error() string {
+	return fmt.Sprintf("pngutil: invalid keyword %q: %s", e.Keyword, e.Reason)
+}
+
+/*
+encodeKeyword returns k encoded as Latin-1 for writing to a text
+chunk. It returns a *KeywordError unless k is 1 to 79 printable
+Latin-1 characters with no leading, trailing or consecutive
+spaces.
+*/
+func encodeKeyword(k string) ([]byte, error) {
+	p := make([]byte, 0, len(k))
+	for _, r := range k {
+		if !keywordChar(r) {
+			return nil, &KeywordError{k, fmt.Sprintf("character %q isn't printable Latin-1", r)}
+		}
+		if r == ' ' && (len(p) == 0 || p[len(p)-1] == ' ') {
+			return nil, &KeywordError{k, "leading or consecutive spaces"}
+		}
+		p = append(p, byte(r))
+	}
+	switch {
+	case len(p) == 0:
+		return nil, &KeywordError{k, "empty"}
+	case len(p) > maxKeywordLength:
+		return nil, &KeywordError{k, fmt.Sprintf("longer than %d characters", maxKeywordLength)}
+	case p[len(p)-1] == ' ':
+		return nil, &KeywordError{k, "trailing space"}
+	}
+	return p, nil
+}
+
+func keywordChar(r rune) bool {
+	return r >= 32 && r <= 126 || r >= 161 && r <= 255
+}
+
+/*
+SanitizeKeyword returns k altered to satisfy the PNG spec's rules
+for keywords: characters that aren't printable Latin-1 become
+underscores, runs of spaces are collapsed, leading and trailing
+spaces are removed, and the result is cut to 79 characters. It
+returns a *KeywordError if nothing remains.
+*/
+func SanitizeKeyword(k string) (string, error) {
+	var b strings.Builder
+	n := 0
+	for _, r := range strings.Join(strings.Fields(k), " ") {
+		if n == maxKeywordLength {
+			break
+		}
+		if !keywordChar(r) {
+			r = '_'
+		}
+		b.WriteRune(r)
+		n++
+	}
+	s := strings.TrimRight(b.String(), " ")
+	if s == "" {
+		return "", &KeywordError{k, "empty"}
+	}
+	return s, nil
+}
+
+/*
+sanitizeKeywords returns meta and entries with their keywords
+sanitized. Keywords which become the same are an error since
+one value would silently replace the other.
+*/
+func sanitizeKeywords(meta Metadata, entries []Entry) (Metadata, []Entry, error) {
+	out := make(Metadata, len(meta))
+	for _, k := range sortedKeys(meta) {
+		s, err := SanitizeKeyword(k)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := out[s]; ok {
+			return nil, nil, &KeywordError{k, fmt.Sprintf("sanitized form %q is already in use", s)}
+		}
+		out[s] = meta[k]
+	}
+	sanitized := make([]Entry, len(entries))
+	for i, e := range entries {
+		s, err := SanitizeKeyword(e.Keyword)
+		if err != nil {
+			return nil, nil, err
+		}
+		e.Keyword = s
+		sanitized[i] = e
+	}
+	return out, sanitized, nil
+}
@@ -0,0 +1,112 @@
+package pngutil
+
+import (
+	"io"
+	"time"
+)
+
+/*
+FieldMap maps fields of structured metadata to PNG keywords.
+EXIF fields are named by their tag name, e.g. "Artist", and XMP
+properties by their conventionally prefixed name, e.g.
+"dc:creator". Fields mapped to an empty keyword are ignored.
+*/
+type FieldMap map[string]string
+
+/*
+DefaultFieldMap returns a map of the EXIF and XMP fields that
+correspond to the predefined PNG keywords. Each call returns a
+fresh copy, so callers may modify it freely.
+*/
+func DefaultFieldMap() FieldMap {
+	return defaultFieldMap.With(nil)
+}
+
+var defaultFieldMap = FieldMap{
+	"ImageDescription": MetaDescription,
+	"Artist":           MetaAuthor,
+	"Copyright":        MetaCopyright,
+	"DateTimeOriginal": MetaCreationTime,
+	"Software":         MetaSoftware,
+	"Model":            MetaSource,
+
+	"dc:title":        MetaTitle,
+	"dc:creator":      MetaAuthor,
+	"dc:description":  MetaDescription,
+	"dc:rights":       MetaCopyright,
+	"xmp:CreateDate":  MetaCreationTime,
+	"xmp:CreatorTool": MetaSoftware,
+}
+
+/*
+With returns a copy of fm with overrides applied on top of it.
+An override with an empty keyword removes that field.
+*/
+func (fm FieldMap) With(overrides FieldMap) FieldMap {
+	out := make(FieldMap, len(fm)+len(overrides))
+	for k, v := range fm {
+		out[k] = v
+	}
+	for k, v := range overrides {
+		out[k] = v
+	}
+	return out
+}
+
+func (fm FieldMap) apply(fields map[string]string) Metadata {
+	meta := make(Metadata)
+	for field, v := range fields {
+		if kw := fm[field]; kw != "" && v != "" {
+			meta[kw] = v
+		}
+	}
+	return meta
+}
+
+/*
+MapEXIF translates the fields of a TIFF-structured EXIF payload,
+such as the contents of an eXIf chunk, to PNG keywords using fm.
+EXIF timestamps are converted to ISO 8601.
+*/
+func MapEXIF(exif []byte, fm FieldMap) (Metadata, error) {
+	fields, err := parseEXIF(exif)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"DateTime", "DateTimeOriginal", "DateTimeDigitized"} {
+		if t, err := time.Parse(exifTimeLayout, fields[name]); err == nil {
+			fields[name] = t.Format("2006-01-02T15:04:05")
+		}
+	}
+	return fm.apply(fields), nil
+}
+
+// MapXMP translates the properties of an XMP packet to PNG keywords using fm.
+func MapXMP(packet []byte, fm FieldMap) (Metadata, error) {
+	fields, err := parseXMP(packet)
+	if err != nil {
+		return nil, err
+	}
+	return fm.apply(fields), nil
+}
+
+/*
+ImportEXIFWithOptions is like ImportEXIF but accepts Options.
+If FieldMap is set, the EXIF fields it maps are also written to
+f as text, replacing existing text with the same keywords.
+*/
+func ImportEXIFWithOptions(f io.ReadSeeker, jpeg io.Reader, opts Options) (*multiReadSeeker, error) {
+	exif, err := jpegEXIF(jpeg)
+	if err != nil {
+		return nil, err
+	}
+	mrs, err := WriteEXIF(f, exif)
+	if err != nil || opts.FieldMap == nil {
+		return mrs, err
+	}
+	meta, err := MapEXIF(exif, opts.FieldMap)
+	if err != nil || len(meta) == 0 {
+		return mrs, err
+	}
+	return setText(mrs, meta)
+}
@@ -0,0 +1,134 @@
+package pngutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+func init() {
+	newValue := func() ChunkUnmarshaler { return new(NinePatch) }
+	if err := RegisterChunk("npTc", CodecHandler(newValue), false); err != nil {
+		panic(err)
+	}
+}
+
+/*
+KeepNinePatch is a Policy that keeps the npTc, npLb and npOl
+chunks of compiled Android nine-patch images in addition to the
+chunks kept by DefaultPolicy.
+*/
+var KeepNinePatch = Keep("npTc", "npLb", "npOl")
+
+// Length of the fixed part of an npTc chunk.
+const ninePatchHeader = 32
+
+/*
+NinePatch represents an npTc chunk, which Android's resource
+compiler writes into nine-patch images in place of the marker
+pixels around their border. XDivs and YDivs hold the start and
+end of each stretchable region along each axis, so have an even
+length. Colors holds a colour hint for each region the divisions
+create.
+*/
+type NinePatch struct {
+	XDivs, YDivs  []int32
+	PaddingLeft   int32
+	PaddingRight  int32
+	PaddingTop    int32
+	PaddingBottom int32
+	Colors        []uint32
+}
+
+func (np NinePatch) ChunkType() string {
+	return "npTc"
+}
+
+func (np NinePatch) MarshalChunk() ([]byte, error) {
+	if len(np.XDivs)%2 != 0 || len(np.YDivs)%2 != 0 {
+		return nil, fmt.Errorf("pngutil: npTc chunk has %d x and %d y divisions, want even counts", len(np.XDivs), len(np.YDivs))
+	}
+	if len(np.XDivs) > 0xFF || len(np.YDivs) > 0xFF || len(np.Colors) > 0xFF {
+		return nil, fmt.Errorf("pngutil: npTc chunk has too many divisions or colours")
+	}
+
+	xOffset := uint32(ninePatchHeader)
+	yOffset := xOffset + 4*uint32(len(np.XDivs))
+	colorsOffset := yOffset + 4*uint32(len(np.YDivs))
+
+	data := make([]byte, 0, colorsOffset+4*uint32(len(np.Colors)))
+	data = append(data, 0, uint8(len(np.XDivs)), uint8(len(np.YDivs)), uint8(len(np.Colors)))
+	data = binary.BigEndian.AppendUint32(data, xOffset)
+	data = binary.BigEndian.AppendUint32(data, yOffset)
+	for _, p := range [4]int32{np.PaddingLeft, np.PaddingRight, np.PaddingTop, np.PaddingBottom} {
+		data = binary.BigEndian.AppendUint32(data, uint32(p))
+	}
+	data = binary.BigEndian.AppendUint32(data, colorsOffset)
+	for _, divs := range [2][]int32{np.XDivs, np.YDivs} {
+		for _, d := range divs {
+			data = binary.BigEndian.AppendUint32(data, uint32(d))
+		}
+	}
+	for _, c := range np.Colors {
+		data = binary.BigEndian.AppendUint32(data, c)
+	}
+	return data, nil
+}
+
+/*
+UnmarshalChunk reads the divisions and colours from directly
+after the fixed fields, where the resource compiler writes them,
+ignoring the offsets stored in the chunk.
+*/
+func (np *NinePatch) UnmarshalChunk(data []byte) error {
+	if len(data) < ninePatchHeader {
+		return fmt.Errorf("pngutil: npTc chunk has length %d, want at least %d", len(data), ninePatchHeader)
+	}
+	nx, ny, nc := int(data[1]), int(data[2]), int(data[3])
+	if want := ninePatchHeader + 4*(nx+ny+nc); len(data) != want {
+		return fmt.Errorf("pngutil: npTc chunk has length %d, want %d", len(data), want)
+	}
+
+	word := func(i int) uint32 {
+		return binary.BigEndian.Uint32(data[i*4:])
+	}
+	*np = NinePatch{
+		PaddingLeft:   int32(word(3)),
+		PaddingRight:  int32(word(4)),
+		PaddingTop:    int32(word(5)),
+		PaddingBottom: int32(word(6)),
+	}
+	i := ninePatchHeader / 4
+	for ; i < ninePatchHeader/4+nx; i++ {
+		np.XDivs = append(np.XDivs, int32(word(i)))
+	}
+	for ; i < ninePatchHeader/4+nx+ny; i++ {
+		np.YDivs = append(np.YDivs, int32(word(i)))
+	}
+	for ; i < ninePatchHeader/4+nx+ny+nc; i++ {
+		np.Colors = append(np.Colors, word(i))
+	}
+	return nil
+}
+
+/*
+SetNinePatch returns f with its npTc chunk set to np, placed
+before IDAT. All other chunks are kept byte for byte.
+
+ReplaceMeta discards npTc by default, which turns a nine-patch
+into an ordinary image; pass KeepNinePatch as its Policy to
+preserve it.
+*/
+func SetNinePatch(f io.ReadSeeker, np NinePatch) (*multiReadSeeker, error) {
+	return setChunk(f, np, "IDAT")
+}
+
+/*
+GetNinePatch returns the nine-patch data recorded in the npTc
+chunk of rs, or an error wrapping ErrNoChunk if it has none.
+*/
+func GetNinePatch(rs io.ReadSeeker) (NinePatch, error) {
+	var np NinePatch
+	err := getChunk(rs, &np)
+	return np, err
+}
@@ -0,0 +1,42 @@
+package pngutil
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestNinePatch(t *testing.T) {
+
+	want := NinePatch{
+		XDivs:         []int32{1, 3},
+		YDivs:         []int32{2, 3},
+		PaddingLeft:   1,
+		PaddingRight:  1,
+		PaddingTop:    0,
+		PaddingBottom: 2,
+		Colors:        []uint32{1, 1, 0xFF0000FF, 1, 1, 1, 1, 1, 1},
+	}
+	mrs, err := SetNinePatch(bytes.NewReader(testPNG(t)), want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := GetNinePatch(mrs); err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("GetNinePatch\n    have: %+v, err: %v\n    want: %+v\n", have, err, want)
+	}
+
+	for _, policy := range []Policy{nil, KeepNinePatch} {
+		out, err := ReplaceMetaWithOptions(mrs, Metadata{MetaTitle: "button"}, Options{Policy: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = GetNinePatch(out)
+		if kept := err == nil; kept != (policy != nil) {
+			t.Errorf("ReplaceMeta with KeepNinePatch %t kept npTc: %t", policy != nil, kept)
+		}
+	}
+
+	if _, err = MarshalChunk(NinePatch{XDivs: []int32{1}}); err == nil {
+		t.Errorf("MarshalChunk accepted an odd number of divisions")
+	}
+}
@@ -0,0 +1,430 @@
+package pngutil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+Options configures the WithOptions variants of the package's
+entry points. The zero value behaves exactly like the plain
+functions, so fields can be added here without breaking any
+existing callers.
+
+Not every field applies to every function. Each field names
+the functions which read it, meaning their WithOptions variants,
+apart from Limits, Context and AssertLevel which most functions
+read. Each function also documents the fields it consults and
+ignores the rest.
+*/
+type Options struct {
+
+	// Policy decides which existing chunks ReplaceMeta keeps. Nil means DefaultPolicy.
+	Policy Policy
+
+	// Placement decides where ReplaceMeta writes new metadata chunks.
+	Placement Placement
+
+	/*
+		Limits bounds the input a call will accept. Zero fields are
+		unlimited, and if all are zero DefaultLimits is used.
+	*/
+	Limits Limits
+
+	/*
+		Materialize has ReplaceMeta read its result into memory
+		before it is returned so that it no longer depends on
+		the input reader, which may then be altered or closed.
+	*/
+	Materialize bool
+
+	// Context cancels long-running work. Nil means context.Background.
+	Context context.Context
+
+	/*
+		Parallel has WriteFile write the output of ReplaceMeta
+		to disk with several concurrent writers. See WriteToAt.
+	*/
+	Parallel bool
+
+	/*
+		Sidecar has WriteFile and ReplaceMetaFile write a JSON
+		summary beside the PNG. See ExportSidecar.
+	*/
+	Sidecar bool
+
+	/*
+		FieldMap has ImportEXIF also write the EXIF fields it
+		maps to keywords as text. MapEXIF and MapXMP take a
+		FieldMap directly and don't read this one.
+	*/
+	FieldMap FieldMap
+
+	/*
+		Template, if non-nil, has ReplaceMeta and ApplyManifest
+		expand metadata values as templates when written. Width
+		and Height are filled in from the image if zero. See
+		ExpandMeta.
+	*/
+	Template *TemplateData
+
+	/*
+		OnDiscard, if non-nil, is called by ReplaceMeta with the
+		type and offset of each existing chunk it discards, so
+		callers can report what a rewrite removed.
+	*/
+	OnDiscard func(chunkType string, offset int64)
+
+	/*
+		Sources are layers of metadata ReplaceMeta merges beneath
+		the metadata it is given, lowest precedence first; for
+		example file defaults followed by per-batch values. See
+		MergeSources.
+	*/
+	Sources []Metadata
+
+	/*
+		Entries are extra text chunks ReplaceMeta writes after
+		the metadata, such as per-language variants of one
+		keyword. See ReadLocalized.
+	*/
+	Entries []Entry
+
+	// Compress has StoreBlob and SaveState deflate the payloads they write.
+	Compress bool
+
+	/*
+		SanitizeName has WriteFile replace characters and device
+		names that are unusable on any platform in the final
+		element of the file name, rather than failing with a
+		*NameError.
+	*/
+	SanitizeName bool
+
+	/*
+		Schema, if non-nil, has ReplaceMeta refuse to write
+		metadata which fails ValidateMeta, checking it after
+		Sources are merged and Template is expanded. Each of
+		Entries is checked as another value of its keyword.
+	*/
+	Schema *Schema
+
+	/*
+		History, if non-nil, has ReplaceMeta carry over the
+		provenance history of its input with this event added.
+		See AppendHistory.
+	*/
+	History *Event
+
+	/*
+		ZTXt lists keywords ReplaceMeta writes as compressed
+		zTXt chunks rather than iTXt, which suits large values
+		such as embedded JSON. zTXt can only hold Latin-1 text.
+	*/
+	ZTXt []string
+
+	/*
+		CompressText lists keywords ReplaceMeta writes as iTXt
+		chunks with their text compressed. Unlike ZTXt any text
+		can be compressed this way.
+	*/
+	CompressText []string
+
+	/*
+		SanitizeKeywords has ReplaceMeta make keywords valid with
+		SanitizeKeyword rather than failing with a *KeywordError.
+	*/
+	SanitizeKeywords bool
+
+	/*
+		Duplicates decides how ReadMeta collapses repeated
+		keywords. ReadMetaValues and ReadLocalized, which keep
+		or group repeats themselves, don't read it.
+	*/
+	Duplicates DuplicatePolicy
+
+	/*
+		SplitText is the most bytes of text ReplaceMeta writes
+		in one iTXt chunk. Longer values are split, without
+		dividing characters, across consecutive iTXt chunks with
+		the same keyword, the later ones tagged ContinuedLanguage,
+		which ReadMeta rejoins. Values too long for a single chunk
+		are always split this way. Zero means as much as fits.
+	*/
+	SplitText int
+
+	/*
+		AssertLevel decides how thoroughly Assert, and every
+		function which calls it first, checks its input.
+	*/
+	AssertLevel AssertLevel
+}
+
+func (o Options) context() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+func (o Options) limits() Limits {
+	if o.Limits == (Limits{}) {
+		return DefaultLimits
+	}
+	return o.Limits
+}
+
+func (o Options) policy() Policy {
+	if o.Policy == nil {
+		return DefaultPolicy
+	}
+	return o.Policy
+}
+
+/*
+Policy reports whether an existing chunk of the given type should
+be kept when a PNG is rewritten. Critical chunks are always kept
+so a Policy can't produce an undecodable image, and operations
+don't consult it for chunks they replace themselves; ReplaceMeta
+always discards existing text chunks, for example.
+*/
+type Policy func(chunkType string) bool
+
+/*
+DefaultPolicy keeps the critical chunks (IHDR, PLTE, IDAT and
+IEND), the chunks describing the colour space of HDR images
+(cICP, mDCV and cLLI), without which they render incorrectly,
+and the acTL, fcTL and fdAT chunks of animated PNGs, discarding
+all other ancillary chunks.
+*/
+func DefaultPolicy(chunkType string) bool {
+	return retain[chunkType] || hdrChunks[chunkType] || apngChunks[chunkType]
+}
+
+// Ancillary chunks DefaultPolicy keeps.
+var (
+	hdrChunks = map[string]bool{
+		"cICP": true,
+		"cLLI": true,
+		"mDCV": true,
+	}
+	apngChunks = map[string]bool{
+		"acTL": true,
+		"fcTL": true,
+		"fdAT": true,
+	}
+)
+
+/*
+StripAnimation is like DefaultPolicy but discards the animation
+chunks, leaving an animated PNG as a still of its default image.
+*/
+func StripAnimation(chunkType string) bool {
+	return !apngChunks[chunkType] && DefaultPolicy(chunkType)
+}
+
+/*
+SafeToCopy keeps the chunks kept by DefaultPolicy as well as
+every ancillary chunk whose safe-to-copy bit is set (a lower
+case fourth letter), such as private application data, as the
+spec permits editors to copy such chunks unexamined.
+*/
+func SafeToCopy(chunkType string) bool {
+	if DefaultPolicy(chunkType) {
+		return true
+	}
+	t := ChunkType(chunkType)
+	return t.Ancillary() && t.SafeToCopy()
+}
+
+/*
+Keep returns a Policy which keeps chunks of the given types in
+addition to those kept by DefaultPolicy.
+*/
+func Keep(types ...string) Policy {
+	keep := make(map[string]bool, len(types))
+	for _, typ := range types {
+		keep[typ] = true
+	}
+	return func(chunkType string) bool {
+		return keep[chunkType] || DefaultPolicy(chunkType)
+	}
+}
+
+/*
+AssertLevel trades the cost of checking a PNG against the
+assurance the check gives.
+*/
+type AssertLevel int
+
+const (
+	/*
+		AssertQuick checks the signature, IHDR's position and
+		length and the final IEND chunk, reading only the head
+		and tail of the stream.
+	*/
+	AssertQuick AssertLevel = iota
+
+	/*
+		AssertStructure also walks the chunk headers, checking
+		each chunk type is well formed and placed where the spec
+		requires, and that the chunks run exactly to the final
+		IEND chunk. Chunk data isn't read.
+	*/
+	AssertStructure
+
+	/*
+		AssertFull also checks the fields of IHDR are legal and
+		the CRC of every chunk, reading the entire stream.
+	*/
+	AssertFull
+
+	/*
+		AssertImageData also inflates the image data, as
+		VerifyImageData does, which is by far the most costly
+		check.
+	*/
+	AssertImageData
+)
+
+// Placement determines where new chunks are written in the output.
+type Placement int
+
+const (
+	PlaceAfterIHDR  Placement = iota // immediately after the IHDR chunk
+	PlaceBeforeIEND                  // immediately before the IEND chunk, after the image data
+)
+
+/*
+Limits bounds the size of the input a call will accept so that
+untrusted files can't cause unbounded work. A zero field means
+no limit is applied.
+*/
+type Limits struct {
+	MaxChunks     int    // maximum number of chunks, including IHDR and IEND
+	MaxChunkSize  uint32 // maximum length in bytes of any one chunk's data
+	MaxTextBytes  int64  // maximum total bytes of text chunk data read or written, and of compressed text once inflated
+	MaxDimensions uint32 // maximum width or height in pixels
+	MaxTotalSize  int64  // maximum size in bytes of the input
+
+	/*
+		MaxPixels and MaxDecodedBytes guard against decompression
+		bombs, small files whose image data inflates to far more
+		memory than a server should give a decoder. They bound
+		the width multiplied by the height and the size of the
+		inflated image data IHDR implies, which includes the
+		filter byte starting each row.
+	*/
+	MaxPixels       int64
+	MaxDecodedBytes int64
+}
+
+/*
+DefaultLimits applies wherever Options.Limits is the zero value,
+which includes every function that doesn't accept Options, so a
+program handling untrusted files can bound them all in one place.
+It is unlimited unless set, which should be done before the
+package is used.
+*/
+var DefaultLimits Limits
+
+const maxInt = int(^uint(0) >> 1)
+
+// ErrLimitExceeded is wrapped by all errors caused by exceeding Limits.
+var ErrLimitExceeded = errors.New("pngutil: limit exceeded")
+
+func (l Limits) checkTotalSize(size int64) error {
+	if l.MaxTotalSize > 0 && size > l.MaxTotalSize {
+		return fmt.Errorf("%w: size %d is over maximum of %d", ErrLimitExceeded, size, l.MaxTotalSize)
+	}
+	return nil
+}
+
+func (l Limits) checkChunkSize(h chunkHeader) error {
+	if l.MaxChunkSize > 0 && h.length > l.MaxChunkSize {
+		return fmt.Errorf("%w: %s chunk at offset %d has length %d, over maximum of %d",
+			ErrLimitExceeded, h.typ, h.offset, h.length, l.MaxChunkSize)
+	}
+	return nil
+}
+
+func (l Limits) checkText(n int64) error {
+	if l.MaxTextBytes > 0 && n > l.MaxTextBytes {
+		return fmt.Errorf("%w: more than %d bytes of text", ErrLimitExceeded, l.MaxTextBytes)
+	}
+	return nil
+}
+
+/*
+textBudget returns the bytes of text left once used have been
+read, or -1 if MaxTextBytes is unlimited.
+*/
+func (l Limits) textBudget(used int64) int64 {
+	switch {
+	case l.MaxTextBytes <= 0:
+		return -1
+	case used > l.MaxTextBytes:
+		return 0
+	}
+	return l.MaxTextBytes - used
+}
+
+func (l Limits) checkDimensions(width, height uint32) error {
+	if l.MaxDimensions > 0 && (width > l.MaxDimensions || height > l.MaxDimensions) {
+		return fmt.Errorf("%w: dimensions %dx%d are over maximum of %d", ErrLimitExceeded, width, height, l.MaxDimensions)
+	}
+	return nil
+}
+
+func (l Limits) checkImage(h ImageInfo) error {
+	if err := l.checkDimensions(h.Width, h.Height); err != nil {
+		return err
+	}
+	if pixels := int64(h.Width) * int64(h.Height); l.MaxPixels > 0 && pixels > l.MaxPixels {
+		return fmt.Errorf("%w: %d pixels is over maximum of %d", ErrLimitExceeded, pixels, l.MaxPixels)
+	}
+	if size := h.rawSize(); l.MaxDecodedBytes > 0 && size > l.MaxDecodedBytes {
+		return fmt.Errorf("%w: image data decodes to %d bytes, over maximum of %d", ErrLimitExceeded, size, l.MaxDecodedBytes)
+	}
+	return nil
+}
+
+func (l Limits) checkChunks(n int) error {
+	if l.MaxChunks > 0 && n > l.MaxChunks {
+		return fmt.Errorf("%w: more than %d chunks", ErrLimitExceeded, l.MaxChunks)
+	}
+	return nil
+}
+
+/*
+materialize drains mrs into memory and returns a new reader over
+the result which no longer shares any state with the original.
+*/
+func materialize(ctx context.Context, mrs *multiReadSeeker) (*multiReadSeeker, error) {
+	if mrs.Size() > int64(maxInt) {
+		return nil, fmt.Errorf("%w: %d bytes won't fit in memory", ErrLimitExceeded, mrs.Size())
+	}
+	bb := make([]byte, mrs.Size())
+	for n := 0; n < len(bb); {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		end := n + 32*1024
+		if end > len(bb) {
+			end = len(bb)
+		}
+		count, err := io.ReadFull(mrs, bb[n:end])
+		n += count
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newMultiReadSeeker(&skipReadSeeker{
+		name: "materialized",
+		rs:   bytes.NewReader(bb),
+		end:  int64(len(bb)),
+	})
+}
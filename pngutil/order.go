@@ -0,0 +1,170 @@
+package pngutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Ancillary chunks which must precede PLTE, and so IDAT.
+var beforePLTE = map[string]bool{
+	"cHRM": true,
+	"cICP": true,
+	"cLLI": true,
+	"gAMA": true,
+	"iCCP": true,
+	"mDCV": true,
+	"sBIT": true,
+	"sRGB": true,
+}
+
+// Ancillary chunks which must follow PLTE, if any, and precede IDAT.
+var afterPLTE = map[string]bool{
+	"bKGD": true,
+	"hIST": true,
+	"tRNS": true,
+}
+
+// Ancillary chunks which must precede IDAT.
+var beforeIDAT = map[string]bool{
+	"acTL": true,
+	"eXIf": true,
+	"oFFs": true,
+	"pCAL": true,
+	"pHYs": true,
+	"sCAL": true,
+	"sPLT": true,
+	"sTER": true,
+}
+
+// Chunks which may appear at most once.
+var uniqueChunks = map[string]bool{
+	"IHDR": true,
+	"PLTE": true,
+	"IEND": true,
+	"acTL": true,
+	"bKGD": true,
+	"cHRM": true,
+	"cICP": true,
+	"cLLI": true,
+	"eXIf": true,
+	"gAMA": true,
+	"hIST": true,
+	"iCCP": true,
+	"mDCV": true,
+	"oFFs": true,
+	"pCAL": true,
+	"pHYs": true,
+	"sBIT": true,
+	"sCAL": true,
+	"sRGB": true,
+	"sTER": true,
+	"tIME": true,
+	"tRNS": true,
+}
+
+/*
+orderState tracks the chunks of a stream seen so far in order
+to check each following chunk against the ordering rules of
+the spec.
+*/
+type orderState struct {
+	seen     map[string]bool
+	last     string
+	idatDone bool // whether a chunk has followed the run of IDAT chunks
+}
+
+/*
+next records a chunk of type typ, returning an error giving the
+reason if it may not follow the chunks recorded before it, in
+which case it isn't recorded.
+*/
+func (s *orderState) next(typ string) (err error) {
+
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	defer func() {
+		if err != nil {
+			return
+		}
+		if s.last == "IDAT" && typ != "IDAT" {
+			s.idatDone = true
+		}
+		s.seen[typ] = true
+		s.last = typ
+	}()
+
+	switch {
+	case s.seen["IEND"]:
+		return errors.New("follows IEND")
+	case s.last == "" && typ != "IHDR":
+		return errors.New("precedes IHDR")
+	case s.last != "" && typ == "IHDR":
+		return errors.New("isn't first")
+	case uniqueChunks[typ] && s.seen[typ]:
+		return fmt.Errorf("isn't the only %s chunk", typ)
+	case typ == "IDAT" && s.idatDone:
+		return errors.New("isn't consecutive with other IDAT chunks")
+	case typ == "IEND" && !s.seen["IDAT"]:
+		return errors.New("precedes IDAT")
+	case (typ == "PLTE" || beforePLTE[typ]) && s.seen["IDAT"]:
+		return errors.New("follows IDAT")
+	case beforePLTE[typ] && s.seen["PLTE"]:
+		return errors.New("follows PLTE")
+	case (afterPLTE[typ] || beforeIDAT[typ]) && s.seen["IDAT"]:
+		return errors.New("follows IDAT")
+	case typ == "hIST" && !s.seen["PLTE"]:
+		return errors.New("precedes PLTE")
+	}
+	if typ == "PLTE" {
+		for _, t := range []string{"bKGD", "hIST", "tRNS"} {
+			if s.seen[t] {
+				return fmt.Errorf("follows %s", t)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ChunkError describes a problem with a particular chunk of a PNG
+stream.
+*/
+type ChunkError struct {
+	Type   string // chunk type, e.g. "PLTE"
+	Offset int64  // offset of the chunk's length field
+	Err    error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("pngutil: %s chunk at offset %d: %v", e.Type, e.Offset, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+/*
+ValidateOrder checks the chunks of rs against the ordering rules
+of the spec: IHDR first, IDAT chunks consecutive, PLTE before
+IDAT, chunks such as gAMA and iCCP before PLTE, chunks such as
+tRNS and bKGD after PLTE and before IDAT, at most one of chunks
+such as gAMA, sRGB and tIME, and so on. Every violation is
+reported as a *ChunkError; they're returned joined by
+errors.Join in the order the chunks appear.
+*/
+func ValidateOrder(rs io.ReadSeeker) error {
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return err
+	}
+	var s orderState
+	var errs []error
+	for _, h := range idx {
+		if err := s.next(h.typ); err != nil {
+			errs = append(errs, &ChunkError{Type: h.typ, Offset: h.offset, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,435 @@
+package pngutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+Transparency represents a tRNS chunk. For indexed images Alpha
+holds the alpha of the palette entries in order; entries beyond
+it are opaque. For greyscale and truecolour images Color holds
+the single sample value, or red, green and blue sample values,
+of the colour to treat as fully transparent. Only one of Alpha
+and Color is set.
+*/
+type Transparency struct {
+	Alpha []uint8
+	Color []uint16
+}
+
+func (tr Transparency) ChunkType() string {
+	return "tRNS"
+}
+
+func (tr Transparency) MarshalChunk() ([]byte, error) {
+	if tr.Alpha != nil && tr.Color != nil {
+		return nil, fmt.Errorf("pngutil: tRNS chunk can't have both palette alpha and a colour")
+	}
+	if tr.Color != nil {
+		if len(tr.Color) != 1 && len(tr.Color) != 3 {
+			return nil, fmt.Errorf("pngutil: tRNS colour has %d samples, want 1 or 3", len(tr.Color))
+		}
+		data := make([]byte, 0, 6)
+		for _, v := range tr.Color {
+			data = binary.BigEndian.AppendUint16(data, v)
+		}
+		return data, nil
+	}
+	if len(tr.Alpha) > 256 {
+		return nil, fmt.Errorf("pngutil: tRNS chunk has %d palette entries, want at most 256", len(tr.Alpha))
+	}
+	return append([]byte{}, tr.Alpha...), nil
+}
+
+/*
+UnmarshalChunk can't tell from the chunk alone which form it
+takes, so chunks of two bytes are read as a greyscale colour,
+six bytes as a truecolour one and any others as palette alpha.
+Use GetTransparency, which consults the colour type of the
+image, to read a tRNS chunk reliably.
+*/
+func (tr *Transparency) UnmarshalChunk(data []byte) error {
+	switch len(data) {
+	case 2:
+		return tr.unmarshal(data, ColorGray)
+	case 6:
+		return tr.unmarshal(data, ColorRGB)
+	}
+	return tr.unmarshal(data, ColorIndexed)
+}
+
+func (tr *Transparency) unmarshal(data []byte, colorType uint8) error {
+	*tr = Transparency{}
+	switch colorType {
+	case ColorIndexed:
+		if len(data) > 256 {
+			return fmt.Errorf("pngutil: tRNS chunk has %d palette entries, want at most 256", len(data))
+		}
+		tr.Alpha = append([]uint8{}, data...)
+		return nil
+	case ColorGray, ColorRGB:
+		if want := 2 * (1 + int(colorType)); len(data) != want {
+			return fmt.Errorf("pngutil: tRNS chunk has length %d, want %d for colour type %d", len(data), want, colorType)
+		}
+		for i := 0; i < len(data); i += 2 {
+			tr.Color = append(tr.Color, binary.BigEndian.Uint16(data[i:]))
+		}
+		return nil
+	}
+	return fmt.Errorf("pngutil: tRNS chunk isn't permitted for colour type %d", colorType)
+}
+
+/*
+check returns an error unless tr suits an image with header h
+whose palette, if it has one, has entries entries.
+*/
+func (tr Transparency) check(h ImageInfo, entries int) error {
+	switch h.ColorType {
+	case ColorIndexed:
+		if tr.Color != nil {
+			return fmt.Errorf("pngutil: tRNS colour given for indexed image")
+		}
+		if len(tr.Alpha) > entries {
+			return fmt.Errorf("pngutil: tRNS chunk has %d entries but palette has %d", len(tr.Alpha), entries)
+		}
+	case ColorGray, ColorRGB:
+		if want := 1 + int(h.ColorType); len(tr.Color) != want {
+			return fmt.Errorf("pngutil: tRNS colour has %d samples, want %d for colour type %d", len(tr.Color), want, h.ColorType)
+		}
+		for _, v := range tr.Color {
+			if v>>h.BitDepth != 0 {
+				return fmt.Errorf("pngutil: tRNS sample %d exceeds bit depth %d", v, h.BitDepth)
+			}
+		}
+	default:
+		return fmt.Errorf("pngutil: tRNS chunk isn't permitted for colour type %d", h.ColorType)
+	}
+	return nil
+}
+
+/*
+checkPalette returns an error unless an image with header h may
+have a PLTE chunk of length n, where -1 means it has none. The
+spec requires a palette for indexed images, forbids one for
+greyscale images, and permits one as a suggestion for truecolour
+images.
+*/
+func checkPalette(h ImageInfo, n int64) error {
+	if n < 0 {
+		if h.ColorType == ColorIndexed {
+			return fmt.Errorf("%w: indexed image has no PLTE chunk", ErrNoChunk)
+		}
+		return nil
+	}
+	if h.ColorType == ColorGray || h.ColorType == ColorGrayAlpha {
+		return fmt.Errorf("pngutil: PLTE chunk isn't permitted for colour type %d", h.ColorType)
+	}
+	if n == 0 || n%3 != 0 || n > 3*256 {
+		return fmt.Errorf("pngutil: PLTE chunk has length %d, want a multiple of 3 from 3 to 768", n)
+	}
+	if h.ColorType == ColorIndexed && n/3 > 1<<h.BitDepth {
+		return fmt.Errorf("pngutil: PLTE chunk has %d entries, more than bit depth %d can index", n/3, h.BitDepth)
+	}
+	return nil
+}
+
+/*
+SetTransparency returns f with its tRNS chunk set to tr, which is
+checked against the colour type, bit depth and palette of f and
+placed after PLTE and before IDAT as the spec requires. All other
+chunks are kept byte for byte.
+
+ReplaceMeta discards tRNS by default, which makes transparent
+images opaque; pass a Policy keeping "tRNS" to preserve it.
+*/
+func SetTransparency(f io.ReadSeeker, tr Transparency) (*multiReadSeeker, error) {
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, err
+	}
+	h, err := readIHDR(f, idx)
+	if err != nil {
+		return nil, err
+	}
+	entries := 0
+	for _, c := range idx {
+		if c.typ == "PLTE" {
+			entries = int(c.length / 3)
+			break
+		}
+	}
+	if err = tr.check(h, entries); err != nil {
+		return nil, err
+	}
+	return setChunk(f, tr, "IDAT")
+}
+
+/*
+GetTransparency returns the contents of the tRNS chunk of rs,
+interpreted according to its colour type, or an error wrapping
+ErrNoChunk if it has none.
+*/
+func GetTransparency(rs io.ReadSeeker) (tr Transparency, err error) {
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return tr, err
+	}
+	h, err := readIHDR(rs, idx)
+	if err != nil {
+		return tr, err
+	}
+	for _, c := range idx {
+		if c.typ != "tRNS" {
+			continue
+		}
+		data, err := readChunkData(rs, c)
+		if err != nil {
+			return tr, err
+		}
+		err = tr.unmarshal(data, h.ColorType)
+		return tr, err
+	}
+	return tr, fmt.Errorf("%w: no tRNS chunk", ErrNoChunk)
+}
+
+/*
+SuggestedPalette represents an sPLT chunk, a named palette an
+application with a limited number of colours may use. Depth is
+the sample depth of the entries, 8 or 16. An image may carry
+several, distinguished by Name, which follows the rules for text
+chunk keywords.
+*/
+type SuggestedPalette struct {
+	Name    string
+	Depth   uint8
+	Entries []PaletteEntry
+}
+
+/*
+PaletteEntry is a colour of a SuggestedPalette. Samples are no
+greater than 255 in palettes of depth 8. Frequency is relative
+to the other entries' and zero if unknown.
+*/
+type PaletteEntry struct {
+	R, G, B, A uint16
+	Frequency  uint16
+}
+
+func (sp SuggestedPalette) ChunkType() string {
+	return "sPLT"
+}
+
+func (sp SuggestedPalette) MarshalChunk() ([]byte, error) {
+	name, err := encodeKeyword(sp.Name)
+	if err != nil {
+		return nil, err
+	}
+	if sp.Depth != 8 && sp.Depth != 16 {
+		return nil, fmt.Errorf("pngutil: sPLT palette %q has sample depth %d, want 8 or 16", sp.Name, sp.Depth)
+	}
+	data := append(name, 0, sp.Depth)
+	for _, e := range sp.Entries {
+		for _, v := range [4]uint16{e.R, e.G, e.B, e.A} {
+			if sp.Depth == 16 {
+				data = binary.BigEndian.AppendUint16(data, v)
+				continue
+			}
+			if v > 0xFF {
+				return nil, fmt.Errorf("pngutil: sPLT palette %q has sample %d exceeding depth 8", sp.Name, v)
+			}
+			data = append(data, uint8(v))
+		}
+		data = binary.BigEndian.AppendUint16(data, e.Frequency)
+	}
+	return data, nil
+}
+
+func (sp *SuggestedPalette) UnmarshalChunk(data []byte) error {
+	name, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(rest) < 1 {
+		return errors.New("pngutil: sPLT chunk has no name separator and sample depth")
+	}
+	*sp = SuggestedPalette{Name: latin1ToUTF8(name), Depth: rest[0]}
+	size := 6
+	switch sp.Depth {
+	case 8:
+	case 16:
+		size = 10
+	default:
+		return fmt.Errorf("pngutil: sPLT palette %q has sample depth %d, want 8 or 16", sp.Name, sp.Depth)
+	}
+	rest = rest[1:]
+	if len(rest)%size != 0 {
+		return fmt.Errorf("pngutil: sPLT palette %q has %d bytes of entries, not a multiple of %d", sp.Name, len(rest), size)
+	}
+	sp.Entries = make([]PaletteEntry, 0, len(rest)/size)
+	for ; len(rest) > 0; rest = rest[size:] {
+		var s [4]uint16
+		for i := range s {
+			if sp.Depth == 16 {
+				s[i] = binary.BigEndian.Uint16(rest[i*2:])
+			} else {
+				s[i] = uint16(rest[i])
+			}
+		}
+		sp.Entries = append(sp.Entries, PaletteEntry{s[0], s[1], s[2], s[3], binary.BigEndian.Uint16(rest[size-2:])})
+	}
+	return nil
+}
+
+/*
+SetSuggestedPalette returns f with sp in place of the sPLT chunk
+of the same name, or if there's none, with sp added before IDAT
+as the spec requires. All other chunks, including suggested
+palettes with other names, are kept byte for byte.
+*/
+func SetSuggestedPalette(f io.ReadSeeker, sp SuggestedPalette) (*multiReadSeeker, error) {
+
+	data, err := sp.MarshalChunk()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxChunkLength {
+		return nil, fmt.Errorf("%w: sPLT palette %q is too large for one chunk", ErrLimitExceeded, sp.Name)
+	}
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Find a palette of the same name, else the first IDAT.
+	at := -1
+	for i, h := range idx {
+		if at < 0 && h.typ == "IDAT" {
+			at = i
+		}
+		if h.typ != "sPLT" {
+			continue
+		}
+		p, err := readChunkData(f, h)
+		if err != nil {
+			return nil, err
+		}
+		if name, _, _ := bytes.Cut(p, []byte{0}); latin1ToUTF8(name) == sp.Name {
+			at = i
+			break
+		}
+	}
+
+	a := newAssembler(f, len(idx)+2)
+	a.copyRange(0, idx[0].offset)
+	for i, h := range idx {
+		if i != at {
+			a.copyChunk(h)
+			continue
+		}
+		a.write("sPLT", AppendChunk(nil, "sPLT", data))
+		if h.typ != "sPLT" {
+			a.copyChunk(h)
+		}
+	}
+	return a.finish()
+}
+
+/*
+GetSuggestedPalettes returns the palettes held in the sPLT
+chunks of rs in the order they appear, or none if it has none.
+*/
+func GetSuggestedPalettes(rs io.ReadSeeker) ([]SuggestedPalette, error) {
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return nil, err
+	}
+	var sps []SuggestedPalette
+	for _, h := range idx {
+		if h.typ != "sPLT" {
+			continue
+		}
+		data, err := readChunkData(rs, h)
+		if err != nil {
+			return nil, err
+		}
+		var sp SuggestedPalette
+		if err = sp.UnmarshalChunk(data); err != nil {
+			return nil, err
+		}
+		sps = append(sps, sp)
+	}
+	return sps, nil
+}
+
+/*
+Histogram represents a hIST chunk, which gives the approximate
+usage frequency of each palette entry. It has one value for
+every entry of the palette.
+*/
+type Histogram []uint16
+
+func (hist Histogram) ChunkType() string {
+	return "hIST"
+}
+
+func (hist Histogram) MarshalChunk() ([]byte, error) {
+	if len(hist) < 1 || len(hist) > 256 {
+		return nil, fmt.Errorf("pngutil: hIST chunk has %d entries, want 1 to 256", len(hist))
+	}
+	data := make([]byte, 0, 2*len(hist))
+	for _, v := range hist {
+		data = binary.BigEndian.AppendUint16(data, v)
+	}
+	return data, nil
+}
+
+func (hist *Histogram) UnmarshalChunk(data []byte) error {
+	if len(data) < 2 || len(data) > 512 || len(data)%2 != 0 {
+		return fmt.Errorf("pngutil: hIST chunk has invalid length %d", len(data))
+	}
+	*hist = make(Histogram, len(data)/2)
+	for i := range *hist {
+		(*hist)[i] = binary.BigEndian.Uint16(data[i*2:])
+	}
+	return nil
+}
+
+/*
+SetHistogram returns f with its hIST chunk set to hist, which
+must have as many entries as the palette of f. It's placed after
+PLTE and before IDAT as the spec requires. All other chunks are
+kept byte for byte.
+*/
+func SetHistogram(f io.ReadSeeker, hist Histogram) (*multiReadSeeker, error) {
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, err
+	}
+	entries := -1
+	for _, h := range idx {
+		if h.typ == "PLTE" {
+			entries = int(h.length / 3)
+			break
+		}
+	}
+	if entries < 0 {
+		return nil, fmt.Errorf("%w: no PLTE chunk for hIST chunk to describe", ErrNoChunk)
+	}
+	if len(hist) != entries {
+		return nil, fmt.Errorf("pngutil: hIST chunk has %d entries but palette has %d", len(hist), entries)
+	}
+	return setChunk(f, hist, "IDAT")
+}
+
+/*
+GetHistogram returns the frequencies recorded in the hIST chunk
+of rs, or an error wrapping ErrNoChunk if it has none.
+*/
+func GetHistogram(rs io.ReadSeeker) (Histogram, error) {
+	var hist Histogram
+	if err := getChunk(rs, &hist); err != nil {
+		return nil, err
+	}
+	return hist, nil
+}
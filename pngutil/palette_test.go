@@ -0,0 +1,156 @@
+package pngutil
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// encodePNG returns img encoded as a PNG.
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// palettedPNG returns an indexed image with an opaque palette of n entries.
+func palettedPNG(t *testing.T, n int) []byte {
+	t.Helper()
+	pal := make(color.Palette, n)
+	for i := range pal {
+		pal[i] = color.NRGBA{uint8(i), uint8(i), uint8(i), 255}
+	}
+	return encodePNG(t, image.NewPaletted(image.Rect(0, 0, 4, 4), pal))
+}
+
+func TestTransparency(t *testing.T) {
+
+	indexed := palettedPNG(t, 4)
+	gray := encodePNG(t, image.NewGray(image.Rect(0, 0, 4, 4)))
+
+	cases := []struct {
+		in  []byte
+		tr  Transparency
+		err bool
+	}{
+		{indexed, Transparency{Alpha: []uint8{0, 128}}, false},
+		{indexed, Transparency{Alpha: make([]uint8, 5)}, true},
+		{indexed, Transparency{Color: []uint16{0}}, true},
+		{gray, Transparency{Color: []uint16{255}}, false},
+		{gray, Transparency{Color: []uint16{256}}, true},
+		{gray, Transparency{Color: []uint16{1, 2, 3}}, true},
+		{testPNG(t), Transparency{Color: []uint16{1, 2, 3}}, true},
+	}
+
+	for _, c := range cases {
+		mrs, err := SetTransparency(bytes.NewReader(c.in), c.tr)
+		if (err != nil) != c.err {
+			t.Errorf("SetTransparency(%+v)\n    have err: %v\n    want err: %t\n", c.tr, err, c.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if have, err := GetTransparency(mrs); err != nil || !reflect.DeepEqual(have, c.tr) {
+			t.Errorf("GetTransparency\n    have: %+v, err: %v\n    want: %+v\n", have, err, c.tr)
+		}
+		if err = ValidateOrder(mrs); err != nil {
+			t.Errorf("SetTransparency(%+v) misplaced tRNS: %v", c.tr, err)
+		}
+	}
+}
+
+func TestTransparencyUnmarshal(t *testing.T) {
+
+	cases := []struct {
+		data []byte
+		want Transparency
+	}{
+		{[]byte{0, 9}, Transparency{Color: []uint16{9}}},
+		{[]byte{0, 1, 0, 2, 0, 3}, Transparency{Color: []uint16{1, 2, 3}}},
+		{[]byte{0, 128, 255}, Transparency{Alpha: []uint8{0, 128, 255}}},
+	}
+
+	for _, c := range cases {
+		var have Transparency
+		if err := have.UnmarshalChunk(c.data); err != nil || !reflect.DeepEqual(have, c.want) {
+			t.Errorf("UnmarshalChunk(%v)\n    have: %+v, err: %v\n    want: %+v\n", c.data, have, err, c.want)
+		}
+	}
+
+	gray := encodePNG(t, image.NewGray(image.Rect(0, 0, 4, 4)))
+	mrs, err := InsertChunk(bytes.NewReader(gray), "tRNS", []byte{0, 9}, Anchor{"IDAT", false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []any{&Transparency{Color: []uint16{9}}}
+	if have, err := DecodeChunks(mrs, "tRNS"); err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("DecodeChunks(gray, \"tRNS\")\n    have: %+v, err: %v\n    want: %+v\n", have, err, want)
+	}
+}
+
+func TestSuggestedPalette(t *testing.T) {
+
+	low := SuggestedPalette{"low colour", 8, []PaletteEntry{{255, 0, 0, 255, 10}, {0, 0, 0, 0, 0}}}
+	deep := SuggestedPalette{"deep", 16, []PaletteEntry{{0xFFFF, 0x8000, 1, 0xFFFF, 3}}}
+	replaced := SuggestedPalette{"low colour", 8, []PaletteEntry{}}
+
+	f := bytes.NewReader(testPNG(t))
+	var rs io.ReadSeeker = f
+	for _, sp := range []SuggestedPalette{low, deep, replaced} {
+		mrs, err := SetSuggestedPalette(rs, sp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs = mrs
+	}
+	want := []SuggestedPalette{replaced, deep}
+	if have, err := GetSuggestedPalettes(rs); err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("GetSuggestedPalettes\n    have: %+v, err: %v\n    want: %+v\n", have, err, want)
+	}
+	if err := ValidateOrder(rs); err != nil {
+		t.Errorf("SetSuggestedPalette misplaced sPLT: %v", err)
+	}
+
+	bad := []SuggestedPalette{
+		{"", 8, nil},
+		{"name", 4, nil},
+		{"name", 8, []PaletteEntry{{256, 0, 0, 0, 0}}},
+	}
+	for _, sp := range bad {
+		if _, err := SetSuggestedPalette(f, sp); err == nil {
+			t.Errorf("SetSuggestedPalette(%+v) succeeded, want error", sp)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+
+	in := palettedPNG(t, 3)
+	want := Histogram{10, 0, 65535}
+	mrs, err := SetHistogram(bytes.NewReader(in), want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := GetHistogram(mrs); err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("GetHistogram\n    have: %v, err: %v\n    want: %v\n", have, err, want)
+	}
+	if err = ValidateOrder(mrs); err != nil {
+		t.Errorf("SetHistogram misplaced hIST: %v", err)
+	}
+
+	if _, err = SetHistogram(bytes.NewReader(in), Histogram{1, 2}); err == nil {
+		t.Errorf("SetHistogram accepted a histogram shorter than the palette")
+	}
+	if _, err = SetHistogram(bytes.NewReader(testPNG(t)), Histogram{1}); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("SetHistogram without PLTE\n    have err: %v\n    want err: %v\n", err, ErrNoChunk)
+	}
+}
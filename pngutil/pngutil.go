@@ -2,6 +2,15 @@
 Package pngutil provides a simple way to handle some common
 tasks with PNGs such as replacing metadata and checking magic
 bytes.
+
+Files of any size up to the limits of io.Seeker are supported,
+including those over 4 GB; offsets are always int64 and chunk
+data isn't read unless an operation needs it. Each chunk holds
+at most 2^31-1 bytes of data as the spec requires, and lengths
+above that are rejected rather than wrapped, whether read from
+a file or produced by the package. Operations which hold a
+whole file in memory, such as Options.Materialize, fail with
+ErrLimitExceeded if it doesn't fit in an int.
 */
 package pngutil
 
@@ -14,10 +23,14 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
+	"sort"
 )
 
-const ihdrEnd int64 = 33 // the offset at which the IHDR chunk ends
+const (
+	ihdrEnd        int64 = 33        // the offset at which the IHDR chunk ends
+	maxChunkLength       = 1<<31 - 1 // largest chunk length permitted by the spec
+	maxDimension         = 1<<31 - 1 // largest width or height permitted by the spec
+)
 
 var (
 	header = []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}
@@ -46,9 +59,26 @@ first 8 bytes of the IHDR chunk, and the IEND
 chunk without reading the entire file.
 
 The current offset of rs is restored after Assert
-has completed its checks.
+has completed its checks. For more thorough checks
+pass an AssertLevel to AssertWithOptions. A PNG with
+data after IEND fails; TrimTrailingData removes it.
+Apple CgBI PNGs fail with ErrCgBI.
 */
 func Assert(rs io.ReadSeeker) (err error) {
+	return AssertWithOptions(rs, Options{})
+}
+
+/*
+AssertWithOptions is like Assert but accepts Options. It consults
+AssertLevel, Context and the MaxTotalSize, MaxDimensions,
+MaxPixels and MaxDecodedBytes fields of Limits, as well as
+MaxChunks and MaxChunkSize above AssertQuick.
+*/
+func AssertWithOptions(rs io.ReadSeeker, opts Options) (err error) {
+
+	if err = opts.context().Err(); err != nil {
+		return err
+	}
 
 	/*
 		Return seek offset to current position
@@ -60,7 +90,7 @@ func Assert(rs io.ReadSeeker) (err error) {
 	}
 	defer func() {
 		if _, sErr := rs.Seek(offset, io.SeekStart); sErr != nil {
-			err = fmt.Errorf("pngutil: %w: %s", err, sErr.Error())
+			err = errors.Join(err, fmt.Errorf("pngutil: couldn't restore offset %d: %w", offset, sErr))
 		}
 	}()
 
@@ -68,16 +98,35 @@ func Assert(rs io.ReadSeeker) (err error) {
 		return err
 	}
 
-	p := make([]byte, 16, 16)
-	if _, err = rs.Read(p); err != nil {
+	lim := opts.limits()
+	p := make([]byte, 29, 29)
+	if _, err = io.ReadFull(rs, p); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
 		return err
 	}
 
-	if !bytes.Equal(p, append(header, ihdr...)) {
-		return errors.New("pngutil: missing header or IHDR chunk")
+	if !bytes.Equal(p[:16], append(header, ihdr...)) {
+		switch f := Sniff(p); f {
+		case FormatCgBI:
+			return ErrCgBI
+		case FormatUnknown, FormatPNG:
+			return errors.New("pngutil: missing header or IHDR chunk")
+		default:
+			return fmt.Errorf("pngutil: not a PNG, file appears to be %s", f)
+		}
+	}
+	h, err := parseIHDR(p[16:29])
+	if err != nil {
+		return err
+	}
+	if err = lim.checkImage(h); err != nil {
+		return err
 	}
 
-	if _, err = rs.Seek(-12, io.SeekEnd); err != nil {
+	end, err := rs.Seek(-12, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if err = lim.checkTotalSize(end + 12); err != nil {
 		return err
 	}
 
@@ -89,6 +138,61 @@ func Assert(rs io.ReadSeeker) (err error) {
 		return errors.New("pngutil: missing IEND chunk at end of file")
 	}
 
+	if opts.AssertLevel > AssertQuick {
+		return assertChunks(rs, end+12, opts)
+	}
+	return nil
+}
+
+/*
+assertChunks makes the checks of AssertStructure and, if opts
+asks for them, AssertFull and AssertImageData on rs, which is
+size bytes long.
+*/
+func assertChunks(rs io.ReadSeeker, size int64, opts Options) error {
+
+	idx, err := scanChunks(opts.context(), rs, opts.limits())
+	if err != nil {
+		return err
+	}
+	var s orderState
+	for _, h := range idx {
+		if !validChunkType(h.typ) {
+			return &ChunkError{Type: h.typ, Offset: h.offset, Err: errors.New("invalid chunk type")}
+		}
+		if err = s.next(h.typ); err != nil {
+			return &ChunkError{Type: h.typ, Offset: h.offset, Err: err}
+		}
+	}
+	if len(idx) == 0 || idx[len(idx)-1].typ != "IEND" || idx[len(idx)-1].end() != size {
+		return errors.New("pngutil: chunks don't run to the IEND chunk at end of file")
+	}
+	if opts.AssertLevel < AssertFull {
+		return nil
+	}
+
+	h, err := readIHDR(rs, idx)
+	if err != nil {
+		return err
+	}
+	if err = h.Check(); err != nil {
+		return err
+	}
+	for _, h := range idx {
+		if err = opts.context().Err(); err != nil {
+			return err
+		}
+		stored, actual, err := checkCRC(rs, h)
+		if err != nil {
+			return err
+		}
+		if stored != actual {
+			return &CRCError{Type: h.typ, Offset: h.offset, Stored: stored, Actual: actual}
+		}
+	}
+	if opts.AssertLevel >= AssertImageData {
+		return verifyImageData(opts.context(), rs, idx)
+	}
 	return nil
 }
 
@@ -111,6 +215,32 @@ const (
 
 type Metadata map[string]string
 
+/*
+MergeSources returns a new Metadata holding the keywords of base
+and each overlay, where a keyword in a later overlay takes
+precedence over the same keyword in base or an earlier overlay.
+An empty value in an overlay removes the keyword from the result,
+allowing per-file overrides to suppress defaults.
+
+None of the arguments are modified.
+*/
+func MergeSources(base Metadata, overlays ...Metadata) Metadata {
+	merged := make(Metadata, len(base))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for _, o := range overlays {
+		for k, v := range o {
+			if v == "" {
+				delete(merged, k)
+				continue
+			}
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
 /*
 ReplaceMeta takes a PNG file represented by f and returns
 a readseeker mrs which is the same file with only the supplied
@@ -126,115 +256,309 @@ Since mrs is a wrapper around the new metadata and f, altering
 f will affect mrs. Therefore callers are recommended to drain
 mrs before altering f.
 
-The metadata is assigned to an iTXt chunk at the start of the
-file.
+The metadata is assigned to iTXt chunks at the start of the
+file, written in keyword order so output is reproducible.
+Keywords must be 1 to 79 printable Latin-1 characters without
+leading, trailing or consecutive spaces, as the spec requires,
+or a *KeywordError is returned. Only the chunks kept by
+DefaultPolicy, which are the critical chunks, cICP, mDCV and
+cLLI, and the animation chunks, and those registered with
+RegisterChunk for retention are kept from f. The animation
+chunks are kept or discarded together, as the Policy decides
+for acTL, so pass StripAnimation to reduce an animated PNG to a
+still.
+
+If an Apple iDOT chunk is kept via Options.Policy its offsets are
+adjusted to account for any chunks discarded after it.
 */
 func ReplaceMeta(f io.ReadSeeker, metadata Metadata) (mrs *multiReadSeeker, err error) {
+	return ReplaceMetaWithOptions(f, metadata, Options{})
+}
+
+/*
+ReplaceMetaWithOptions is like ReplaceMeta but accepts Options.
+It consults Policy to decide which non-text chunks survive,
+Placement for where the metadata goes, Limits which is applied
+to f and the text written, Materialize to detach the result
+from f, Template to expand metadata values, and Context to
+cancel the scan of f.
+
+If Sources is non-empty the metadata written is Sources merged
+in order with metadata on top, as if by MergeSources. Entries
+are written after metadata, followed by the history if History
+is set. Keywords listed in ZTXt are written as zTXt chunks and
+those in CompressText as compressed iTXt chunks. SanitizeKeywords
+repairs invalid keywords instead of rejecting them. Values longer
+than SplitText are split across chunks. Schema is checked
+against the metadata and Entries actually written.
+*/
+func ReplaceMetaWithOptions(f io.ReadSeeker, metadata Metadata, opts Options) (mrs *multiReadSeeker, err error) {
 
-	if err = Assert(f); err != nil {
+	if err = AssertWithOptions(f, opts); err != nil {
 		return nil, err
 	}
+	if opts.Placement != PlaceAfterIHDR && opts.Placement != PlaceBeforeIEND {
+		return nil, fmt.Errorf("pngutil: unknown placement %d", opts.Placement)
+	}
 
-	// Pre-calculate length of our iTXt chunks.
-	itxtLen := 0
+	idx, err := scanChunks(opts.context(), f, opts.limits())
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Sources) > 0 {
+		layers := append(append([]Metadata{}, opts.Sources[1:]...), metadata)
+		metadata = MergeSources(opts.Sources[0], layers...)
+	}
+	if opts.Template != nil && len(metadata) > 0 {
+		if metadata, err = expandMeta(f, idx[0], metadata, *opts.Template); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.SanitizeKeywords {
+		if metadata, opts.Entries, err = sanitizeKeywords(metadata, opts.Entries); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Schema != nil {
+		if err = validateMeta(metadata, opts.Entries, *opts.Schema); err != nil {
+			return nil, err
+		}
+	}
+
+	// Count the text written after merging and expansion.
+	var textBytes int64
 	for k, v := range metadata {
-		itxtLen += 4      // chunk length
-		itxtLen += 4      // chunk type
-		itxtLen += len(k) // keyword
-		itxtLen += 5      // null separtors, compression flags, languages
-		itxtLen += len(v) // text
-		itxtLen += 4      // chunk CRC
+		textBytes += int64(len(k) + len(v))
+	}
+	for _, e := range opts.Entries {
+		textBytes += int64(len(e.Keyword) + len(e.Text))
+	}
+	if err = opts.limits().checkText(textBytes); err != nil {
+		return nil, err
 	}
 
 	/*
-		Make byte slice of that length with 8
-		bytes extra for scratch space below.
+		An animation is only playable with all of its chunks so
+		the Policy's choice for acTL applies to fcTL and fdAT.
 	*/
-	bb := make([]byte, itxtLen+8)
-	i := 0
-	for k, v := range metadata {
-		start := i                              // save start offset of this chunk
-		i += 4                                  // skip length
-		i += copy(bb[i:], itxt)                 // chunk type
-		i += copy(bb[i:], k)                    // keyword
-		i += 5                                  // skip null separators, compression flags, languages
-		i += copy(bb[i:], v)                    // text
-		length := uint32(i - (start + 8))       // calculate length
-		int32ToBytes(bb[start:start+4], length) // add length
-		crc := crc32.NewIEEE()
-		crc.Write(bb[start+4 : start+8+int(length)]) // input chunk type + data
-		int32ToBytes(bb[i:], crc.Sum32())            // calculate CRC
-		i += 4                                       // add CRC length
+	policy := opts.policy()
+	animated := policy("acTL")
+	keep := func(typ string) bool {
+		if textChunks[typ] || (opts.History != nil && typ == historyChunk) {
+			return false
+		}
+		if apngChunks[typ] {
+			return animated
+		}
+		return retain[typ] || registeredRetain(typ) || policy(typ)
 	}
 
-	// Alias scratch space at the end of the metadata buffer.
-	p := bb[i:]
-
-	// Seek to end of IHDR chunk (PNG 8 byte header, 13 byte IHDR chunk)
-	if _, err = f.Seek(ihdrEnd, io.SeekStart); err != nil {
-		return nil, err
+	/*
+		A retained dSIG chunk must remain immediately after
+		IHDR so the header reader is extended to include it,
+		placing the metadata inside the signed span.
+	*/
+	headerEnd := ihdrEnd
+	rest := idx[1:]
+	if len(rest) > 0 && rest[0].typ == "dSIG" && keep("dSIG") {
+		headerEnd = rest[0].end()
+		rest = rest[1:]
 	}
-	readers := []*skipReadSeeker{
-		&skipReadSeeker{
-			name: "header",
-			rs:   f,
-			end:  ihdrEnd,
-		},
-		&skipReadSeeker{
-			name: "metadata",
-			rs:   bytes.NewReader(bb[:len(bb)-8]),
-			end:  int64(len(bb) - 8),
-		},
+
+	/*
+		Consecutive retained chunks share a reader so count
+		the runs of them up front in order to allocate the
+		readers slice exactly once.
+	*/
+	runs := 0
+	kept := false
+	for _, h := range rest {
+		if keep(h.typ) && !kept {
+			runs++
+		}
+		kept = keep(h.typ)
 	}
-	pos := ihdrEnd
-	keptPrevChunk := false
 
-	for {
+	readers := make([]*skipReadSeeker, 0, runs+3)
+	readers = append(readers, &skipReadSeeker{
+		name: "header",
+		rs:   f,
+		end:  headerEnd,
+	})
 
-		// Read next 8 bytes.
-		n, err := f.Read(p)
-		if errors.Is(err, io.EOF) {
-			break
-		}
+	/*
+		Stripping all metadata is by far the most common
+		call so don't build a metadata reader for it.
+	*/
+	var metaReader *skipReadSeeker
+	if len(metadata) > 0 || len(opts.Entries) > 0 || opts.History != nil {
+		bb, err := encodeText(metadata, opts)
 		if err != nil {
 			return nil, err
 		}
-		if n != 8 {
-			return nil, errors.New("pngutil: couldn't read next chunk length and type")
+		if opts.History != nil {
+			if bb, err = encodeHistory(bb, f, *opts.History); err != nil {
+				return nil, err
+			}
 		}
+		metaReader = &skipReadSeeker{
+			name: "metadata",
+			rs:   bytes.NewReader(bb),
+			end:  int64(len(bb)),
+		}
+	}
+	if metaReader != nil && opts.Placement == PlaceAfterIHDR {
+		readers = append(readers, metaReader)
+	}
 
-		length := int64(binary.BigEndian.Uint32(p[0:4])) + 4 // add 4 for CRC
+	// Skip IHDR since it's covered by the header reader.
+	var idot chunkHeader
+	kept = false
+	for _, h := range rest {
 
 		// Discard chunk.
-		chunk := string(p[4:8])
-		if !retain[chunk] {
-			keptPrevChunk = false
-			goto skip
+		if !keep(h.typ) {
+			if opts.OnDiscard != nil {
+				opts.OnDiscard(h.typ, h.offset)
+			}
+			kept = false
+			continue
+		}
+		if h.typ == "iDOT" {
+			idot = h
 		}
 
 		// Concat this chunk to the previous.
-		if keptPrevChunk {
-			last := len(readers) - 1
-			readers[last].end = pos
-		} else { // Otherwise add new chunk.
-			readers = append(readers, &skipReadSeeker{
-				name:  "chunk",
-				rs:    f,
-				start: pos,
-				end:   pos + length,
-			})
+		if kept {
+			readers[len(readers)-1].end = h.end()
+			continue
+		}
+
+		// Otherwise add new chunk.
+		readers = append(readers, &skipReadSeeker{
+			name:  "chunk",
+			rs:    f,
+			start: h.offset,
+			end:   h.end(),
+		})
+		kept = true
+	}
+
+	/*
+		Placed before IEND the metadata still belongs inside
+		the span of a retained dSIG pair, so it goes before
+		the closing dSIG.
+	*/
+	if metaReader != nil && opts.Placement == PlaceBeforeIEND {
+		at := idx[len(idx)-1].offset
+		if headerEnd != ihdrEnd && len(rest) > 1 && rest[len(rest)-2].typ == "dSIG" {
+			at = rest[len(rest)-2].offset
+		}
+		readers = insertReader(readers, f, at, metaReader)
+	}
+
+	/*
+		Apple's iDOT chunk holds offsets to IDAT chunks which
+		discarding chunks between them may have invalidated.
+	*/
+	if idot.typ != "" {
+		if readers, err = fixIDOT(f, idot, readers); err != nil {
+			return nil, err
 		}
-		keptPrevChunk = true
+	}
+
+	if mrs, err = newMultiReadSeeker(readers...); err != nil {
+		return nil, err
+	}
+	if opts.Materialize {
+		return materialize(opts.context(), mrs)
+	}
+	return mrs, nil
+}
+
+/*
+insertReader returns readers with r inserted at offset at of f,
+splitting the reader covering that offset if necessary. at must
+fall within a retained range of f.
+*/
+func insertReader(readers []*skipReadSeeker, f io.ReadSeeker, at int64, r *skipReadSeeker) []*skipReadSeeker {
+	for i, sr := range readers {
+		if sr.rs != f || at < sr.start || at >= sr.end {
+			continue
+		}
+		out := make([]*skipReadSeeker, 0, len(readers)+2)
+		out = append(out, readers[:i]...)
+		if at > sr.start {
+			out = append(out, &skipReadSeeker{name: sr.name, rs: f, start: sr.start, end: at})
+		}
+		out = append(out, r, &skipReadSeeker{name: sr.name, rs: f, start: at, end: sr.end})
+		return append(out, readers[i+1:]...)
+	}
+	return append(readers, r)
+}
+
+/*
+encodeMeta returns metadata encoded as a series of iTXt chunks
+in keyword order, so the same metadata always encodes the same.
+*/
+func encodeMeta(metadata Metadata) ([]byte, error) {
+
+	keys := sortedKeys(metadata)
 
-	skip:
-		if pos, err = f.Seek(length, io.SeekCurrent); err != nil {
+	// Keywords are Latin-1 so encode them up front.
+	keywords := make(map[string][]byte, len(metadata))
+	for _, k := range keys {
+		kw, err := encodeKeyword(k)
+		if err != nil {
 			return nil, err
 		}
+		keywords[k] = kw
+	}
+
+	// Pre-calculate length of our iTXt chunks.
+	itxtLen := 0
+	for k, v := range metadata {
+		if len(keywords[k])+5+len(v) > maxChunkLength {
+			return nil, fmt.Errorf("%w: text of keyword %q is too large for one chunk", ErrLimitExceeded, k)
+		}
+		itxtLen += 4                // chunk length
+		itxtLen += 4                // chunk type
+		itxtLen += len(keywords[k]) // keyword
+		itxtLen += 5                // null separtors, compression flags, languages
+		itxtLen += len(v)           // text
+		itxtLen += 4                // chunk CRC
+	}
+
+	bb := make([]byte, itxtLen)
+	i := 0
+	for _, k := range keys {
+		v := metadata[k]
+		start := i                              // save start offset of this chunk
+		i += 4                                  // skip length
+		i += copy(bb[i:], itxt)                 // chunk type
+		i += copy(bb[i:], keywords[k])          // keyword
+		i += 5                                  // skip null separators, compression flags, languages
+		i += copy(bb[i:], v)                    // text
+		length := uint32(i - (start + 8))       // calculate length
+		int32ToBytes(bb[start:start+4], length) // add length
+		crc := crc32.NewIEEE()
+		crc.Write(bb[start+4 : start+8+int(length)]) // input chunk type + data
+		int32ToBytes(bb[i:], crc.Sum32())            // calculate CRC
+		i += 4                                       // add CRC length
 	}
 
-	readers[len(readers)-1].end = pos
+	return bb, nil
+}
 
-	return newMultiReadSeeker(readers...)
+// sortedKeys returns the keywords of m in ascending order.
+func sortedKeys(m Metadata) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 var retain = map[string]bool{
@@ -244,20 +568,25 @@ var retain = map[string]bool{
 	"IEND": true,
 }
 
+// Chunk types holding textual metadata, which ReplaceMeta always replaces.
+var textChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+}
+
 func int32ToBytes(p []byte, n uint32) {
 	binary.BigEndian.PutUint32(p, n)
 }
 
+/*
+closeFile closes c and joins any resulting error with the one
+pointed to by err so that neither is lost to errors.Is/As.
+*/
 func closeFile(c io.Closer, err *error) {
-	cErr := c.Close()
-	if err == nil {
-		*err = cErr
-		return
+	if cErr := c.Close(); cErr != nil {
+		*err = errors.Join(*err, fmt.Errorf("pngutil: %w", cErr))
 	}
-	if cErr == nil {
-		return
-	}
-	*err = fmt.Errorf("%w: %v", *err, cErr)
 }
 
 /*
@@ -265,14 +594,35 @@ WriteFile drains r and writes it to a new file
 at name, returning the number of bytes it wrote
 and an error, if any.
 
-If name doesn't already end in ".png" WriteFile
-will add it to the end.
+If name doesn't already end in ".png", in any
+case, WriteFile will add it to the end after
+removing any trailing dots or spaces. A name
+that can't be used on the current platform,
+such as "CON" on Windows, is a *NameError.
 */
 func WriteFile(name string, r io.Reader) (n int64, err error) {
+	return WriteFileWithOptions(name, r, Options{})
+}
 
-	if ext := filepath.Ext(name); ext != ".png" {
-		name = strings.TrimRight(name, ".")
-		name += ".png"
+/*
+WriteFileWithOptions is like WriteFile but accepts Options. It
+consults Context, which is checked between reads of r, and the
+MaxTotalSize field of Limits, which caps the bytes written.
+If Parallel is set and r was returned by ReplaceMeta, its
+contents are written concurrently using WriteToAt, checking
+Context before each piece. If Sidecar
+is set, ExportSidecar is called on the new file. SanitizeName
+makes name portable instead of rejecting it.
+*/
+func WriteFileWithOptions(name string, r io.Reader, opts Options) (n int64, err error) {
+
+	ctx := opts.context()
+	if err = ctx.Err(); err != nil {
+		return n, err
+	}
+
+	if name, err = pngName(name, opts.SanitizeName); err != nil {
+		return n, err
 	}
 
 	name, err = filepath.Abs(name)
@@ -280,18 +630,40 @@ func WriteFile(name string, r io.Reader) (n int64, err error) {
 		return n, fmt.Errorf("pngutil: %w", err)
 	}
 
+	// Registered before closeFile so it runs once the file is closed.
+	if opts.Sidecar {
+		defer func() {
+			if err == nil {
+				_, err = ExportSidecar(name)
+			}
+		}()
+	}
+
 	f, err := os.Create(name)
 	if err != nil {
 		return n, fmt.Errorf("pngutil: %w", err)
 	}
 	defer closeFile(f, &err)
 
+	if mrs, ok := r.(*multiReadSeeker); ok && opts.Parallel {
+		if err = opts.limits().checkTotalSize(mrs.Size()); err != nil {
+			return n, err
+		}
+		return mrs.writeToAt(ctx, f)
+	}
+
 	tr := io.TeeReader(r, f)
 	p := make([]byte, 64, 64)
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
 		count, err := tr.Read(p)
 		n += int64(count)
+		if lErr := opts.limits().checkTotalSize(n); lErr != nil {
+			return n, lErr
+		}
 		if errors.Is(err, io.EOF) {
 			break
 		}
@@ -2,11 +2,81 @@ package pngutil
 
 import (
 	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
+/*
+testPNG returns a small encoded image with any extra chunks
+inserted directly after the IHDR chunk.
+*/
+func testPNG(t *testing.T, extra ...[]byte) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	out := append([]byte{}, b[:ihdrEnd]...)
+	for _, c := range extra {
+		out = append(out, c...)
+	}
+	return append(out, b[ihdrEnd:]...)
+}
+
+// testChunk frames data as a chunk of type typ.
+func testChunk(typ string, data []byte) []byte {
+	c := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(c[0:4], uint32(len(data)))
+	copy(c[4:8], typ)
+	c = append(c, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(c[4:]))
+	return append(c, crc...)
+}
+
+// chunkTypes returns the type of each chunk in rs.
+func chunkTypes(t *testing.T, rs io.ReadSeeker) []string {
+	t.Helper()
+	infos, err := Chunks(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make([]string, len(infos))
+	for i, ci := range infos {
+		types[i] = ci.Type
+	}
+	return types
+}
+
 func TestSkipReadSeeker(t *testing.T) {
 
 	cases := []struct {
@@ -90,7 +160,9 @@ func TestMultiReadSeeker(t *testing.T) {
 		val    []byte
 	}{
 		{6, 4, io.SeekStart, false, []byte{6, 7, 8, 9}},
-		{20, 0, io.SeekStart, true, []byte{0, 0, 0, 0}}, // EOF
+		{20, 0, io.SeekStart, false, []byte{0, 0, 0, 0}}, // EOF
+		{16, 0, io.SeekStart, false, []byte{0, 0, 0, 0}}, // EOF
+		{0, 0, io.SeekEnd, false, []byte{0, 0, 0, 0}},    // EOF
 		{-2, 2, io.SeekEnd, true, []byte{14, 15, 0, 0}},
 		{6, 4, io.SeekCurrent, false, []byte{6, 7, 8, 9}},
 		{-1, 0, io.SeekStart, true, []byte{0, 0, 0, 0}},
@@ -157,3 +229,1733 @@ func TestMultiReadSeeker(t *testing.T) {
 			c.read, c.val, errStr)
 	}
 }
+
+func TestAttachHash(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00Old")))
+	mrs, err := ReplaceMeta(bytes.NewReader(in), Metadata{MetaAuthor: "Someone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := mrs.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.Sum256(out)
+
+	// Attaching away from the start waits for a seek back to it.
+	h := sha256.New()
+	mrs.AttachHash(h)
+	if _, err = io.Copy(io.Discard, mrs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mrs.Sums(); err == nil {
+		t.Errorf("Sums succeeded after attaching at EOF, want error")
+	}
+
+	cases := []struct {
+		name  string
+		read  func() error
+		valid bool
+	}{
+		{"drained", func() error {
+			_, err := io.Copy(io.Discard, mrs)
+			return err
+		}, true},
+		{"partial", func() error {
+			_, err := io.CopyN(io.Discard, mrs, 10)
+			return err
+		}, false},
+		{"seeked past", func() error {
+			if _, err := io.CopyN(io.Discard, mrs, 10); err != nil {
+				return err
+			}
+			if _, err := mrs.Seek(20, io.SeekStart); err != nil {
+				return err
+			}
+			_, err := io.Copy(io.Discard, mrs)
+			return err
+		}, false},
+		{"restarted", func() error {
+			if _, err := io.CopyN(io.Discard, mrs, 10); err != nil {
+				return err
+			}
+			if _, err := mrs.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			_, err := io.Copy(io.Discard, mrs)
+			return err
+		}, true},
+	}
+
+	for _, c := range cases {
+		if _, err = mrs.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if err = c.read(); err != nil {
+			t.Fatal(err)
+		}
+		sums, err := mrs.Sums()
+		if !c.valid {
+			if err == nil {
+				t.Errorf("Sums(%s) succeeded, want error", c.name)
+			}
+			continue
+		}
+		if err != nil || len(sums) != 1 || !bytes.Equal(sums[0], want[:]) {
+			t.Errorf("Sums(%s)\n    have: %x, err: %v\n    want: [%x]\n", c.name, sums, err, want)
+		}
+	}
+}
+
+func TestVerifyReader(t *testing.T) {
+
+	good := testPNG(t, testChunk("tEXt", []byte("Title\x00Verify")))
+	corrupt := append([]byte{}, good...)
+	corrupt[ihdrEnd+12] ^= 0xFF // flip a byte of tEXt data
+	truncated := good[:len(good)-6]
+	trailing := append(append([]byte{}, good...), 0)
+
+	cases := []struct {
+		name string
+		in   []byte
+		crc  bool // want *CRCError
+		err  bool
+	}{
+		{"good", good, false, false},
+		{"corrupt", corrupt, true, true},
+		{"truncated", truncated, false, true},
+		{"trailing", trailing, false, true},
+	}
+
+	for _, c := range cases {
+		n, err := io.Copy(ioutil.Discard, NewVerifyReader(bytes.NewReader(c.in)))
+		var crcErr *CRCError
+		if (err != nil) != c.err || errors.As(err, &crcErr) != c.crc {
+			t.Errorf("NewVerifyReader(%s)\n"+
+				"    have n: %d, err: %v\n"+
+				"    want CRC error: %t, error: %t\n",
+				c.name, n, err, c.crc, c.err)
+		}
+	}
+}
+
+func TestReplaceMeta(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00Old")))
+
+	// Retained chunks on either side of a discarded one.
+	split := testPNG(t,
+		testChunk("PLTE", []byte{0, 0, 0}),
+		testChunk("tEXt", []byte("Title\x00Old")),
+	)
+
+	cases := []struct {
+		in   []byte
+		meta Metadata
+		size int
+	}{
+		{in, nil, len(in) - 21},
+		{in, Metadata{}, len(in) - 21},
+		{in, Metadata{MetaTitle: "New"}, len(in) - 21 + 25},
+		{split, nil, len(split) - 21},
+	}
+
+	for _, c := range cases {
+		mrs, err := ReplaceMeta(bytes.NewReader(c.in), c.meta)
+		if err != nil {
+			t.Errorf("ReplaceMeta(%v): %v", c.meta, err)
+			continue
+		}
+		out, err := mrs.Bytes()
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, NewVerifyReader(bytes.NewReader(out)))
+		}
+		if err == nil {
+			_, err = png.Decode(bytes.NewReader(out))
+		}
+		if err != nil || len(out) != c.size || int64(len(out)) != mrs.Size() {
+			t.Errorf("ReplaceMeta(%v)\n"+
+				"    have len: %d, size: %d, err: %v\n"+
+				"    want len: %d, size: %d, err: nil\n",
+				c.meta, len(out), mrs.Size(), err, c.size, c.size)
+		}
+	}
+}
+
+func TestReplaceMetaWithOptions(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00Old")), testChunk("gAMA", []byte{0, 0, 0xB1, 0x8F}))
+	keepGamma := func(typ string) bool { return typ == "gAMA" }
+
+	cases := []struct {
+		opts Options
+		size int
+		err  error
+	}{
+		{Options{}, len(in) - 21 - 16, nil},
+		{Options{Policy: keepGamma}, len(in) - 21, nil},
+		{Options{Materialize: true}, len(in) - 21 - 16, nil},
+		{Options{Limits: Limits{MaxChunks: 3}}, 0, ErrLimitExceeded},
+		{Options{Limits: Limits{MaxTotalSize: 10}}, 0, ErrLimitExceeded},
+	}
+
+	for _, c := range cases {
+		var size int64
+		mrs, err := ReplaceMetaWithOptions(bytes.NewReader(in), nil, c.opts)
+		if err == nil {
+			size, err = io.Copy(ioutil.Discard, NewVerifyReader(mrs))
+		}
+		if !errors.Is(err, c.err) || err == nil && size != int64(c.size) {
+			t.Errorf("ReplaceMetaWithOptions(%+v)\n"+
+				"    have size: %d, err: %v\n"+
+				"    want size: %d, err: %v\n",
+				c.opts, size, err, c.size, c.err)
+		}
+	}
+}
+
+func TestWriteFileParallel(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00Old")))
+	mrs, err := ReplaceMeta(bytes.NewReader(in), Metadata{MetaAuthor: "Someone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := mrs.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(t.TempDir(), "out.png")
+	n, err := WriteFileWithOptions(name, mrs, Options{Parallel: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := os.ReadFile(name)
+	if err != nil || n != int64(len(want)) || !bytes.Equal(have, want) {
+		t.Errorf("WriteFileWithOptions(Parallel)\n"+
+			"    have n: %d, len: %d, err: %v\n"+
+			"    want n: %d, len: %d, err: nil\n",
+			n, len(have), err, len(want), len(want))
+	}
+}
+
+func TestWriteToAtContext(t *testing.T) {
+
+	data := bytes.Repeat([]byte{1, 2, 3}, writeAtBlockSize)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sources := map[string]func() io.ReadSeeker{
+		"parallel":   func() io.ReadSeeker { return bytes.NewReader(data) },
+		"sequential": func() io.ReadSeeker { return struct{ io.ReadSeeker }{bytes.NewReader(data)} },
+	}
+	for name, src := range sources {
+		for _, ctx := range []context.Context{cancelled, context.Background()} {
+			mrs, err := newMultiReadSeeker(&skipReadSeeker{rs: src(), end: int64(len(data))})
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Create(filepath.Join(t.TempDir(), "out.png"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := mrs.writeToAt(ctx, f)
+			f.Close()
+			want := int64(len(data))
+			if ctx.Err() != nil {
+				want = 0
+			}
+			if n != want || !errors.Is(err, ctx.Err()) {
+				t.Errorf("writeToAt(%s, %v)\n    have n: %d, err: %v\n    want n: %d, err: %v\n", name, ctx.Err(), n, err, want, ctx.Err())
+			}
+		}
+	}
+}
+
+func TestWriteFileSidecar(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00Old")))
+	mrs, err := ReplaceMeta(bytes.NewReader(in), Metadata{MetaAuthor: "Someone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(t.TempDir(), "out")
+	if _, err = WriteFileWithOptions(name, mrs, Options{Sidecar: true}); err != nil {
+		t.Fatal(err)
+	}
+	bb, err := os.ReadFile(name + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sc Sidecar
+	if err = json.Unmarshal(bb, &sc); err != nil {
+		t.Fatal(err)
+	}
+	if sc.Name != "out.png" || sc.Width != 4 || sc.Chunks["iTXt"] != 1 ||
+		!reflect.DeepEqual(sc.Metadata, Metadata{MetaAuthor: "Someone"}) {
+		t.Errorf("WriteFileWithOptions(Sidecar) wrote unexpected sidecar:\n%s", bb)
+	}
+}
+
+func TestReplaceMetaTemplate(t *testing.T) {
+
+	when := time.Date(2025, time.March, 1, 9, 0, 0, 0, time.UTC)
+	meta := Metadata{
+		MetaCopyright: "© {{.Time.Year}} Studio",
+		MetaComment:   "{{.Filename}} {{.Width}}x{{.Height}} for {{.Fields.client}}",
+		MetaAuthor:    "Plain",
+	}
+	tmpl := &TemplateData{Filename: "a.png", Time: when, Fields: map[string]string{"client": "ACME"}}
+	want := Metadata{
+		MetaCopyright: "© 2025 Studio",
+		MetaComment:   "a.png 4x4 for ACME",
+		MetaAuthor:    "Plain",
+	}
+
+	mrs, err := ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), meta, Options{Template: tmpl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := NewSidecar(mrs)
+	if err != nil || !reflect.DeepEqual(sc.Metadata, want) {
+		t.Errorf("ReplaceMetaWithOptions(Template)\n"+
+			"    have: %v, err: %v\n"+
+			"    want: %v, err: nil\n",
+			sc.Metadata, err, want)
+	}
+
+	meta = Metadata{MetaTitle: "{{.Missing}}"}
+	if _, err = ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), meta, Options{Template: tmpl}); err == nil {
+		t.Errorf("ReplaceMetaWithOptions accepted a template referencing a missing field")
+	}
+}
+
+func TestReplaceMetaIDOT(t *testing.T) {
+
+	text := testChunk("tEXt", []byte("Title\x00Old"))
+	idot := testChunk("iDOT", encodeIDOT([]IDOTSegment{{Rows: 4, Offset: 28 + uint32(len(text))}}))
+	in := testPNG(t, idot, text)
+
+	var discarded []string
+	opts := Options{
+		Policy:    KeepIDOT,
+		OnDiscard: func(typ string, _ int64) { discarded = append(discarded, typ) },
+	}
+	mrs, err := ReplaceMetaWithOptions(bytes.NewReader(in), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segs, err := GetIDOT(mrs)
+	if err != nil || segs[0].Offset != 28 || !reflect.DeepEqual(discarded, []string{"tEXt"}) {
+		t.Errorf("ReplaceMetaWithOptions(KeepIDOT)\n"+
+			"    have segments: %+v, discarded: %v, err: %v\n"+
+			"    want offset: 28, discarded: [tEXt], err: nil\n",
+			segs, discarded, err)
+	}
+	if _, err = mrs.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.Copy(ioutil.Discard, NewVerifyReader(mrs)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetIDOT(t *testing.T) {
+
+	// An iDOT chunk as written by macOS for a 4 row image split in
+	// two, directly followed by the IDAT holding the first band.
+	apple := []byte{
+		0, 0, 0, 2, // segments
+		0, 0, 0, 0, // reserved
+		0, 0, 0, 2, // rows in the first segment
+		0, 0, 0, 0x28, // offset of the first segment's IDAT
+		0, 0, 0, 2, // first row of the second segment
+		0, 0, 0, 2, // rows in the second segment
+		0, 0, 0, 0x5b, // offset of the second segment's IDAT
+	}
+	want := []IDOTSegment{{0, 2, 0x28}, {2, 2, 0x5b}}
+
+	cases := []struct {
+		in   []byte
+		want []IDOTSegment
+		err  bool
+	}{
+		{testPNG(t, testChunk("iDOT", apple)), want, false},
+		{testPNG(t, testChunk("iDOT", apple[:24])), nil, true},
+		{testPNG(t, testChunk("iDOT", make([]byte, 16))), nil, true},
+	}
+
+	for i, c := range cases {
+		have, err := GetIDOT(bytes.NewReader(c.in))
+		if (err != nil) != c.err || !reflect.DeepEqual(have, c.want) {
+			t.Errorf("GetIDOT(case %d)\n    have: %+v, err: %v\n    want: %+v, err: %t\n", i, have, err, c.want, c.err)
+		}
+	}
+	if _, err := GetIDOT(bytes.NewReader(testPNG(t))); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("GetIDOT without iDOT\n    have err: %v\n    want err: %v\n", err, ErrNoChunk)
+	}
+}
+
+func TestDataURI(t *testing.T) {
+
+	in := testPNG(t)
+	uri, err := ToDataURI(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := FromDataURI(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(rs)
+	if err != nil || !bytes.Equal(in, out) {
+		t.Errorf("FromDataURI(ToDataURI(png)) didn't round trip: %v", err)
+	}
+
+	if _, err = ToDataURIWithOptions(bytes.NewReader(in), Options{Limits: Limits{MaxTotalSize: 10}}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("ToDataURIWithOptions ignored MaxTotalSize: %v", err)
+	}
+
+	enc := uri[len("data:image/png;base64,"):]
+	var wrapped strings.Builder
+	for line := enc; line != ""; {
+		n := len(line)
+		if n > 16 {
+			n = 16
+		}
+		wrapped.WriteString(line[:n] + "\r\n\t ")
+		line = line[n:]
+	}
+	raw := base64.RawStdEncoding.EncodeToString(in)
+	jpeg := base64.StdEncoding.EncodeToString(testJPEG(t, nil))
+
+	cases := []struct {
+		uri string
+		err bool
+	}{
+		{" DATA:image/PNG;base64," + enc + "\n", false},
+		{"data:image/png;name=a.png;base64," + enc, false},
+		{"data:image/png;base64," + wrapped.String(), false},
+		{"data:image/png;base64," + raw, false},
+		{"data:image/jpeg;base64," + enc, true},
+		{"data:;base64," + enc, true},
+		{"data:image/png," + enc, true},
+		{"data:image/png;charset=utf-8," + enc, true},
+		{"data:image/png;base64", true},
+		{"image/png;base64," + enc, true},
+		{"data:image/png;base64," + enc[:len(enc)-8] + "!!!!" + enc[len(enc)-4:], true},
+		{"data:image/png;base64," + jpeg, true},
+	}
+
+	for _, c := range cases {
+		rs, err := FromDataURI(c.uri)
+		if (err != nil) != c.err {
+			t.Errorf("FromDataURI(%.40q)\n    have err: %v\n    want err: %t\n", c.uri, err, c.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if out, err := io.ReadAll(rs); err != nil || !bytes.Equal(out, in) {
+			t.Errorf("FromDataURI(%.40q) decoded %d bytes, want %d, err: %v", c.uri, len(out), len(in), err)
+		}
+	}
+}
+
+func TestScanChunks(t *testing.T) {
+
+	var extra [][]byte
+	for i := 0; i < 200; i++ {
+		extra = append(extra, testChunk("tEXt", []byte("Comment\x00small")))
+	}
+	extra = append(extra, testChunk("prVt", make([]byte, 3*scanBufferSize)))
+	extra = append(extra, testChunk("tEXt", []byte("Comment\x00after")))
+	in := testPNG(t, extra...)
+
+	idx, err := scanChunks(context.Background(), bytes.NewReader(in), Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := int64(len(header))
+	for i, h := range idx {
+		if h.offset != pos {
+			t.Fatalf("scanChunks: chunk %d (%s) at offset %d, want %d", i, h.typ, h.offset, pos)
+		}
+		pos = h.end()
+	}
+	if pos != int64(len(in)) || len(idx) != 205 || idx[len(idx)-1].typ != "IEND" {
+		t.Errorf("scanChunks: have %d chunks ending at %d, want 205 ending at %d", len(idx), pos, len(in))
+	}
+}
+
+func TestMergeSources(t *testing.T) {
+
+	defaults := Metadata{MetaAuthor: "Studio", MetaCopyright: "© Studio", MetaSoftware: "pngutil"}
+	batch := Metadata{MetaAuthor: "Batch", MetaComment: "batch 7"}
+	file := Metadata{MetaAuthor: "Jo", MetaSoftware: ""}
+
+	want := Metadata{MetaAuthor: "Jo", MetaCopyright: "© Studio", MetaComment: "batch 7"}
+	if have := MergeSources(defaults, batch, file); !reflect.DeepEqual(have, want) {
+		t.Errorf("MergeSources\n    have: %v\n    want: %v\n", have, want)
+	}
+	if defaults[MetaAuthor] != "Studio" || len(defaults) != 3 {
+		t.Errorf("MergeSources modified its base: %v", defaults)
+	}
+
+	mrs, err := ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), file, Options{Sources: []Metadata{defaults, batch}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := NewSidecar(mrs)
+	if err != nil || !reflect.DeepEqual(sc.Metadata, want) {
+		t.Errorf("ReplaceMetaWithOptions(Sources)\n"+
+			"    have: %v, err: %v\n"+
+			"    want: %v, err: nil\n",
+			sc.Metadata, err, want)
+	}
+}
+
+func TestReadLocalized(t *testing.T) {
+
+	entries := []Entry{
+		{Keyword: MetaDescription, Language: "en", Text: "A cat"},
+		{Keyword: MetaDescription, Language: "de", Text: "Eine Katze"},
+		{Keyword: MetaDescription, Language: "ja", Text: "猫"},
+	}
+	mrs, err := ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), Metadata{MetaAuthor: "Jo"}, Options{Entries: entries})
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := ReadLocalized(mrs)
+	want := map[string]map[string]string{
+		MetaAuthor:      {"": "Jo"},
+		MetaDescription: {"en": "A cat", "de": "Eine Katze", "ja": "猫"},
+	}
+	if err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("ReadLocalized\n"+
+			"    have: %v, err: %v\n"+
+			"    want: %v, err: nil\n",
+			have, err, want)
+	}
+
+	bad := Options{Entries: []Entry{{Keyword: MetaTitle, Language: "en_GB"}}}
+	if _, err = ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), nil, bad); err == nil {
+		t.Errorf("ReplaceMetaWithOptions accepted language tag %q", "en_GB")
+	}
+}
+
+func TestBlob(t *testing.T) {
+
+	data := bytes.Repeat([]byte("save state "), 100)
+	for _, compress := range []bool{false, true} {
+		mrs, err := StoreBlobWithOptions(bytes.NewReader(testPNG(t, testChunk("myAp", []byte{0, 1}))), "myAp", data, Options{Compress: compress})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc, err := NewSidecar(mrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sc.Chunks["myAp"] != 1 {
+			t.Errorf("StoreBlob(Compress: %t) left %d myAp chunks, want 1", compress, sc.Chunks["myAp"])
+		}
+		have, err := LoadBlob(mrs, "myAp")
+		if err != nil || !bytes.Equal(have, data) {
+			t.Errorf("LoadBlob(Compress: %t)\n    have: %d bytes, err: %v\n    want: %d bytes, err: nil\n", compress, len(have), err, len(data))
+		}
+	}
+
+	for _, typ := range []string{"MYap", "mYap", "myAPx", "myap"} {
+		if _, err := StoreBlob(bytes.NewReader(testPNG(t)), typ, data); err == nil {
+			t.Errorf("StoreBlob accepted chunk type %q", typ)
+		}
+	}
+	if _, err := LoadBlob(bytes.NewReader(testPNG(t)), "myAp"); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("LoadBlob of missing chunk: have %v, want ErrNoChunk", err)
+	}
+}
+
+func TestState(t *testing.T) {
+
+	type save struct {
+		Level int
+		Name  string
+	}
+	mrs, err := SaveStateWithOptions(bytes.NewReader(testPNG(t)), "gmSv", 2, save{3, "Jo"}, Options{Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := LoadState(mrs, "gmSv")
+	if err != nil || st.Version != 2 {
+		t.Fatalf("LoadState: have %+v, err: %v, want version 2", st, err)
+	}
+
+	var have save
+	if err = st.Decode(3, &have); err != nil || have != (save{3, "Jo"}) {
+		t.Errorf("Decode\n    have: %+v, err: %v\n    want: %+v, err: nil\n", have, err, save{3, "Jo"})
+	}
+	if err = st.Decode(1, &have); !errors.Is(err, ErrNewerState) {
+		t.Errorf("Decode of newer state: have %v, want ErrNewerState", err)
+	}
+
+	truncated := testPNG(t, testChunk("gmSv", []byte{0, 0, 0, 0, 1, 0, 0, 0, 9, '{'}))
+	if _, err = LoadState(bytes.NewReader(truncated), "gmSv"); err == nil {
+		t.Errorf("LoadState accepted a truncated document")
+	}
+}
+
+func TestCopyVerified(t *testing.T) {
+
+	good := testPNG(t, testChunk("tEXt", []byte("a\x00b")))
+	corrupt := append([]byte{}, good...)
+	corrupt[ihdrEnd+8] ^= 0xFF // first byte of tEXt data
+	truncated := good[:len(good)-6]
+	trailing := append(append([]byte{}, good...), 0)
+
+	cases := []struct {
+		name string
+		in   []byte
+		n    int64
+		err  bool
+	}{
+		{"good", good, int64(len(good)), false},
+		{"corrupt", corrupt, ihdrEnd, true},
+		{"truncated", truncated, int64(len(good)) - 12, true},
+		{"trailing", trailing, int64(len(good)), true},
+		{"not png", []byte("GIF89a"), 0, true},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		n, err := CopyVerified(&buf, bytes.NewReader(c.in))
+		if n != c.n || (err != nil) != c.err || !bytes.Equal(buf.Bytes(), c.in[:n]) {
+			t.Errorf("CopyVerified(%s)\n"+
+				"    have: %d bytes, err: %v\n"+
+				"    want: %d bytes, err: %t\n",
+				c.name, n, err, c.n, c.err)
+		}
+	}
+}
+
+func TestPNGName(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		sanitize bool
+		want     string
+		err      bool
+	}{
+		{"out", false, "out.png", false},
+		{"out.PNG", false, "out.PNG", false},
+		{"out.", false, "out.png", false},
+		{"v1.2", false, "v1.2.png", false},
+		{"dir/...", false, "", true},
+		{"", false, "", true},
+		{"a\x00b", true, "", true},
+		{"dir/a:b?.png", true, "dir/a_b_.png", false},
+		{"con", true, "_con.png", false},
+		{"Aux.tar", true, "_Aux.tar.png", false},
+		{"console", true, "console.png", false},
+	}
+
+	for _, c := range cases {
+		have, err := pngName(filepath.FromSlash(c.name), c.sanitize)
+		var nameErr *NameError
+		if have != filepath.FromSlash(c.want) || (err != nil) != c.err || (err != nil && !errors.As(err, &nameErr)) {
+			t.Errorf("pngName(%q, %t)\n"+
+				"    have: %q, err: %v\n"+
+				"    want: %q, err: %t\n",
+				c.name, c.sanitize, have, err, c.want, c.err)
+		}
+	}
+}
+
+/*
+sparseFile is a read-only io.ReadSeeker whose bytes are zero
+except where covered by one of its extents, allowing tests on
+files too large to hold in memory.
+*/
+type sparseFile struct {
+	size    int64
+	pos     int64
+	extents map[int64][]byte
+}
+
+func (sf *sparseFile) Read(p []byte) (int, error) {
+	if sf.pos >= sf.size {
+		return 0, io.EOF
+	}
+	if rem := sf.size - sf.pos; int64(len(p)) > rem {
+		p = p[:rem]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	for off, b := range sf.extents {
+		if off < sf.pos+int64(len(p)) && off+int64(len(b)) > sf.pos {
+			if off >= sf.pos {
+				copy(p[off-sf.pos:], b)
+			} else {
+				copy(p, b[sf.pos-off:])
+			}
+		}
+	}
+	sf.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (sf *sparseFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += sf.pos
+	case io.SeekEnd:
+		offset += sf.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	sf.pos = offset
+	return offset, nil
+}
+
+func TestLargeFile(t *testing.T) {
+
+	// Three maximal IDAT chunks take the file past 4 GB.
+	base := testPNG(t)
+	sf := &sparseFile{extents: map[int64][]byte{0: base[:ihdrEnd]}}
+	pos := ihdrEnd
+	for i := 0; i < 3; i++ {
+		h := make([]byte, 8)
+		binary.BigEndian.PutUint32(h, maxChunkLength)
+		copy(h[4:], "IDAT")
+		sf.extents[pos] = h
+		pos += 12 + maxChunkLength
+	}
+	text := testChunk("tEXt", []byte("Title\x00big"))
+	sf.extents[pos] = append(text, iend...)
+	sf.size = pos + int64(len(text)+len(iend))
+
+	idx, err := indexPNG(sf, Options{})
+	if err != nil || len(idx) != 6 || idx[4].offset != pos || idx[5].end() != sf.size {
+		t.Fatalf("indexPNG: have %v, err: %v, want tEXt at %d", idx, err, pos)
+	}
+
+	mrs, err := ReplaceMeta(sf, Metadata{MetaTitle: "big"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := encodeMeta(Metadata{MetaTitle: "big"})
+	if want := sf.size - int64(len(text)) + int64(len(meta)); mrs.Size() != want {
+		t.Errorf("ReplaceMeta: have size %d, want %d", mrs.Size(), want)
+	}
+	tail := make([]byte, 12)
+	if _, err = mrs.Seek(-12, io.SeekEnd); err == nil {
+		_, err = io.ReadFull(mrs, tail)
+	}
+	if err != nil || !bytes.Equal(tail, iend) {
+		t.Errorf("ReplaceMeta: output ends with %x, err: %v, want IEND", tail, err)
+	}
+
+	// A length over 2^31-1 mustn't be taken as negative or wrapped.
+	binary.BigEndian.PutUint32(sf.extents[ihdrEnd], 1<<31)
+	if _, err = indexPNG(sf, Options{}); err == nil {
+		t.Errorf("indexPNG accepted chunk length %d", uint32(1<<31))
+	}
+}
+
+func TestRemoteFile(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00remote")), testChunk("blOb", make([]byte, 200*1024)))
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.ServeContent(w, r, "a.png", time.Time{}, bytes.NewReader(in))
+	}))
+	defer srv.Close()
+
+	rf, err := openURL(context.Background(), srv.Client(), srv.URL, 16*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := NewSidecar(rf)
+	if err != nil || sc.Size != int64(len(in)) || sc.Metadata[MetaTitle] != "remote" {
+		t.Errorf("NewSidecar(RemoteFile): have %+v, err: %v", sc, err)
+	}
+	if requests > 4 {
+		t.Errorf("NewSidecar(RemoteFile) made %d requests, want at most 4", requests)
+	}
+
+	have := make([]byte, len(in))
+	if _, err = rf.ReadAt(have, 0); err != nil || !bytes.Equal(have, in) {
+		t.Errorf("RemoteFile.ReadAt returned different bytes, err: %v", err)
+	}
+}
+
+func TestRemoteFileManyIDAT(t *testing.T) {
+
+	var extra [][]byte
+	for i := 0; i < 64; i++ {
+		extra = append(extra, testChunk("IDAT", make([]byte, 16*1024)))
+	}
+	in := testPNG(t, extra...)
+	var fetched int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int64
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+			if end >= int64(len(in)) {
+				end = int64(len(in)) - 1
+			}
+			fetched += end - start + 1
+		}
+		http.ServeContent(w, r, "a.png", time.Time{}, bytes.NewReader(in))
+	}))
+	defer srv.Close()
+
+	rf, err := openURL(context.Background(), srv.Client(), srv.URL, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewSidecar(rf); err != nil {
+		t.Fatal(err)
+	}
+	if fetched > int64(len(in))/8 {
+		t.Errorf("NewSidecar(RemoteFile) fetched %d of %d bytes, want at most %d", fetched, len(in), len(in)/8)
+	}
+}
+
+/*
+flakyReader fails every other read, first advancing its offset
+as a network filesystem might before reporting an error.
+*/
+type flakyReader struct {
+	*bytes.Reader
+	reads int
+}
+
+var errBlip = errors.New("blip")
+
+func (fr *flakyReader) Read(p []byte) (int, error) {
+	if fr.reads++; fr.reads%2 == 1 {
+		fr.Reader.Seek(1, io.SeekCurrent)
+		return 0, errBlip
+	}
+	return fr.Reader.Read(p)
+}
+
+// failingReader fails every read with err, counting them in reads.
+type failingReader struct {
+	*bytes.Reader
+	err   error
+	reads int
+}
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	fr.reads++
+	return 0, fr.err
+}
+
+func TestRetryReader(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00flaky")))
+	want, err := ReplaceMeta(bytes.NewReader(in), Metadata{MetaAuthor: "Jo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBytes, _ := want.Bytes()
+
+	rr := NewRetryReader(&flakyReader{Reader: bytes.NewReader(in)}, RetryPolicy{Backoff: time.Nanosecond})
+	mrs, err := ReplaceMeta(rr, Metadata{MetaAuthor: "Jo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := mrs.Bytes()
+	if err != nil || !bytes.Equal(have, wantBytes) {
+		t.Errorf("ReplaceMeta(RetryReader) output differs, err: %v", err)
+	}
+
+	rr = NewRetryReader(&flakyReader{Reader: bytes.NewReader(in)}, RetryPolicy{Attempts: 1})
+	if _, err = rr.Read(make([]byte, 8)); !errors.Is(err, errBlip) {
+		t.Errorf("RetryReader with 1 attempt: have %v, want %v", err, errBlip)
+	}
+
+	// Reads are resumed from the offset rs had when wrapped.
+	br := bytes.NewReader(in)
+	br.Seek(8, io.SeekStart)
+	rr = NewRetryReader(&flakyReader{Reader: br}, RetryPolicy{Backoff: time.Nanosecond})
+	p := make([]byte, 4)
+	if _, err = io.ReadFull(rr, p); err != nil || !bytes.Equal(p, in[8:12]) {
+		t.Errorf("RetryReader from offset 8\n    have: %v, err: %v\n    want: %v\n", p, err, in[8:12])
+	}
+
+	for _, want := range []error{context.Canceled, context.DeadlineExceeded} {
+		fr := &failingReader{Reader: bytes.NewReader(in), err: want}
+		rr = NewRetryReader(fr, RetryPolicy{Backoff: time.Nanosecond})
+		if _, err = rr.Read(p); !errors.Is(err, want) || fr.reads != 1 {
+			t.Errorf("RetryReader failing with %v\n    have: %v after %d reads\n    want: %v after 1 read\n", want, err, fr.reads, want)
+		}
+	}
+}
+
+func TestLimitsInflatedText(t *testing.T) {
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(make([]byte, 4<<20))
+	zw.Close()
+	bomb := buf.Bytes()
+
+	ztxt := append([]byte("Comment\x00\x00"), bomb...)
+	itxt := append([]byte("Comment\x00\x01\x00\x00\x00"), bomb...)
+	for _, chunk := range [][]byte{testChunk("zTXt", ztxt), testChunk("iTXt", itxt)} {
+		in := testPNG(t, chunk)
+		for _, lim := range []int64{1 << 20, 8 << 20} {
+			_, err := ReadMetaWithOptions(bytes.NewReader(in), Options{Limits: Limits{MaxTextBytes: lim}})
+			if limited := lim < 4<<20; errors.Is(err, ErrLimitExceeded) != limited || !limited && err != nil {
+				t.Errorf("ReadMetaWithOptions(%.4s, MaxTextBytes: %d)\n    have err: %v\n    want limit error: %t\n", chunk[4:], lim, err, limited)
+			}
+		}
+	}
+
+	buf.Reset()
+	zw = zlib.NewWriter(&buf)
+	zw.Write(make([]byte, maxICCProfile+1))
+	zw.Close()
+	var p ICCProfile
+	if err := p.UnmarshalChunk(append([]byte("p\x00\x00"), buf.Bytes()...)); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("ICCProfile.UnmarshalChunk(oversized)\n    have err: %v\n    want err: %v\n", err, ErrLimitExceeded)
+	}
+}
+
+func TestLimits(t *testing.T) {
+
+	in := testPNG(t, testChunk("tEXt", []byte("Title\x00limits")), testChunk("blOb", make([]byte, 100)))
+	meta := Metadata{MetaComment: "0123456789"}
+
+	cases := []struct {
+		lim Limits
+		err bool
+	}{
+		{Limits{}, false},
+		{Limits{MaxChunkSize: 100}, false},
+		{Limits{MaxChunkSize: 99}, true},
+		{Limits{MaxDimensions: 4}, false},
+		{Limits{MaxDimensions: 3}, true},
+		{Limits{MaxTextBytes: 17}, false},
+		{Limits{MaxTextBytes: 16}, true},
+		{Limits{MaxChunks: 5}, false},
+		{Limits{MaxChunks: 4}, true},
+		{Limits{MaxPixels: 16}, false},
+		{Limits{MaxPixels: 15}, true},
+		{Limits{MaxDecodedBytes: 68}, false},
+		{Limits{MaxDecodedBytes: 67}, true},
+	}
+
+	for _, c := range cases {
+		_, err := ReplaceMetaWithOptions(bytes.NewReader(in), meta, Options{Limits: c.lim})
+		if (err != nil) != c.err || (err != nil && !errors.Is(err, ErrLimitExceeded)) {
+			t.Errorf("ReplaceMetaWithOptions(%+v)\n    have err: %v\n    want err: %t\n", c.lim, err, c.err)
+		}
+	}
+
+	DefaultLimits = Limits{MaxTextBytes: 5}
+	defer func() { DefaultLimits = Limits{} }()
+	if _, err := NewSidecar(bytes.NewReader(in)); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("NewSidecar with DefaultLimits: have %v, want ErrLimitExceeded", err)
+	}
+	if _, err := ReplaceMetaWithOptions(bytes.NewReader(in), nil, Options{Limits: Limits{MaxChunks: 10}}); err != nil {
+		t.Errorf("Options.Limits didn't override DefaultLimits: %v", err)
+	}
+}
+
+func TestValidateMeta(t *testing.T) {
+
+	schema := Schema{
+		Required: []string{MetaCopyright, MetaSource},
+		Allowed:  []string{MetaTitle, MetaCreationTime},
+		Rules: map[string]Rule{
+			MetaCopyright:    {Pattern: regexp.MustCompile(`^© \d{4} `)},
+			MetaTitle:        {MaxLength: 5},
+			MetaCreationTime: {TimeFormat: time.RFC3339},
+		},
+	}
+
+	cases := []struct {
+		meta     Metadata
+		keywords []string
+	}{
+		{Metadata{MetaCopyright: "© 2025 Studio", MetaSource: "Cam", MetaTitle: "Café"}, nil},
+		{Metadata{MetaCopyright: "© 2025 Studio"}, []string{MetaSource}},
+		{Metadata{MetaCopyright: "Studio", MetaSource: "Cam"}, []string{MetaCopyright}},
+		{Metadata{MetaCopyright: "© 2025 Studio", MetaSource: "Cam", MetaTitle: "Longer"}, []string{MetaTitle}},
+		{Metadata{MetaCopyright: "© 2025 Studio", MetaSource: "Cam", MetaCreationTime: "yesterday"}, []string{MetaCreationTime}},
+		{Metadata{MetaCopyright: "© 2025 Studio", MetaSource: "Cam", MetaAuthor: "Jo"}, []string{MetaAuthor}},
+		{Metadata{MetaCopyright: "© 2025 Studio", MetaAuthor: "Jo"}, []string{MetaAuthor, MetaSource}},
+	}
+
+	for _, c := range cases {
+		var have []string
+		if err := ValidateMeta(c.meta, schema); err != nil {
+			for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+				var fe *FieldError
+				if errors.As(e, &fe) {
+					have = append(have, fe.Keyword)
+				}
+			}
+		}
+		if !reflect.DeepEqual(have, c.keywords) {
+			t.Errorf("ValidateMeta(%v)\n    have: %q\n    want: %q\n", c.meta, have, c.keywords)
+		}
+	}
+
+	if _, err := ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), Metadata{MetaTitle: "x"}, Options{Schema: &schema}); err == nil {
+		t.Errorf("ReplaceMetaWithOptions wrote metadata failing its Schema")
+	}
+
+	// Entries are checked as further values of their keywords.
+	meta := Metadata{MetaCopyright: "© 2025 Studio"}
+	entries := []struct {
+		entries []Entry
+		err     bool
+	}{
+		{[]Entry{{Keyword: MetaSource, Text: "Cam"}, {Keyword: MetaTitle, Text: "Titel", Language: "de"}}, false},
+		{[]Entry{{Keyword: MetaTitle, Text: "Titel", Language: "de"}}, true},
+		{[]Entry{{Keyword: MetaSource, Text: "Cam"}, {Keyword: MetaTitle, Text: "Longer", Language: "de"}}, true},
+		{[]Entry{{Keyword: MetaSource, Text: "Cam"}, {Keyword: MetaAuthor, Text: "Jo", Language: "de"}}, true},
+	}
+	for _, c := range entries {
+		_, err := ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), meta, Options{Schema: &schema, Entries: c.entries})
+		if (err != nil) != c.err {
+			t.Errorf("ReplaceMetaWithOptions(Entries: %v)\n    have err: %v\n    want err: %t\n", c.entries, err, c.err)
+		}
+	}
+
+	// The Schema's slices mustn't be written to.
+	allowed := make([]string, 1, 4)
+	allowed[0] = MetaTitle
+	ValidateMeta(Metadata{}, Schema{Required: []string{MetaSource}, Allowed: allowed})
+	if spare := allowed[:2]; spare[1] != "" {
+		t.Errorf("ValidateMeta wrote %q past the end of Schema.Allowed", spare[1])
+	}
+}
+
+func TestHistory(t *testing.T) {
+
+	t0 := time.Date(2025, time.March, 1, 9, 0, 0, 0, time.UTC)
+	first := Event{Time: t0, Tool: "editor", Operation: "create", Actor: "Jo"}
+	second := Event{Time: t0.Add(time.Hour), Tool: "pngutil", Operation: "replace metadata"}
+
+	mrs, err := AppendHistory(bytes.NewReader(testPNG(t)), first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, _ := mrs.Bytes()
+	mrs, err = ReplaceMetaWithOptions(bytes.NewReader(in), Metadata{MetaTitle: "x"}, Options{History: &second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := ReadHistory(mrs)
+	want := []Event{first, second}
+	if err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("ReadHistory\n"+
+			"    have: %v, err: %v\n"+
+			"    want: %v, err: nil\n",
+			have, err, want)
+	}
+	if sc, err := NewSidecar(mrs); err != nil || sc.Chunks[historyChunk] != 1 {
+		t.Errorf("ReplaceMetaWithOptions(History) left %d history chunks, err: %v", sc.Chunks[historyChunk], err)
+	}
+
+	if have, err = ReadHistory(bytes.NewReader(testPNG(t))); have != nil || err != nil {
+		t.Errorf("ReadHistory without history: have %v, err: %v", have, err)
+	}
+}
+
+func TestChunkFraming(t *testing.T) {
+
+	data := []byte("Title\x00framing")
+	chunk := AppendChunk(nil, "tEXt", data)
+	if !bytes.Equal(chunk, testChunk("tEXt", data)) {
+		t.Errorf("AppendChunk\n    have: %x\n    want: %x\n", chunk, testChunk("tEXt", data))
+	}
+	if have, want := ChunkCRC("tEXt", data), binary.BigEndian.Uint32(chunk[len(chunk)-4:]); have != want {
+		t.Errorf("ChunkCRC: have %08x, want %08x", have, want)
+	}
+
+	corrupt := append([]byte{}, chunk...)
+	corrupt[8] ^= 1
+	cases := []struct {
+		chunk []byte
+		crc   bool
+		err   bool
+	}{
+		{chunk, false, false},
+		{iend, false, false},
+		{corrupt, true, true},
+		{chunk[:len(chunk)-1], false, true},
+		{chunk[:8], false, true},
+	}
+	for _, c := range cases {
+		err := VerifyChunkCRC(c.chunk)
+		var crcErr *CRCError
+		if (err != nil) != c.err || errors.As(err, &crcErr) != c.crc {
+			t.Errorf("VerifyChunkCRC(%x)\n    have err: %v\n    want err: %t, CRCError: %t\n", c.chunk, err, c.err, c.crc)
+		}
+	}
+}
+
+func TestReadMeta(t *testing.T) {
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write([]byte("compressed"))
+	zw.Close()
+
+	in := testPNG(t,
+		testChunk("tEXt", []byte("Title\x00caf\xe9")),
+		testChunk("zTXt", append([]byte("Comment\x00\x00"), z.Bytes()...)),
+		testChunk("iTXt", []byte("Author\x00\x00\x00en\x00\x00Jö")),
+		testChunk("tEXt", []byte("Title\x00second")),
+	)
+	want := Metadata{MetaTitle: "second", MetaComment: "compressed", MetaAuthor: "Jö"}
+	have, err := ReadMeta(bytes.NewReader(in))
+	if err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("ReadMeta\n"+
+			"    have: %v, err: %v\n"+
+			"    want: %v, err: nil\n",
+			have, err, want)
+	}
+
+	if _, err = ReadMeta(bytes.NewReader(in[:len(in)-1])); err == nil {
+		t.Errorf("ReadMeta accepted a truncated file")
+	}
+}
+
+func TestReplaceMetaZTXt(t *testing.T) {
+
+	doc := strings.Repeat(`{"layer":"background","visible":true},`, 100)
+	meta := Metadata{MetaComment: doc, MetaTitle: "café", MetaAuthor: "猫"}
+
+	mrs, err := ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), meta, Options{ZTXt: []string{MetaComment, MetaTitle}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := NewSidecar(mrs)
+	if err != nil || sc.Chunks["zTXt"] != 2 || sc.Chunks["iTXt"] != 1 || !reflect.DeepEqual(sc.Metadata, meta) {
+		t.Errorf("ReplaceMetaWithOptions(ZTXt)\n"+
+			"    have: %v, %v, err: %v\n"+
+			"    want: 2 zTXt and 1 iTXt chunk, %v\n",
+			sc.Chunks, sc.Metadata, err, meta)
+	}
+	if mrs.Size() > int64(len(doc)) {
+		t.Errorf("ReplaceMetaWithOptions(ZTXt) didn't compress: %d bytes", mrs.Size())
+	}
+
+	if _, err = ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), meta, Options{ZTXt: []string{MetaAuthor}}); err == nil {
+		t.Errorf("ReplaceMetaWithOptions wrote non-Latin-1 text as zTXt")
+	}
+}
+
+func TestReplaceMetaCompressText(t *testing.T) {
+
+	meta := Metadata{MetaDescription: strings.Repeat("猫が好き。", 500), MetaTitle: "short"}
+	mrs, err := ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), meta, Options{CompressText: []string{MetaDescription}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := ReadMeta(mrs)
+	if err != nil || !reflect.DeepEqual(have, meta) {
+		t.Errorf("ReadMeta after CompressText: have %d keywords, err: %v", len(have), err)
+	}
+	if mrs.Size() > int64(len(meta[MetaDescription]))/4 {
+		t.Errorf("ReplaceMetaWithOptions(CompressText) didn't compress: %d bytes", mrs.Size())
+	}
+
+	idx, err := indexPNG(mrs, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := map[string]bool{}
+	for _, h := range idx {
+		if h.typ == "iTXt" {
+			data, _ := readChunkData(mrs, h)
+			e, err := decodeText(h.typ, data, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compressed[e.Keyword] = e.Compressed
+		}
+	}
+	if want := map[string]bool{MetaDescription: true, MetaTitle: false}; !reflect.DeepEqual(compressed, want) {
+		t.Errorf("iTXt compression flags: have %v, want %v", compressed, want)
+	}
+}
+
+func TestReadEntries(t *testing.T) {
+
+	want := []Entry{
+		{Keyword: MetaTitle, Text: "plain"},
+		{Keyword: MetaDescription, Text: "Eine Katze", Language: "de", TranslatedKeyword: "Beschreibung"},
+		{Keyword: MetaComment, Text: "ねこ", Language: "ja", TranslatedKeyword: "コメント", Compressed: true},
+	}
+	mrs, err := ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), Metadata{MetaTitle: "plain"}, Options{Entries: want[1:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := ReadEntries(mrs)
+	if err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("ReadEntries\n"+
+			"    have: %+v, err: %v\n"+
+			"    want: %+v, err: nil\n",
+			have, err, want)
+	}
+
+	bad := Options{Entries: []Entry{{Keyword: MetaTitle, TranslatedKeyword: "a\x00b"}}}
+	if _, err = ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), nil, bad); err == nil {
+		t.Errorf("ReplaceMetaWithOptions accepted a translated keyword containing NUL")
+	}
+}
+
+func TestKeywords(t *testing.T) {
+
+	cases := []struct {
+		keyword   string
+		sanitized string
+		valid     bool
+	}{
+		{"Title", "Title", true},
+		{"Café Notes", "Café Notes", true},
+		{"", "", false},
+		{" Title", "Title", false},
+		{"Title ", "Title", false},
+		{"Two  Spaces", "Two Spaces", false},
+		{"猫", "_", false},
+		{"Tab\there", "Tab here", false},
+		{"a\x00b", "a_b", false},
+		{strings.Repeat("k", 80), strings.Repeat("k", 79), false},
+		{"   ", "", false},
+	}
+
+	for _, c := range cases {
+		_, err := encodeKeyword(c.keyword)
+		var kwErr *KeywordError
+		if (err == nil) != c.valid || (err != nil && !errors.As(err, &kwErr)) {
+			t.Errorf("encodeKeyword(%q): have err %v, want valid: %t", c.keyword, err, c.valid)
+		}
+		have, err := SanitizeKeyword(c.keyword)
+		if have != c.sanitized || (err != nil) != (c.sanitized == "") {
+			t.Errorf("SanitizeKeyword(%q): have %q, err: %v, want %q", c.keyword, have, err, c.sanitized)
+		}
+	}
+
+	meta := Metadata{"Café": "latin-1 keyword", " Notes ": "x"}
+	if _, err := ReplaceMeta(bytes.NewReader(testPNG(t)), meta); err == nil {
+		t.Errorf("ReplaceMeta accepted keyword %q", " Notes ")
+	}
+	mrs, err := ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), meta, Options{SanitizeKeywords: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Metadata{"Café": "latin-1 keyword", "Notes": "x"}
+	if have, err := ReadMeta(mrs); err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("ReplaceMetaWithOptions(SanitizeKeywords)\n    have: %v, err: %v\n    want: %v\n", have, err, want)
+	}
+}
+
+func TestAppendMeta(t *testing.T) {
+
+	in := testPNG(t,
+		testChunk("tEXt", []byte("Software\x00Editor 2")),
+		testChunk("tEXt", []byte("Copyright\x00old")),
+		testChunk("blOb", []byte{1, 2, 3}),
+	)
+	mrs, err := AppendMeta(bytes.NewReader(in), Metadata{MetaCopyright: "© 2025 Studio", MetaAuthor: "Jo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Metadata{MetaSoftware: "Editor 2", MetaCopyright: "© 2025 Studio", MetaAuthor: "Jo"}
+	sc, err := NewSidecar(mrs)
+	if err != nil || !reflect.DeepEqual(sc.Metadata, want) || sc.Chunks["blOb"] != 1 || sc.Chunks["tEXt"] != 1 {
+		t.Errorf("AppendMeta\n"+
+			"    have: %v, %v, err: %v\n"+
+			"    want: %v with blOb and one tEXt chunk kept\n",
+			sc.Metadata, sc.Chunks, err, want)
+	}
+}
+
+func TestDeleteMeta(t *testing.T) {
+
+	in := testPNG(t,
+		testChunk("tEXt", []byte("Author\x00Jo")),
+		testChunk("iTXt", []byte("Source\x00\x00\x00\x00\x00GPS 51.5,-0.1")),
+		testChunk("tEXt", []byte("Title\x00Keep")),
+	)
+
+	mrs, err := DeleteMeta(bytes.NewReader(in), MetaSource, MetaComment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := mrs.Bytes()
+	want := testPNG(t, testChunk("tEXt", []byte("Author\x00Jo")), testChunk("tEXt", []byte("Title\x00Keep")))
+	if err != nil || !bytes.Equal(have, want) {
+		t.Errorf("DeleteMeta(Source, Comment)\n    have: %x, err: %v\n    want: %x\n", have, err, want)
+	}
+
+	if mrs, err = DeleteMeta(bytes.NewReader(in), MetaComment); err != nil {
+		t.Fatal(err)
+	}
+	if have, err = mrs.Bytes(); err != nil || !bytes.Equal(have, in) {
+		t.Errorf("DeleteMeta of a missing keyword altered the file, err: %v", err)
+	}
+}
+
+func TestUpdateMeta(t *testing.T) {
+
+	in := testPNG(t,
+		testChunk("tEXt", []byte("Title\x00old")),
+		testChunk("blOb", []byte{1, 2, 3}),
+		testChunk("iTXt", []byte("Description\x00\x00\x00de\x00Beschreibung\x00alt")),
+		testChunk("tEXt", []byte("Author\x00Jo")),
+		testChunk("tEXt", []byte("Comment\x00old")),
+	)
+	meta := Metadata{MetaTitle: "new", MetaDescription: "neu", MetaComment: "猫", MetaSource: "ignored"}
+	mrs, err := UpdateMeta(bytes.NewReader(in), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := mrs.Bytes()
+	want := testPNG(t,
+		testChunk("tEXt", []byte("Title\x00new")),
+		testChunk("blOb", []byte{1, 2, 3}),
+		testChunk("iTXt", []byte("Description\x00\x00\x00de\x00Beschreibung\x00neu")),
+		testChunk("tEXt", []byte("Author\x00Jo")),
+		testChunk("iTXt", []byte("Comment\x00\x00\x00\x00\x00猫")),
+	)
+	if err != nil || !bytes.Equal(have, want) {
+		t.Errorf("UpdateMeta\n    have: %q, err: %v\n    want: %q\n", have, err, want)
+	}
+
+	// A value split across chunks is replaced as a whole.
+	long := strings.Repeat("split text ", 3)
+	mrs, err = ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), Metadata{MetaDescription: long, MetaTitle: "x"}, Options{SplitText: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mrs, err = UpdateMeta(mrs, Metadata{MetaDescription: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	wantMeta := Metadata{MetaDescription: "new", MetaTitle: "x"}
+	if have, err := ReadMeta(mrs); err != nil || !reflect.DeepEqual(have, wantMeta) {
+		t.Errorf("UpdateMeta(split)\n    have: %v, err: %v\n    want: %v\n", have, err, wantMeta)
+	}
+	if entries, err := ReadEntries(mrs); err != nil || len(entries) != 2 {
+		t.Errorf("UpdateMeta(split) left %d text chunks, err: %v, want 2", len(entries), err)
+	}
+}
+
+func TestReplaceMetaOrder(t *testing.T) {
+
+	meta := Metadata{}
+	for _, k := range []string{MetaWarning, MetaAuthor, MetaTitle, MetaComment, MetaSource, MetaCopyright, MetaSoftware} {
+		meta[k] = "v"
+	}
+	var first []byte
+	for i := 0; i < 10; i++ {
+		mrs, err := ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), meta, Options{ZTXt: []string{MetaWarning, MetaSource}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, err := mrs.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = have
+		} else if !bytes.Equal(have, first) {
+			t.Fatalf("ReplaceMeta output differs between runs")
+		}
+	}
+
+	entries, err := ReadEntries(bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var have []string
+	for _, e := range entries {
+		have = append(have, e.Keyword)
+	}
+	want := []string{MetaAuthor, MetaComment, MetaCopyright, MetaSoftware, MetaTitle, MetaSource, MetaWarning}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("ReplaceMeta keyword order\n    have: %q\n    want: %q\n", have, want)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+
+	in := testPNG(t,
+		testChunk("tEXt", []byte("Author\x00Jo")),
+		testChunk("tEXt", []byte("Title\x00x")),
+		testChunk("tEXt", []byte("Author\x00Sam")),
+	)
+
+	cases := []struct {
+		policy DuplicatePolicy
+		author string
+		err    bool
+	}{
+		{DuplicateLast, "Sam", false},
+		{DuplicateFirst, "Jo", false},
+		{DuplicateJoin, "Jo\nSam", false},
+		{DuplicateError, "", true},
+	}
+	for _, c := range cases {
+		meta, err := ReadMetaWithOptions(bytes.NewReader(in), Options{Duplicates: c.policy})
+		if (err != nil) != c.err || meta[MetaAuthor] != c.author {
+			t.Errorf("ReadMetaWithOptions(Duplicates: %d)\n"+
+				"    have: %q, err: %v\n"+
+				"    want: %q, err: %t\n",
+				c.policy, meta[MetaAuthor], err, c.author, c.err)
+		}
+	}
+
+	vals, err := ReadMetaValues(bytes.NewReader(in))
+	want := map[string][]string{MetaAuthor: {"Jo", "Sam"}, MetaTitle: {"x"}}
+	if err != nil || !reflect.DeepEqual(vals, want) {
+		t.Errorf("ReadMetaValues\n    have: %q, err: %v\n    want: %q\n", vals, err, want)
+	}
+}
+
+func TestStandardMeta(t *testing.T) {
+
+	when := time.Date(2025, time.March, 1, 9, 30, 0, 0, time.FixedZone("", 3600))
+	s := StandardMeta{Title: "Hills", Author: "Jo", CreationTime: when}
+	m := s.Metadata()
+	want := Metadata{MetaTitle: "Hills", MetaAuthor: "Jo", MetaCreationTime: "2025-03-01T09:30:00+01:00"}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("StandardMeta.Metadata\n    have: %v\n    want: %v\n", m, want)
+	}
+	back, err := ParseStandardMeta(m)
+	if err != nil || !back.CreationTime.Equal(when) || back.Title != s.Title || back.Author != s.Author {
+		t.Errorf("ParseStandardMeta round trip: have %+v, err: %v, want %+v", back, err, s)
+	}
+
+	cases := []struct {
+		in   string
+		want time.Time
+		err  bool
+	}{
+		{"Sat, 01 Mar 2025 09:30:00 +0100", when, false},
+		{"2025:03:01 08:30:00", when, false},
+		{"2025-03-01T08:30:00", when, false},
+		{"last Tuesday", time.Time{}, true},
+	}
+	for _, c := range cases {
+		have, err := ParseStandardMeta(Metadata{MetaCreationTime: c.in})
+		if (err != nil) != c.err || !have.CreationTime.Equal(c.want) {
+			t.Errorf("ParseStandardMeta(%q): have %v, err: %v, want %v", c.in, have.CreationTime, err, c.want)
+		}
+	}
+}
+
+func TestSplitText(t *testing.T) {
+
+	long := strings.Repeat("ab猫", 10) // 50 bytes
+	meta := Metadata{MetaDescription: long, MetaTitle: "short"}
+	mrs, err := ReplaceMetaWithOptions(bytes.NewReader(testPNG(t)), meta, Options{SplitText: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadEntries(mrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := 0
+	for _, e := range entries {
+		if e.Keyword != MetaDescription {
+			continue
+		}
+		if len(e.Text) > 8 || !utf8.ValidString(e.Text) {
+			t.Errorf("SplitText wrote part %q", e.Text)
+		}
+		want := ""
+		if parts > 0 {
+			want = ContinuedLanguage
+		}
+		if e.Language != want {
+			t.Errorf("SplitText part %d has language %q, want %q", parts, e.Language, want)
+		}
+		parts++
+	}
+	if have, err := ReadMeta(mrs); err != nil || !reflect.DeepEqual(have, meta) {
+		t.Errorf("ReadMeta\n    have: %v, err: %v\n    want: %v\n", have, err, meta)
+	}
+	want := map[string][]string{MetaDescription: {long}, MetaTitle: {"short"}}
+	if have, err := ReadMetaValues(mrs); err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("ReadMetaValues\n    have: %v, err: %v\n    want: %v\n", have, err, want)
+	}
+
+	var buf bytes.Buffer
+	if _, err = StreamText(&buf, MetaDescription, strings.NewReader(long), 8); err != nil {
+		t.Fatal(err)
+	}
+	in := testPNG(t, buf.Bytes())
+	have, err := ReadMeta(bytes.NewReader(in))
+	if err != nil || have[MetaDescription] != long {
+		t.Errorf("StreamText: have %q, err: %v, want %q", have[MetaDescription], err, long)
+	}
+	if entries, _ := ReadEntries(bytes.NewReader(in)); len(entries) != 7 {
+		t.Errorf("StreamText wrote %d parts, want 7", len(entries))
+	}
+
+	// Repeated keywords without the continuation tag aren't parts.
+	in = testPNG(t, testChunk("tEXt", []byte("Title\x00a")), testChunk("tEXt", []byte("Title\x00b")))
+	if have, err := ReadMeta(bytes.NewReader(in)); err != nil || have[MetaTitle] != "b" {
+		t.Errorf("ReadMeta(repeated)\n    have: %q, err: %v\n    want: %q\n", have[MetaTitle], err, "b")
+	}
+}
+
+func TestReplaceMetaFile(t *testing.T) {
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "in.png")
+	if err := os.WriteFile(name, testPNG(t, testChunk("tEXt", []byte("Title\x00Old"))), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// In place.
+	if err := ReplaceMetaFile(name, name, Metadata{MetaTitle: "New"}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	meta, err := ReadMeta(f)
+	if err != nil || !reflect.DeepEqual(meta, Metadata{MetaTitle: "New"}) {
+		t.Errorf("ReplaceMetaFile: have %v, err: %v", meta, err)
+	}
+	if info, err := f.Stat(); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("ReplaceMetaFile: have mode %v, err: %v, want %v", info.Mode().Perm(), err, os.FileMode(0640))
+	}
+
+	// A failure leaves the destination and no temporary file behind.
+	bad := filepath.Join(dir, "bad.png")
+	os.WriteFile(bad, []byte("not a png"), 0644)
+	if err = ReplaceMetaFile(bad, name, nil); err == nil {
+		t.Errorf("ReplaceMetaFile accepted an invalid source")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("ReplaceMetaFile left %d files in its directory, want 2", len(entries))
+	}
+}
+
+func TestPlaceBeforeIEND(t *testing.T) {
+
+	meta := Metadata{MetaTitle: "end"}
+	itxt, _ := encodeMeta(meta)
+	dsig := testChunk("dSIG", []byte("sig"))
+
+	cases := []struct {
+		name  string
+		extra [][]byte
+		opts  Options
+	}{
+		{"plain", [][]byte{testChunk("tEXt", []byte("Title\x00old"))}, Options{Placement: PlaceBeforeIEND}},
+		{"dSIG", [][]byte{dsig}, Options{Placement: PlaceBeforeIEND, Policy: Keep("dSIG")}},
+	}
+
+	for _, c := range cases {
+		in := testPNG(t, c.extra...)
+		// Close the dSIG pair just before IEND as signing would.
+		if c.name == "dSIG" {
+			in = append(append(in[:len(in)-12:len(in)-12], dsig...), iend...)
+		}
+		mrs, err := ReplaceMetaWithOptions(bytes.NewReader(in), meta, c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, err := mrs.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tail := append(append([]byte{}, itxt...), iend...)
+		if c.name == "dSIG" {
+			tail = append(append(append([]byte{}, itxt...), dsig...), iend...)
+		}
+		if !bytes.HasSuffix(have, tail) {
+			t.Errorf("ReplaceMetaWithOptions(PlaceBeforeIEND, %s) ends with %q, want %q", c.name, have[len(have)-len(tail):], tail)
+		}
+		if _, err = CopyVerified(ioutil.Discard, bytes.NewReader(have)); err != nil {
+			t.Errorf("ReplaceMetaWithOptions(PlaceBeforeIEND, %s) wrote an invalid PNG: %v", c.name, err)
+		}
+	}
+}
+
+func TestSafeToCopy(t *testing.T) {
+
+	in := testPNG(t,
+		testChunk("edIt", []byte("editor state")), // safe to copy
+		testChunk("gamE", []byte("engine data")),  // unsafe to copy
+		testChunk("tEXt", []byte("Title\x00old")),
+		testChunk("pHYs", make([]byte, 9)),
+		testChunk("gAMA", make([]byte, 4)), // unsafe to copy
+	)
+	mrs, err := ReplaceMetaWithOptions(bytes.NewReader(in), nil, Options{Policy: SafeToCopy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := NewSidecar(mrs)
+	want := map[string]int{"IHDR": 1, "edIt": 1, "pHYs": 1, "IDAT": 1, "IEND": 1}
+	if err != nil || !reflect.DeepEqual(sc.Chunks, want) {
+		t.Errorf("ReplaceMetaWithOptions(SafeToCopy)\n    have: %v, err: %v\n    want: %v\n", sc.Chunks, err, want)
+	}
+}
+
+func TestCopyMeta(t *testing.T) {
+
+	src := testPNG(t,
+		testChunk("tEXt", []byte("Title\x00source")),
+		testChunk("tIME", []byte{0x07, 0xe6, 1, 2, 3, 4, 5}),
+		testChunk("iTXt", []byte("Author\x00\x00\x00\x00\x00someone")),
+	)
+	dst := testPNG(t,
+		testChunk("tEXt", []byte("Title\x00re-encoded")),
+		testChunk("tEXt", []byte("Software\x00encoder")),
+		testChunk("tIME", []byte{0x07, 0xe7, 1, 1, 0, 0, 0}),
+	)
+
+	cases := []struct {
+		extra []string
+		meta  Metadata
+		tIME  byte
+	}{
+		{nil, Metadata{"Title": "source", "Author": "someone", "Software": "encoder"}, 0xe7},
+		{[]string{"tIME", "eXIf"}, Metadata{"Title": "source", "Author": "someone", "Software": "encoder"}, 0xe6},
+	}
+
+	for _, c := range cases {
+		mrs, err := CopyMeta(bytes.NewReader(src), bytes.NewReader(dst), c.extra...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(mrs)
+		meta, err := ReadMeta(bytes.NewReader(b))
+		if err != nil || !reflect.DeepEqual(meta, c.meta) {
+			t.Errorf("CopyMeta(%v)\n    have: %v, err: %v\n    want: %v\n", c.extra, meta, err, c.meta)
+		}
+		tm, err := firstChunkData(bytes.NewReader(b), "tIME")
+		if err != nil || len(tm) != 7 || tm[1] != c.tIME {
+			t.Errorf("CopyMeta(%v) tIME\n    have: %x, err: %v\n    want year byte: %x\n", c.extra, tm, err, c.tIME)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+
+	cases := []struct {
+		in   []byte
+		want ImageInfo
+	}{
+		{testPNG(t), ImageInfo{Width: 4, Height: 4, BitDepth: 8, ColorType: ColorRGBA}},
+		{palettedPNG(t, 4), ImageInfo{Width: 4, Height: 4, BitDepth: 2, ColorType: ColorIndexed}},
+		{encodePNG(t, image.NewGray16(image.Rect(0, 0, 3, 1))), ImageInfo{Width: 3, Height: 1, BitDepth: 16, ColorType: ColorGray}},
+	}
+	for i, c := range cases {
+		if have, err := Info(bytes.NewReader(c.in)); err != nil || have != c.want {
+			t.Errorf("Info(case %d)\n    have: %+v, err: %v\n    want: %+v\n", i, have, err, c.want)
+		}
+	}
+	if _, err := Info(bytes.NewReader([]byte("not a png"))); err == nil {
+		t.Errorf("Info(not a png): have nil error")
+	}
+}
+
+func TestImageInfoCheck(t *testing.T) {
+
+	valid := ImageInfo{Width: 4, Height: 4, BitDepth: 8, ColorType: ColorRGBA}
+	cases := []struct {
+		edit func(*ImageInfo)
+		ok   bool
+	}{
+		{func(h *ImageInfo) {}, true},
+		{func(h *ImageInfo) { h.BitDepth, h.ColorType = 1, ColorGray }, true},
+		{func(h *ImageInfo) { h.BitDepth, h.ColorType = 16, ColorGrayAlpha }, true},
+		{func(h *ImageInfo) { h.Interlace = InterlaceAdam7 }, true},
+		{func(h *ImageInfo) { h.Width = 1<<31 - 1 }, true},
+		{func(h *ImageInfo) { h.Width = 0 }, false},
+		{func(h *ImageInfo) { h.Height = 1 << 31 }, false},
+		{func(h *ImageInfo) { h.BitDepth = 4 }, false},
+		{func(h *ImageInfo) { h.BitDepth, h.ColorType = 16, ColorIndexed }, false},
+		{func(h *ImageInfo) { h.ColorType = 5 }, false},
+		{func(h *ImageInfo) { h.Compression = 1 }, false},
+		{func(h *ImageInfo) { h.Filter = 1 }, false},
+		{func(h *ImageInfo) { h.Interlace = 2 }, false},
+	}
+	for i, c := range cases {
+		h := valid
+		c.edit(&h)
+		if err := h.Check(); (err == nil) != c.ok {
+			t.Errorf("ImageInfo.Check(case %d)\n    have: %v\n    want ok: %t\n", i, err, c.ok)
+		}
+	}
+}
+
+func TestRawSize(t *testing.T) {
+
+	cases := []struct {
+		in   ImageInfo
+		want int64
+	}{
+		{ImageInfo{Width: 4, Height: 4, BitDepth: 8, ColorType: ColorRGBA}, 68},
+		{ImageInfo{Width: 8, Height: 8, BitDepth: 8, ColorType: ColorGray}, 72},
+		{ImageInfo{Width: 8, Height: 8, BitDepth: 8, ColorType: ColorGray, Interlace: InterlaceAdam7}, 79},
+		{ImageInfo{Width: 1, Height: 1, BitDepth: 1, ColorType: ColorGray, Interlace: InterlaceAdam7}, 2},
+		{ImageInfo{Width: 9, Height: 2, BitDepth: 2, ColorType: ColorIndexed}, 8},
+		{ImageInfo{Width: 1<<31 - 1, Height: 1<<31 - 1, BitDepth: 16, ColorType: ColorRGBA}, math.MaxInt64},
+	}
+	for _, c := range cases {
+		if have := c.in.rawSize(); have != c.want {
+			t.Errorf("%+v.rawSize()\n    have: %d\n    want: %d\n", c.in, have, c.want)
+		}
+	}
+}
+
+func TestAssertLevel(t *testing.T) {
+
+	// The test image has a single IDAT chunk directly before IEND.
+	in := testPNG(t)
+	end := len(in) - 12
+	corrupt := append([]byte{}, in...)
+	corrupt[end-5]++
+	badIHDR := append([]byte{}, in...)
+	copy(badIHDR[8:33], testChunk("IHDR", []byte{0, 0, 0, 4, 0, 0, 0, 4, 3, 6, 0, 0, 0}))
+	truncated := append(append([]byte{}, in[:end-1]...), in[end:]...)
+	misplaced := testPNG(t, testChunk("tRNS", []byte{0, 0}), testChunk("PLTE", make([]byte, 3)))
+
+	cases := []struct {
+		name string
+		in   []byte
+		fail AssertLevel // lowest level at which Assert fails
+	}{
+		{"valid", in, AssertImageData + 1},
+		{"image data", idatPNG(t, in, make([]byte, 67)), AssertImageData},
+		{"crc", corrupt, AssertFull},
+		{"ihdr", badIHDR, AssertFull},
+		{"truncated", truncated, AssertStructure},
+		{"order", misplaced, AssertStructure},
+		{"signature", in[1:], AssertQuick},
+	}
+	for _, c := range cases {
+		for level := AssertQuick; level <= AssertImageData; level++ {
+			err := AssertWithOptions(bytes.NewReader(c.in), Options{AssertLevel: level})
+			if (err != nil) != (level >= c.fail) {
+				t.Errorf("AssertWithOptions(%s, level %d)\n    have: %v\n    want err: %t\n", c.name, level, err, level >= c.fail)
+			}
+		}
+	}
+}
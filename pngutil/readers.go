@@ -1,8 +1,16 @@
 package pngutil
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
+	"runtime"
+	"sync"
 )
 
 /*
@@ -63,6 +71,14 @@ type multiReadSeeker struct {
 	readSeekers []*skipReadSeeker
 	sizes       []int64
 	size        int64
+
+	/*
+		hashes are fed every byte read while the bytes
+		remain contiguous from offset zero. hashed is
+		the number of bytes they've been fed so far.
+	*/
+	hashes []hash.Hash
+	hashed int64
 }
 
 /*
@@ -71,12 +87,11 @@ rs. All read seekers and the multireader itself will
 be seeked to the start.
 */
 func newMultiReadSeeker(readSeekers ...*skipReadSeeker) (mrs *multiReadSeeker, err error) {
-	var sizes []int64
+	sizes := make([]int64, len(readSeekers))
 	var size int64
-	for _, rs := range readSeekers {
-		sz := rs.end - rs.start
-		sizes = append(sizes, sz)
-		size += sz
+	for i, rs := range readSeekers {
+		sizes[i] = rs.end - rs.start
+		size += sizes[i]
 	}
 	mrs = &multiReadSeeker{
 		readSeekers: readSeekers,
@@ -97,7 +112,20 @@ func newMultiReadSeeker(readSeekers ...*skipReadSeeker) (mrs *multiReadSeeker, e
 
 func (mrs *multiReadSeeker) Read(p []byte) (n int, err error) {
 
+	if mrs.overall >= mrs.size {
+		return 0, io.EOF
+	}
+
 	read := 0
+	start := mrs.overall
+	defer func() {
+		if start == mrs.hashed && read > 0 {
+			for _, h := range mrs.hashes {
+				h.Write(p[:read])
+			}
+			mrs.hashed += int64(read)
+		}
+	}()
 	for {
 		if read == len(p) {
 			break
@@ -134,6 +162,11 @@ func (mrs *multiReadSeeker) Read(p []byte) (n int, err error) {
 }
 
 func (mrs *multiReadSeeker) Seek(offset int64, whence int) (n int64, err error) {
+	defer func() {
+		if err == nil && n == 0 {
+			mrs.resetHashes()
+		}
+	}()
 
 	switch whence {
 	case io.SeekStart:
@@ -146,6 +179,26 @@ func (mrs *multiReadSeeker) Seek(offset int64, whence int) (n int64, err error)
 		return 0, errors.New("pngutil: invalid whence value for multiReadSeeker")
 	}
 
+	if offset < 0 {
+		return 0, errors.New("pngutil: seek before start of multiReadSeeker")
+	}
+
+	/*
+		Seeking to or beyond the end is permitted by io.Seeker.
+		The cursor is parked at the end of the last readseeker
+		so subsequent reads return io.EOF.
+	*/
+	if offset >= mrs.size {
+		if last := len(mrs.readSeekers) - 1; last >= 0 {
+			mrs.rsIdx = last
+			if _, err := mrs.readSeekers[last].Seek(mrs.sizes[last], io.SeekStart); err != nil {
+				return 0, err
+			}
+		}
+		mrs.overall = offset
+		return offset, nil
+	}
+
 	var total int64
 	for i, s := range mrs.sizes {
 		if offset >= total && offset < total+s {
@@ -167,3 +220,344 @@ func (mrs *multiReadSeeker) Seek(offset int64, whence int) (n int64, err error)
 func (mrs *multiReadSeeker) Size() (n int64) {
 	return mrs.size
 }
+
+/*
+Bytes seeks mrs to the start and reads its entire contents into
+a single slice allocated to exactly Size() bytes. This is the
+simplest way to get the final image when streaming it isn't
+required. mrs is left at EOF.
+*/
+func (mrs *multiReadSeeker) Bytes() ([]byte, error) {
+	if _, err := mrs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if mrs.size > int64(maxInt) {
+		return nil, fmt.Errorf("%w: %d bytes won't fit in memory", ErrLimitExceeded, mrs.size)
+	}
+	bb := make([]byte, mrs.size)
+	if _, err := io.ReadFull(mrs, bb); err != nil {
+		return nil, fmt.Errorf("pngutil: %w", err)
+	}
+	return bb, nil
+}
+
+/*
+CRCError is returned when the CRC stored at the end of a chunk
+doesn't match the one computed over its type and data.
+*/
+type CRCError struct {
+	Type   string // chunk type, e.g. "IDAT"
+	Offset int64  // offset of the chunk's length field
+	Stored uint32 // CRC found in the stream
+	Actual uint32 // CRC computed from the chunk
+}
+
+func (e *CRCError) Error() string {
+	return fmt.Sprintf("pngutil: CRC mismatch in %s chunk at offset %d (stored %08x, computed %08x)",
+		e.Type, e.Offset, e.Stored, e.Actual)
+}
+
+// States of verifyReader as it walks the stream.
+const (
+	vsSignature = iota
+	vsHeader
+	vsData
+	vsCRC
+	vsDone
+)
+
+/*
+verifyReader passes through the bytes of a PNG stream while
+checking its framing: the signature, each chunk's CRC, and
+that nothing follows IEND. It only ever holds the 8 bytes of
+a chunk header or 4 bytes of a CRC in memory.
+*/
+type verifyReader struct {
+	r      io.Reader
+	state  int
+	offset int64 // bytes consumed so far
+	err    error // sticky error returned by all further reads
+
+	buf  [8]byte // partial signature, chunk header, or CRC
+	bufN int
+	want int
+
+	chunkOffset int64
+	chunkType   string
+	remaining   int64
+	crc         hash.Hash32
+}
+
+/*
+NewVerifyReader returns a reader that yields the same bytes
+as r, which is expected to be a complete PNG stream such as
+the one returned by ReplaceMeta. Each chunk's CRC is checked
+as it passes through and the first mismatch fails the read
+with a *CRCError. Any other structural problem, including r
+ending mid-chunk or data following IEND, also fails the read.
+
+Bytes up to and including the point at which corruption was
+detected are returned alongside the error, so callers that
+need to withhold an entire bad chunk should buffer by chunk.
+*/
+func NewVerifyReader(r io.Reader) io.Reader {
+	return &verifyReader{
+		r:    r,
+		want: len(header),
+		crc:  crc32.NewIEEE(),
+	}
+}
+
+func (vr *verifyReader) Read(p []byte) (n int, err error) {
+	if vr.err != nil {
+		return 0, vr.err
+	}
+	n, err = vr.r.Read(p)
+	if vn, vErr := vr.verify(p[:n]); vErr != nil {
+		vr.err = vErr
+		return vn, vErr
+	}
+	if errors.Is(err, io.EOF) && vr.state != vsDone {
+		vr.err = fmt.Errorf("pngutil: stream ended at offset %d before IEND chunk: %w",
+			vr.offset, io.ErrUnexpectedEOF)
+		return n, vr.err
+	}
+	return n, err
+}
+
+func (vr *verifyReader) verify(p []byte) (n int, err error) {
+	for n < len(p) {
+		switch vr.state {
+		case vsSignature, vsHeader, vsCRC:
+			c := copy(vr.buf[vr.bufN:vr.want], p[n:])
+			vr.bufN += c
+			vr.offset += int64(c)
+			n += c
+			if vr.bufN < vr.want {
+				return n, nil
+			}
+			vr.bufN = 0
+			if err = vr.advance(); err != nil {
+				return n, err
+			}
+		case vsData:
+			c := int64(len(p) - n)
+			if c > vr.remaining {
+				c = vr.remaining
+			}
+			vr.crc.Write(p[n : n+int(c)])
+			vr.remaining -= c
+			vr.offset += c
+			n += int(c)
+			if vr.remaining == 0 {
+				vr.state = vsCRC
+				vr.want = 4
+			}
+		case vsDone:
+			return n, fmt.Errorf("pngutil: unexpected data after IEND chunk at offset %d", vr.offset)
+		}
+	}
+	return n, nil
+}
+
+// advance is called once buf holds all the bytes the current state wants.
+func (vr *verifyReader) advance() error {
+	switch vr.state {
+	case vsSignature:
+		if !bytes.Equal(vr.buf[:8], header) {
+			return errors.New("pngutil: missing PNG signature")
+		}
+		vr.state = vsHeader
+		vr.want = 8
+	case vsHeader:
+		length := binary.BigEndian.Uint32(vr.buf[0:4])
+		if length > maxChunkLength {
+			return fmt.Errorf("pngutil: chunk length %d at offset %d exceeds maximum", length, vr.offset-8)
+		}
+		vr.chunkOffset = vr.offset - 8
+		vr.chunkType = string(vr.buf[4:8])
+		vr.remaining = int64(length)
+		vr.crc.Reset()
+		vr.crc.Write(vr.buf[4:8])
+		vr.state = vsData
+		if length == 0 {
+			vr.state = vsCRC
+			vr.want = 4
+		}
+	case vsCRC:
+		stored := binary.BigEndian.Uint32(vr.buf[0:4])
+		if actual := vr.crc.Sum32(); stored != actual {
+			return &CRCError{
+				Type:   vr.chunkType,
+				Offset: vr.chunkOffset,
+				Stored: stored,
+				Actual: actual,
+			}
+		}
+		vr.state = vsHeader
+		vr.want = 8
+		if vr.chunkType == "IEND" {
+			vr.state = vsDone
+		}
+	}
+	return nil
+}
+
+/*
+AttachHash adds hs to the hashes fed by mrs. Every byte read
+from mrs is written to each of them, so a content hash can be
+computed while the output is copied elsewhere rather than by
+reading it a second time.
+
+Attaching resets all hashes. They're fed from offset zero; if
+mrs isn't currently at the start, hashing begins once mrs is
+seeked back to it. Seeking to the start at any time resets
+them again.
+*/
+func (mrs *multiReadSeeker) AttachHash(hs ...hash.Hash) {
+	mrs.hashes = append(mrs.hashes, hs...)
+	mrs.resetHashes()
+}
+
+/*
+Sums returns the digest of each attached hash in the order
+they were attached. It returns an error if the hashes haven't
+been fed the entire output, which happens when mrs hasn't been
+drained to EOF or was seeked away from the start mid-read and
+didn't return to where it left off.
+*/
+func (mrs *multiReadSeeker) Sums() (sums [][]byte, err error) {
+	if mrs.hashed != mrs.size {
+		return nil, fmt.Errorf("pngutil: hashes have seen %d of %d bytes", mrs.hashed, mrs.size)
+	}
+	for _, h := range mrs.hashes {
+		sums = append(sums, h.Sum(nil))
+	}
+	return sums, nil
+}
+
+func (mrs *multiReadSeeker) resetHashes() {
+	for _, h := range mrs.hashes {
+		h.Reset()
+	}
+	mrs.hashed = 0
+}
+
+// Size of the pieces WriteToAt splits segments into.
+const writeAtBlockSize = 4 << 20
+
+/*
+WriteToAt writes the contents of mrs to w, copying independent
+pieces of the output concurrently at their final offsets. On
+fast storage this considerably speeds up writing very large
+images. It returns the number of bytes written.
+
+Concurrent copying requires every underlying reader to support
+io.ReaderAt, as *os.File and *bytes.Reader do. If one doesn't,
+WriteToAt falls back to copying sequentially from the start.
+
+Attached hashes are only fed by the sequential path. After a
+concurrent write Sums returns an error until mrs is seeked back
+to the start and read again.
+*/
+func (mrs *multiReadSeeker) WriteToAt(w io.WriterAt) (n int64, err error) {
+	return mrs.writeToAt(context.Background(), w)
+}
+
+/*
+writeToAt is WriteToAt stopping early, with ctx's error, once
+ctx is done. ctx is checked before each piece is copied.
+*/
+func (mrs *multiReadSeeker) writeToAt(ctx context.Context, w io.WriterAt) (n int64, err error) {
+
+	type piece struct {
+		ra  io.ReaderAt
+		src int64 // offset in ra
+		dst int64 // offset in w
+		n   int64
+	}
+
+	var pieces []piece
+	var dst int64
+	for i, srs := range mrs.readSeekers {
+		ra, ok := srs.rs.(io.ReaderAt)
+		if !ok {
+			return mrs.writeToAtSequential(ctx, w)
+		}
+		for off := int64(0); off < mrs.sizes[i]; off += writeAtBlockSize {
+			size := mrs.sizes[i] - off
+			if size > writeAtBlockSize {
+				size = writeAtBlockSize
+			}
+			pieces = append(pieces, piece{ra, srs.start + off, dst + off, size})
+		}
+		dst += mrs.sizes[i]
+	}
+
+	mrs.hashed = -1
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan piece)
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				written, cErr := io.Copy(
+					io.NewOffsetWriter(w, p.dst),
+					io.NewSectionReader(p.ra, p.src, p.n),
+				)
+				mu.Lock()
+				n += written
+				if cErr == nil && written != p.n {
+					cErr = io.ErrShortWrite
+				}
+				if cErr != nil {
+					err = errors.Join(err, cErr)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, p := range pieces {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- p
+	}
+	close(jobs)
+	wg.Wait()
+
+	if cErr := ctx.Err(); cErr != nil {
+		return n, cErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("pngutil: %w", err)
+	}
+	return n, nil
+}
+
+func (mrs *multiReadSeeker) writeToAtSequential(ctx context.Context, w io.WriterAt) (n int64, err error) {
+	if _, err = mrs.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	ow := io.NewOffsetWriter(w, 0)
+	for n < mrs.size {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+		c, err := io.CopyN(ow, mrs, writeAtBlockSize)
+		n += c
+		if err != nil && !errors.Is(err, io.EOF) {
+			return n, fmt.Errorf("pngutil: %w", err)
+		}
+		if c == 0 {
+			break
+		}
+	}
+	return n, nil
+}
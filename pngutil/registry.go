@@ -0,0 +1,226 @@
+package pngutil
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+/*
+ChunkHandler converts the data of a particular chunk type to
+and from a Go value. Handlers let applications give their own
+chunk types the same treatment as the ones this package knows
+about.
+*/
+type ChunkHandler interface {
+
+	// DecodeChunk parses the data of a chunk, excluding its length, type and CRC.
+	DecodeChunk(data []byte) (any, error)
+
+	// EncodeChunk serializes v into the data of a chunk.
+	EncodeChunk(v any) ([]byte, error)
+}
+
+type registration struct {
+	handler ChunkHandler
+	retain  bool
+}
+
+var registry = struct {
+	sync.RWMutex
+	handlers map[string]registration
+}{
+	handlers: make(map[string]registration),
+}
+
+/*
+RegisterChunk makes h the handler for chunks of type typ,
+replacing any handler previously registered for it. If retain
+is true, ReplaceMeta keeps chunks of type typ regardless of
+the Policy it's given.
+
+RegisterChunk is safe to call concurrently but is typically
+called from an init function.
+*/
+func RegisterChunk(typ string, h ChunkHandler, retain bool) error {
+	if !validChunkType(typ) {
+		return fmt.Errorf("pngutil: invalid chunk type %q", typ)
+	}
+	if h == nil {
+		return fmt.Errorf("pngutil: nil handler for chunk type %q", typ)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	registry.handlers[typ] = registration{h, retain}
+	return nil
+}
+
+func lookupChunk(typ string) (reg registration, ok bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	reg, ok = registry.handlers[typ]
+	return reg, ok
+}
+
+// registeredRetain reports whether typ was registered with retain set.
+func registeredRetain(typ string) bool {
+	reg, ok := lookupChunk(typ)
+	return ok && reg.retain
+}
+
+/*
+DecodeChunks decodes every chunk of type typ in rs using the
+handler registered for typ, returning the values in the order
+the chunks appear. The offset of rs is left unspecified.
+*/
+func DecodeChunks(rs io.ReadSeeker, typ string) (vals []any, err error) {
+	reg, ok := lookupChunk(typ)
+	if !ok {
+		return nil, fmt.Errorf("pngutil: no handler registered for chunk type %q", typ)
+	}
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range idx {
+		if h.typ != typ {
+			continue
+		}
+		v, err := decodeChunk(rs, h, reg)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+/*
+DecodeRegistered decodes every chunk of rs whose type has a
+registered handler, including the package's own, returning the
+values keyed by chunk type in the order the chunks of each type
+appear. Chunks without a handler are skipped. The offset of rs
+is left unspecified.
+*/
+func DecodeRegistered(rs io.ReadSeeker) (map[string][]any, error) {
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return nil, err
+	}
+	vals := make(map[string][]any)
+	for _, h := range idx {
+		reg, ok := lookupChunk(h.typ)
+		if !ok {
+			continue
+		}
+		v, err := decodeChunk(rs, h, reg)
+		if err != nil {
+			return nil, err
+		}
+		vals[h.typ] = append(vals[h.typ], v)
+	}
+	return vals, nil
+}
+
+// decodeChunk decodes the chunk located by h with the handler of reg.
+func decodeChunk(rs io.ReadSeeker, h chunkHeader, reg registration) (any, error) {
+	data, err := readChunkData(rs, h)
+	if err != nil {
+		return nil, err
+	}
+	v, err := reg.handler.DecodeChunk(data)
+	if err != nil {
+		return nil, fmt.Errorf("pngutil: decoding %s chunk at offset %d: %w", h.typ, h.offset, err)
+	}
+	return v, nil
+}
+
+/*
+EncodeChunk serializes v using the handler registered for typ
+and returns a complete chunk, including its length and CRC,
+ready to be written into a PNG stream.
+*/
+func EncodeChunk(typ string, v any) ([]byte, error) {
+	reg, ok := lookupChunk(typ)
+	if !ok {
+		return nil, fmt.Errorf("pngutil: no handler registered for chunk type %q", typ)
+	}
+	data, err := reg.handler.EncodeChunk(v)
+	if err != nil {
+		return nil, fmt.Errorf("pngutil: encoding %s chunk: %w", typ, err)
+	}
+	if len(data) > maxChunkLength {
+		return nil, fmt.Errorf("pngutil: encoded %s chunk is too large", typ)
+	}
+	return AppendChunk(nil, typ, data), nil
+}
+
+/*
+ChunkMarshaler is implemented by types that can serialize
+themselves as the data of a chunk.
+*/
+type ChunkMarshaler interface {
+
+	// ChunkType returns the four letter type code of the chunk.
+	ChunkType() string
+
+	// MarshalChunk returns the chunk's data, excluding its length, type and CRC.
+	MarshalChunk() ([]byte, error)
+}
+
+/*
+ChunkUnmarshaler is implemented by types that can parse the
+data of a chunk into themselves. UnmarshalChunk must copy data
+if it wishes to retain it after returning.
+*/
+type ChunkUnmarshaler interface {
+	UnmarshalChunk(data []byte) error
+}
+
+/*
+CodecHandler adapts a chunk codec to a ChunkHandler so that
+it can be passed to RegisterChunk. newValue must return a new
+zero value each time it's called; it's decoded into and that
+value is returned by DecodeChunk. EncodeChunk accepts any
+ChunkMarshaler.
+*/
+func CodecHandler(newValue func() ChunkUnmarshaler) ChunkHandler {
+	return codecHandler(newValue)
+}
+
+type codecHandler func() ChunkUnmarshaler
+
+func (newValue codecHandler) DecodeChunk(data []byte) (any, error) {
+	v := newValue()
+	if err := v.UnmarshalChunk(data); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (newValue codecHandler) EncodeChunk(v any) ([]byte, error) {
+	m, ok := v.(ChunkMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("pngutil: %T doesn't implement ChunkMarshaler", v)
+	}
+	return m.MarshalChunk()
+}
+
+/*
+MarshalChunk returns a complete chunk, including its length,
+type and CRC, holding the data produced by m.
+*/
+func MarshalChunk(m ChunkMarshaler) ([]byte, error) {
+	typ := m.ChunkType()
+	if !validChunkType(typ) {
+		return nil, fmt.Errorf("pngutil: invalid chunk type %q", typ)
+	}
+	data, err := m.MarshalChunk()
+	if err != nil {
+		return nil, fmt.Errorf("pngutil: encoding %s chunk: %w", typ, err)
+	}
+	if len(data) > maxChunkLength {
+		return nil, fmt.Errorf("pngutil: encoded %s chunk is too large", typ)
+	}
+	return AppendChunk(nil, typ, data), nil
+}
@@ -0,0 +1,180 @@
+package pngutil
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type stringHandler struct{}
+
+func (stringHandler) DecodeChunk(data []byte) (any, error) {
+	return string(data), nil
+}
+
+func (stringHandler) EncodeChunk(v any) ([]byte, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, errors.New("not a string")
+	}
+	return []byte(s), nil
+}
+
+func TestRegisterChunk(t *testing.T) {
+
+	if err := RegisterChunk("tsTa", stringHandler{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterChunk("ts!a", stringHandler{}, true); err == nil {
+		t.Errorf("RegisterChunk(%q) accepted an invalid chunk type", "ts!a")
+	}
+
+	chunk, err := EncodeChunk("tsTa", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mrs, err := ReplaceMeta(bytes.NewReader(testPNG(t, chunk)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, err := DecodeChunks(mrs, "tsTa")
+	if err != nil || !reflect.DeepEqual(vals, []any{"state"}) {
+		t.Errorf("DecodeChunks after ReplaceMeta\n"+
+			"    have vals: %v, err: %v\n"+
+			"    want vals: %v, err: nil\n",
+			vals, err, []any{"state"})
+	}
+}
+
+func TestDecodeRegistered(t *testing.T) {
+
+	if err := RegisterChunk("tsTb", stringHandler{}, false); err != nil {
+		t.Fatal(err)
+	}
+	gama, err := MarshalChunk(Gamma(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := testPNG(t, testChunk("tsTb", []byte("one")), gama, testChunk("tsTb", []byte("two")), testChunk("unRG", nil))
+
+	g := Gamma(0.5)
+	want := map[string][]any{"tsTb": {"one", "two"}, "gAMA": {&g}}
+	if have, err := DecodeRegistered(bytes.NewReader(in)); err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("DecodeRegistered\n    have: %v, err: %v\n    want: %v\n", have, err, want)
+	}
+	sc, err := NewSidecar(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sc.Decoded, want) {
+		t.Errorf("NewSidecar(...).Decoded\n    have: %v\n    want: %v\n", sc.Decoded, want)
+	}
+
+	// Undecodable chunks are reported rather than failing the sidecar.
+	iccp, err := MarshalChunk(ICCProfile{Name: "p", Profile: make([]byte, 1024)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in = testPNG(t,
+		testChunk("gAMA", []byte{0, 0, 0, 0}),
+		iccp,
+		testChunk("tIME", []byte{0x07, 0xE9, 13, 40, 0, 0, 0}),
+		testChunk("tsTb", []byte("one")),
+	)
+	if sc, err = NewSidecar(bytes.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string][]any{"tsTb": {"one"}}
+	if !reflect.DeepEqual(sc.Decoded, want) || len(sc.DecodeErrors) != 2 {
+		t.Errorf("NewSidecar(bad chunks)\n"+
+			"    have decoded: %v, errors: %q\n"+
+			"    want decoded: %v, 2 errors\n",
+			sc.Decoded, sc.DecodeErrors, want)
+	}
+}
+
+func TestBuiltinCodecs(t *testing.T) {
+
+	when := time.Date(2024, time.February, 29, 23, 59, 59, 0, time.UTC)
+	cases := []ChunkMarshaler{
+		PhysicalDims{X: 2835, Y: 2835, Unit: UnitMetre},
+		ModTime{when},
+	}
+
+	for _, c := range cases {
+		chunk, err := MarshalChunk(c)
+		if err != nil {
+			t.Errorf("MarshalChunk(%+v): %v", c, err)
+			continue
+		}
+		vals, err := DecodeChunks(bytes.NewReader(testPNG(t, chunk)), c.ChunkType())
+		if err != nil || len(vals) != 1 {
+			t.Errorf("DecodeChunks(%s): %v, err: %v", c.ChunkType(), vals, err)
+			continue
+		}
+		have, _ := vals[0].(ChunkMarshaler).MarshalChunk()
+		want, _ := c.MarshalChunk()
+		if !bytes.Equal(have, want) {
+			t.Errorf("%s round trip\n"+
+				"    have: %v\n"+
+				"    want: %v\n",
+				c.ChunkType(), vals[0], c)
+		}
+	}
+
+	// A leap second is accepted, though time.Time can't represent it.
+	var leap ModTime
+	want := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if err := leap.UnmarshalChunk([]byte{0x07, 0xE0, 12, 31, 23, 59, 60}); err != nil || !leap.Time.Equal(want) {
+		t.Errorf("ModTime.UnmarshalChunk(2016-12-31 23:59:60)\n    have: %v, err: %v\n    want: %v\n", leap.Time, err, want)
+	}
+}
+
+func TestKeepGIF(t *testing.T) {
+
+	want := GIFControl{Disposal: 2, UserInput: true, Delay: 150 * time.Millisecond}
+	chunk, err := MarshalChunk(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := testPNG(t, chunk)
+
+	for _, policy := range []Policy{nil, KeepGIF} {
+		mrs, err := ReplaceMetaWithOptions(bytes.NewReader(in), nil, Options{Policy: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, err := GIFControls(mrs)
+		kept := policy != nil
+		if err != nil || kept != (len(have) == 1) || kept && have[0] != want {
+			t.Errorf("GIFControls after ReplaceMeta (kept: %t)\n"+
+				"    have: %+v, err: %v\n"+
+				"    want: %+v, err: nil\n",
+				kept, have, err, want)
+		}
+	}
+}
+
+func TestModTime(t *testing.T) {
+
+	when := time.Date(2025, time.March, 1, 9, 30, 15, 500, time.FixedZone("", 3600))
+	in := testPNG(t, testChunk("tIME", []byte{0x07, 0xD0, 1, 1, 0, 0, 0}), testChunk("tEXt", []byte("Title\x00x")))
+
+	mrs, err := SetModTime(bytes.NewReader(in), when)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := GetModTime(mrs)
+	if want := time.Date(2025, time.March, 1, 8, 30, 15, 0, time.UTC); err != nil || !have.Equal(want) {
+		t.Errorf("GetModTime: have %v, err: %v, want %v", have, err, want)
+	}
+	if sc, err := NewSidecar(mrs); err != nil || sc.Chunks["tIME"] != 1 {
+		t.Errorf("SetModTime left %d tIME chunks, err: %v", sc.Chunks["tIME"], err)
+	}
+
+	if _, err = GetModTime(bytes.NewReader(testPNG(t))); !errors.Is(err, ErrNoChunk) {
+		t.Errorf("GetModTime without tIME: have %v, want ErrNoChunk", err)
+	}
+}
@@ -0,0 +1,205 @@
+package pngutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	remoteBlockSize = 64 * 1024 // bytes fetched per range request
+	remoteMaxBlocks = 64        // blocks cached before the oldest is evicted
+)
+
+/*
+RemoteFile is an io.ReadSeeker and io.ReaderAt over a PNG served
+over HTTP, fetching only the parts that are read using range
+requests. Fetched blocks are cached, so functions like Assert and
+NewSidecar, which read headers and metadata but not image data,
+download a small fraction of a large image.
+
+RemoteFile is safe for concurrent use through ReadAt. Read and
+Seek share a cursor and so must not be used concurrently.
+*/
+type RemoteFile struct {
+	ctx       context.Context
+	client    *http.Client
+	url       string
+	size      int64
+	blockSize int64
+	pos       int64
+
+	mu     sync.Mutex
+	blocks map[int64][]byte // keyed by block index
+	order  []int64          // block indices oldest first
+}
+
+/*
+OpenURL returns a RemoteFile for the resource at url, which is
+fetched with client, or http.DefaultClient if client is nil. The
+server must support range requests. ctx applies to every request
+the RemoteFile makes, including those of later reads.
+*/
+func OpenURL(ctx context.Context, client *http.Client, url string) (*RemoteFile, error) {
+	return openURL(ctx, client, url, remoteBlockSize)
+}
+
+func openURL(ctx context.Context, client *http.Client, url string, blockSize int64) (*RemoteFile, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	rf := &RemoteFile{
+		ctx:       ctx,
+		client:    client,
+		url:       url,
+		blockSize: blockSize,
+		blocks:    make(map[int64][]byte),
+	}
+
+	// The first block is always needed so fetching it learns the size too.
+	p, size, err := rf.fetch(0)
+	if err != nil {
+		return nil, err
+	}
+	rf.size = size
+	rf.blocks[0] = p
+	rf.order = append(rf.order, 0)
+	return rf, nil
+}
+
+// Size returns the size in bytes of the remote resource.
+func (rf *RemoteFile) Size() int64 {
+	return rf.size
+}
+
+/*
+Read reads no further than the end of the block holding the
+cursor, so buffered readers filling ahead of a seek fetch one
+block rather than every block their buffer spans.
+*/
+func (rf *RemoteFile) Read(p []byte) (n int, err error) {
+	if rest := rf.blockSize - rf.pos%rf.blockSize; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err = rf.ReadAt(p, rf.pos)
+	rf.pos += int64(n)
+	if errors.Is(err, io.EOF) && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (rf *RemoteFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rf.pos
+	case io.SeekEnd:
+		offset += rf.size
+	default:
+		return 0, errors.New("pngutil: invalid whence value for RemoteFile")
+	}
+	if offset < 0 {
+		return 0, errors.New("pngutil: seek before start of RemoteFile")
+	}
+	rf.pos = offset
+	return offset, nil
+}
+
+func (rf *RemoteFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("pngutil: negative offset for RemoteFile")
+	}
+	for n < len(p) {
+		if off >= rf.size {
+			return n, io.EOF
+		}
+		block, err := rf.block(off / rf.blockSize)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], block[off%rf.blockSize:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+// block returns the i-th block from the cache, fetching it if needed.
+func (rf *RemoteFile) block(i int64) ([]byte, error) {
+
+	rf.mu.Lock()
+	p, ok := rf.blocks[i]
+	rf.mu.Unlock()
+	if ok {
+		return p, nil
+	}
+
+	p, _, err := rf.fetch(i)
+	if err != nil {
+		return nil, err
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if _, ok := rf.blocks[i]; !ok {
+		if len(rf.order) == remoteMaxBlocks {
+			delete(rf.blocks, rf.order[0])
+			rf.order = rf.order[1:]
+		}
+		rf.blocks[i] = p
+		rf.order = append(rf.order, i)
+	}
+	return p, nil
+}
+
+/*
+fetch requests the i-th block and returns it along with the
+total size of the resource reported by the server.
+*/
+func (rf *RemoteFile) fetch(i int64) (p []byte, size int64, err error) {
+
+	start := i * rf.blockSize
+	req, err := http.NewRequestWithContext(rf.ctx, http.MethodGet, rf.url, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("pngutil: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+rf.blockSize-1))
+
+	resp, err := rf.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("pngutil: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, 0, fmt.Errorf("pngutil: range request for %s returned %s", rf.url, resp.Status)
+	}
+
+	// Content-Range is of the form "bytes start-end/size".
+	cr := resp.Header.Get("Content-Range")
+	_, total, ok := strings.Cut(cr, "/")
+	if size, err = strconv.ParseInt(total, 10, 64); !ok || err != nil || size < 0 {
+		return nil, 0, fmt.Errorf("pngutil: invalid Content-Range %q from %s", cr, rf.url)
+	}
+
+	want := size - start
+	if want > rf.blockSize {
+		want = rf.blockSize
+	}
+	if want < 0 {
+		want = 0
+	}
+	p = make([]byte, want)
+	if _, err = io.ReadFull(resp.Body, p); err != nil {
+		return nil, 0, fmt.Errorf("pngutil: reading range from %s: %w", rf.url, err)
+	}
+	return p, size, nil
+}
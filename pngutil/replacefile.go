@@ -0,0 +1,86 @@
+package pngutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/*
+ReplaceMetaFile reads the PNG at srcPath, replaces its metadata
+as ReplaceMeta does, and writes the result to dstPath. srcPath
+and dstPath may be the same file.
+
+The output is written to a temporary file beside dstPath which
+is renamed over dstPath once complete, so on platforms where
+renaming is atomic dstPath is never left partially written. The
+new file takes the permissions of srcPath. Unlike WriteFile no
+extension is added to dstPath.
+*/
+func ReplaceMetaFile(srcPath, dstPath string, meta Metadata) error {
+	return ReplaceMetaFileWithOptions(srcPath, dstPath, meta, Options{})
+}
+
+/*
+ReplaceMetaFileWithOptions is like ReplaceMetaFile but accepts
+Options, which are passed to ReplaceMetaWithOptions. If Sidecar
+is set ExportSidecar is called on dstPath once it's written.
+*/
+func ReplaceMetaFileWithOptions(srcPath, dstPath string, meta Metadata, opts Options) (err error) {
+
+	tmp, err := os.CreateTemp(filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("pngutil: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	// srcPath is closed before renaming as Windows can't replace open files.
+	if err = writeReplaced(tmp, srcPath, meta, opts); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), dstPath); err != nil {
+		return fmt.Errorf("pngutil: %w", err)
+	}
+	if opts.Sidecar {
+		_, err = ExportSidecar(dstPath)
+	}
+	return err
+}
+
+/*
+writeReplaced writes the PNG at srcPath with its metadata replaced
+to tmp, giving tmp the same permissions, then syncs and closes it.
+*/
+func writeReplaced(tmp *os.File, srcPath string, meta Metadata, opts Options) (err error) {
+
+	defer closeFile(tmp, &err)
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("pngutil: %w", err)
+	}
+	defer closeFile(src, &err)
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("pngutil: %w", err)
+	}
+
+	mrs, err := ReplaceMetaWithOptions(src, meta, opts)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(tmp, mrs); err != nil {
+		return fmt.Errorf("pngutil: %w", err)
+	}
+	if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+		return fmt.Errorf("pngutil: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("pngutil: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package pngutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+/*
+RetryPolicy configures NewRetryReader. The zero value retries
+each failed operation twice with a short backoff.
+*/
+type RetryPolicy struct {
+	Attempts   int           // tries per operation including the first; zero means 3
+	Backoff    time.Duration // wait before the first retry, doubled for each after; zero means 100ms
+	MaxBackoff time.Duration // upper bound on the wait; zero means no bound
+
+	/*
+		Retryable reports whether err is transient. Nil treats
+		all errors as transient except io.EOF and the errors of a
+		cancelled or expired context.
+	*/
+	Retryable func(err error) bool
+
+	// Context cancels waits between retries. Nil means context.Background.
+	Context context.Context
+}
+
+/*
+NewRetryReader returns a reader over rs which retries reads and
+seeks failing with a transient error. Before each retried read
+rs is seeked back to the offset the failed read started from, so
+a read is resumed rather than skipped or repeated. If the offset
+of rs can't be found when NewRetryReader is called, reads aren't
+resumed this way until rs has been seeked.
+
+A read returning some bytes along with an error returns the bytes
+without the error, leaving the error to recur on the next read if
+it persists. Once the attempts are spent the last error is
+returned.
+*/
+func NewRetryReader(rs io.ReadSeeker, policy RetryPolicy) io.ReadSeeker {
+	if policy.Attempts < 1 {
+		policy.Attempts = 3
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = 100 * time.Millisecond
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = func(err error) bool {
+			return !errors.Is(err, io.EOF) &&
+				!errors.Is(err, context.Canceled) &&
+				!errors.Is(err, context.DeadlineExceeded)
+		}
+	}
+	if policy.Context == nil {
+		policy.Context = context.Background()
+	}
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		pos = -1
+	}
+	return &retryReader{rs: rs, policy: policy, pos: pos}
+}
+
+type retryReader struct {
+	rs     io.ReadSeeker
+	policy RetryPolicy
+	pos    int64 // offset of rs, or -1 until it's first known
+}
+
+func (rr *retryReader) Read(p []byte) (n int, err error) {
+	err = rr.retry(func(attempt int) error {
+		if attempt > 1 && rr.pos >= 0 {
+			if _, err := rr.rs.Seek(rr.pos, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		n, err = rr.rs.Read(p)
+		if n > 0 {
+			if rr.pos >= 0 {
+				rr.pos += int64(n)
+			}
+			return nil
+		}
+		return err
+	})
+	return n, err
+}
+
+func (rr *retryReader) Seek(offset int64, whence int) (abs int64, err error) {
+	err = rr.retry(func(int) error {
+		if abs, err = rr.rs.Seek(offset, whence); err != nil {
+			return err
+		}
+		rr.pos = abs
+		return nil
+	})
+	return abs, err
+}
+
+// retry calls op until it succeeds, fails permanently, or runs out of attempts.
+func (rr *retryReader) retry(op func(attempt int) error) error {
+	wait := rr.policy.Backoff
+	for attempt := 1; ; attempt++ {
+		err := op(attempt)
+		if err == nil || attempt == rr.policy.Attempts || !rr.policy.Retryable(err) {
+			return err
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-rr.policy.Context.Done():
+			t.Stop()
+			return errors.Join(err, rr.policy.Context.Err())
+		case <-t.C:
+		}
+		if wait *= 2; rr.policy.MaxBackoff > 0 && wait > rr.policy.MaxBackoff {
+			wait = rr.policy.MaxBackoff
+		}
+	}
+}
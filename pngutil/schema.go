@@ -0,0 +1,134 @@
+package pngutil
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+Schema declares the metadata an organisation requires of its
+images. See ValidateMeta.
+*/
+type Schema struct {
+	Required []string // keywords which must be present and non-empty
+
+	// Allowed, if non-nil, lists the only keywords permitted besides Required.
+	Allowed []string
+
+	Rules map[string]Rule // constraints on the values of particular keywords
+}
+
+/*
+Rule constrains the value of a keyword. Zero fields aren't
+checked.
+*/
+type Rule struct {
+	Pattern    *regexp.Regexp     // value must match
+	MaxLength  int                // maximum length in characters
+	TimeFormat string             // value must parse with time.Parse using this layout
+	Check      func(string) error // arbitrary validation
+}
+
+// FieldError describes a keyword which doesn't satisfy a Schema.
+type FieldError struct {
+	Keyword string
+	Reason  string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("pngutil: metadata %q %s", e.Keyword, e.Reason)
+}
+
+/*
+ValidateMeta checks m against s. It returns nil if m satisfies
+s, otherwise a *FieldError for every violation joined with
+errors.Join, in keyword order.
+*/
+func ValidateMeta(m Metadata, s Schema) error {
+	return validateMeta(m, nil, s)
+}
+
+/*
+validateMeta checks m and entries against s together, treating
+each entry as another value of its keyword.
+*/
+func validateMeta(m Metadata, entries []Entry, s Schema) error {
+
+	type field struct{ keyword, value string }
+	fields := make([]field, 0, len(m)+len(entries))
+	for k, v := range m {
+		fields = append(fields, field{k, v})
+	}
+	for _, e := range entries {
+		fields = append(fields, field{e.Keyword, e.Text})
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].keyword < fields[j].keyword
+	})
+
+	present := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		present[f.keyword] = present[f.keyword] || f.value != ""
+	}
+
+	var errs []*FieldError
+	for _, k := range s.Required {
+		if !present[k] {
+			errs = append(errs, &FieldError{k, "is required"})
+		}
+	}
+
+	var allowed map[string]bool
+	if s.Allowed != nil {
+		allowed = make(map[string]bool, len(s.Allowed)+len(s.Required))
+		for _, k := range s.Allowed {
+			allowed[k] = true
+		}
+		for _, k := range s.Required {
+			allowed[k] = true
+		}
+	}
+
+	for i, f := range fields {
+		k, v := f.keyword, f.value
+		if allowed != nil && !allowed[k] {
+			if i == 0 || fields[i-1].keyword != k {
+				errs = append(errs, &FieldError{k, "isn't allowed"})
+			}
+			continue
+		}
+		r, ok := s.Rules[k]
+		if !ok {
+			continue
+		}
+		if r.MaxLength > 0 && utf8.RuneCountInString(v) > r.MaxLength {
+			errs = append(errs, &FieldError{k, fmt.Sprintf("is longer than %d characters", r.MaxLength)})
+		}
+		if r.Pattern != nil && !r.Pattern.MatchString(v) {
+			errs = append(errs, &FieldError{k, fmt.Sprintf("doesn't match %s", r.Pattern)})
+		}
+		if r.TimeFormat != "" {
+			if _, err := time.Parse(r.TimeFormat, v); err != nil {
+				errs = append(errs, &FieldError{k, fmt.Sprintf("isn't a time in the format %q", r.TimeFormat)})
+			}
+		}
+		if r.Check != nil {
+			if err := r.Check(v); err != nil {
+				errs = append(errs, &FieldError{k, err.Error()})
+			}
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Keyword < errs[j].Keyword
+	})
+	joined := make([]error, len(errs))
+	for i, e := range errs {
+		joined[i] = e
+	}
+	return errors.Join(joined...)
+}
@@ -0,0 +1,115 @@
+package pngutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Sidecar summarises a PNG for archival and asset management
+systems that ingest a JSON description alongside each image.
+*/
+type Sidecar struct {
+	Name      string         `json:"name"`
+	Size      int64          `json:"size"`
+	Width     uint32         `json:"width"`
+	Height    uint32         `json:"height"`
+	BitDepth  uint8          `json:"bitDepth"`
+	ColorType uint8          `json:"colorType"`
+	Interlace bool           `json:"interlace"`
+	Chunks    map[string]int `json:"chunks"` // number of chunks of each type
+	Metadata  Metadata       `json:"metadata"`
+
+	/*
+		Decoded holds the chunks with a registered handler, as
+		DecodeRegistered returns them, apart from iCCP whose
+		profile is too large to summarise. Chunks which fail to
+		decode are described in DecodeErrors instead.
+	*/
+	Decoded      map[string][]any `json:"decoded,omitempty"`
+	DecodeErrors []string         `json:"decodeErrors,omitempty"`
+}
+
+/*
+NewSidecar inspects rs and returns a summary of it. The Name
+field is left empty since rs needn't be a file.
+*/
+func NewSidecar(rs io.ReadSeeker) (sc *Sidecar, err error) {
+
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return nil, err
+	}
+	ihdr, err := readIHDR(rs, idx)
+	if err != nil {
+		return nil, err
+	}
+
+	sc = &Sidecar{
+		Size:      idx[len(idx)-1].end(),
+		Width:     ihdr.Width,
+		Height:    ihdr.Height,
+		BitDepth:  ihdr.BitDepth,
+		ColorType: ihdr.ColorType,
+		Interlace: ihdr.Interlace == InterlaceAdam7,
+		Chunks:    make(map[string]int),
+	}
+	for _, h := range idx {
+		sc.Chunks[h.typ]++
+	}
+	if sc.Metadata, err = readMeta(rs, idx, Options{}); err != nil {
+		return nil, err
+	}
+	sc.Decoded = make(map[string][]any)
+	for _, h := range idx {
+		reg, ok := lookupChunk(h.typ)
+		if !ok || h.typ == "iCCP" {
+			continue
+		}
+		data, err := readChunkData(rs, h)
+		if err != nil {
+			return nil, err
+		}
+		v, err := reg.handler.DecodeChunk(data)
+		if err != nil {
+			sc.DecodeErrors = append(sc.DecodeErrors, fmt.Sprintf("%s chunk at offset %d: %v", h.typ, h.offset, err))
+			continue
+		}
+		sc.Decoded[h.typ] = append(sc.Decoded[h.typ], v)
+	}
+	return sc, nil
+}
+
+/*
+ExportSidecar writes a JSON Sidecar describing the PNG at name
+to a file beside it with the same name and a ".json" extension,
+returning the path of the file it wrote.
+*/
+func ExportSidecar(name string) (path string, err error) {
+
+	f, err := os.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("pngutil: %w", err)
+	}
+	defer closeFile(f, &err)
+
+	sc, err := NewSidecar(f)
+	if err != nil {
+		return "", err
+	}
+	sc.Name = filepath.Base(name)
+
+	bb, err := json.MarshalIndent(sc, "", "\t")
+	if err != nil {
+		return "", fmt.Errorf("pngutil: %w", err)
+	}
+	path = strings.TrimSuffix(name, filepath.Ext(name)) + ".json"
+	if err = os.WriteFile(path, append(bb, '\n'), 0666); err != nil {
+		return "", fmt.Errorf("pngutil: %w", err)
+	}
+	return path, nil
+}
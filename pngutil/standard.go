@@ -0,0 +1,92 @@
+package pngutil
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+StandardMeta holds the predefined keywords of the PNG spec as
+typed fields, avoiding misspelt keywords and inconsistently
+formatted times. Empty fields are omitted from the Metadata it
+converts to.
+*/
+type StandardMeta struct {
+	Title        string
+	Author       string
+	Description  string
+	Copyright    string
+	CreationTime time.Time
+	Software     string
+	Disclaimer   string
+	Warning      string
+	Source       string
+	Comment      string
+}
+
+// Layouts ParseStandardMeta accepts for Creation Time, in order of preference.
+var creationTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05", // as written by MapEXIF
+	time.RFC1123Z,
+	time.RFC1123,
+	exifTimeLayout,
+}
+
+/*
+Metadata returns s as Metadata, with CreationTime formatted as
+RFC 3339 (a profile of ISO 8601).
+*/
+func (s StandardMeta) Metadata() Metadata {
+	m := make(Metadata)
+	for k, v := range map[string]string{
+		MetaTitle:       s.Title,
+		MetaAuthor:      s.Author,
+		MetaDescription: s.Description,
+		MetaCopyright:   s.Copyright,
+		MetaSoftware:    s.Software,
+		MetaDisclaimer:  s.Disclaimer,
+		MetaWarning:     s.Warning,
+		MetaSource:      s.Source,
+		MetaComment:     s.Comment,
+	} {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	if !s.CreationTime.IsZero() {
+		m[MetaCreationTime] = s.CreationTime.Format(time.RFC3339)
+	}
+	return m
+}
+
+/*
+ParseStandardMeta returns the predefined keywords of m as a
+StandardMeta, ignoring any others. Creation Time may be in RFC
+3339, RFC 1123 or EXIF format; a time without a zone is taken
+as UTC. An error is returned if it's in none of them.
+*/
+func ParseStandardMeta(m Metadata) (StandardMeta, error) {
+	s := StandardMeta{
+		Title:       m[MetaTitle],
+		Author:      m[MetaAuthor],
+		Description: m[MetaDescription],
+		Copyright:   m[MetaCopyright],
+		Software:    m[MetaSoftware],
+		Disclaimer:  m[MetaDisclaimer],
+		Warning:     m[MetaWarning],
+		Source:      m[MetaSource],
+		Comment:     m[MetaComment],
+	}
+	ct, ok := m[MetaCreationTime]
+	if !ok || ct == "" {
+		return s, nil
+	}
+	for _, layout := range creationTimeLayouts {
+		if t, err := time.Parse(layout, ct); err == nil {
+			s.CreationTime = t
+			return s, nil
+		}
+	}
+	return s, fmt.Errorf("pngutil: unrecognised %s %q", MetaCreationTime, ct)
+}
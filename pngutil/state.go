@@ -0,0 +1,92 @@
+package pngutil
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNewerState is returned when saved state is newer than the application reading it.
+var ErrNewerState = errors.New("pngutil: state was saved by a newer version")
+
+/*
+State is an application's JSON document embedded in a PNG by
+SaveState, tagged with the schema version it was written with.
+*/
+type State struct {
+	Version uint32
+	Doc     json.RawMessage
+}
+
+/*
+SaveState returns rs with v encoded as JSON and stored as version
+of the caller's schema in a private chunk of type fourCC, as
+StoreBlob does. The document is prefixed with its version and
+length so truncation is detected on load.
+*/
+func SaveState(rs io.ReadSeeker, fourCC string, version uint32, v any) (*multiReadSeeker, error) {
+	return SaveStateWithOptions(rs, fourCC, version, v, Options{})
+}
+
+/*
+SaveStateWithOptions is like SaveState but accepts Options. It
+consults Compress as StoreBlobWithOptions does.
+*/
+func SaveStateWithOptions(rs io.ReadSeeker, fourCC string, version uint32, v any, opts Options) (*multiReadSeeker, error) {
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("pngutil: %w", err)
+	}
+	if len(doc) > MaxBlobSize-8 {
+		return nil, fmt.Errorf("%w: state of %d bytes is over maximum of %d", ErrLimitExceeded, len(doc), MaxBlobSize-8)
+	}
+	data := make([]byte, 8, 8+len(doc))
+	binary.BigEndian.PutUint32(data[0:4], version)
+	binary.BigEndian.PutUint32(data[4:8], uint32(len(doc)))
+	return StoreBlobWithOptions(rs, fourCC, append(data, doc...), opts)
+}
+
+/*
+LoadState returns the state saved in rs by SaveState. It returns
+ErrNoChunk if rs has no chunk of type fourCC.
+
+The document isn't decoded so that callers can inspect Version
+first and handle documents written by older versions of their
+application however they see fit.
+*/
+func LoadState(rs io.ReadSeeker, fourCC string) (*State, error) {
+	data, err := LoadBlob(rs, fourCC)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, fmt.Errorf("pngutil: %s state is truncated", fourCC)
+	}
+	n := binary.BigEndian.Uint32(data[4:8])
+	if uint64(n) != uint64(len(data)-8) {
+		return nil, fmt.Errorf("pngutil: %s state has length %d, want %d", fourCC, len(data)-8, n)
+	}
+	return &State{
+		Version: binary.BigEndian.Uint32(data[0:4]),
+		Doc:     data[8:],
+	}, nil
+}
+
+/*
+Decode unmarshals the document into v, which is assumed to be of
+the schema version current. It returns ErrNewerState if the
+document was saved with a later version, since fields it doesn't
+know about would be silently lost. Documents from earlier versions
+are decoded as is, leaving fields they lack at their zero values.
+*/
+func (s *State) Decode(current uint32, v any) error {
+	if s.Version > current {
+		return fmt.Errorf("%w: have version %d, support up to %d", ErrNewerState, s.Version, current)
+	}
+	if err := json.Unmarshal(s.Doc, v); err != nil {
+		return fmt.Errorf("pngutil: %w", err)
+	}
+	return nil
+}
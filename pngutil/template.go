@@ -0,0 +1,75 @@
+package pngutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/*
+TemplateData is the data available to templates in metadata
+values, for example:
+
+	© {{.Time.Year}} Studio — rendered {{.Now}}
+	{{.Filename}} ({{.Width}}x{{.Height}})
+	Client: {{.Fields.client}}
+*/
+type TemplateData struct {
+	Filename string            // name of the file being written, if known
+	Now      string            // Time formatted per RFC 3339
+	Time     time.Time         // time of expansion
+	Width    uint32            // width of the image in pixels
+	Height   uint32            // height of the image in pixels
+	Fields   map[string]string // arbitrary caller-supplied values
+}
+
+/*
+ExpandMeta returns a copy of meta in which each value has been
+executed as a text/template with data. Values not containing
+"{{" are copied unchanged. If data.Time is zero the current
+time is used, and Now is derived from it if empty.
+*/
+func ExpandMeta(meta Metadata, data TemplateData) (Metadata, error) {
+
+	if data.Time.IsZero() {
+		data.Time = time.Now()
+	}
+	if data.Now == "" {
+		data.Now = data.Time.Format(time.RFC3339)
+	}
+
+	out := make(Metadata, len(meta))
+	var sb strings.Builder
+	for k, v := range meta {
+		if !strings.Contains(v, "{{") {
+			out[k] = v
+			continue
+		}
+		tmpl, err := template.New(k).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("pngutil: metadata %q: %w", k, err)
+		}
+		sb.Reset()
+		if err = tmpl.Execute(&sb, data); err != nil {
+			return nil, fmt.Errorf("pngutil: metadata %q: %w", k, err)
+		}
+		out[k] = sb.String()
+	}
+	return out, nil
+}
+
+// expandMeta expands meta with data, taking missing dimensions from ihdr.
+func expandMeta(f io.ReadSeeker, ihdr chunkHeader, meta Metadata, data TemplateData) (Metadata, error) {
+	if data.Width == 0 || data.Height == 0 {
+		p, err := readChunkData(f, ihdr)
+		if err != nil {
+			return nil, err
+		}
+		data.Width = binary.BigEndian.Uint32(p[0:4])
+		data.Height = binary.BigEndian.Uint32(p[4:8])
+	}
+	return ExpandMeta(meta, data)
+}
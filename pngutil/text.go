@@ -0,0 +1,796 @@
+package pngutil
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Entry is a single text chunk. Unlike Metadata it can represent
+several chunks sharing a keyword, such as translations of the
+same Description distinguished by Language.
+*/
+type Entry struct {
+	Keyword  string
+	Text     string
+	Language string // RFC 3066 language tag, e.g. "en" or "de-CH"; empty if unknown
+
+	// TranslatedKeyword is Keyword translated into Language, in UTF-8.
+	TranslatedKeyword string
+
+	// Compressed has Text zlib-compressed within the iTXt chunk.
+	Compressed bool
+}
+
+/*
+decodeText parses the data of a tEXt, zTXt or iTXt chunk and
+returns its keyword, text as UTF-8, and language tag and
+translated keyword if it has them. Compressed text is inflated
+to at most max bytes, or any number if max is negative.
+*/
+func decodeText(typ string, data []byte, max int64) (e Entry, err error) {
+
+	kw, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return e, fmt.Errorf("pngutil: %s chunk has no keyword separator", typ)
+	}
+	e.Keyword = latin1ToUTF8(kw)
+
+	switch typ {
+	case "tEXt":
+		e.Text = latin1ToUTF8(rest)
+		return e, nil
+
+	case "zTXt":
+		if len(rest) < 1 || rest[0] != 0 {
+			return e, fmt.Errorf("pngutil: zTXt chunk %q has unknown compression method", e.Keyword)
+		}
+		p, err := inflate(rest[1:], max)
+		if errors.Is(err, ErrLimitExceeded) {
+			return e, fmt.Errorf("%w: zTXt chunk %q inflates to more than %d bytes", ErrLimitExceeded, e.Keyword, max)
+		}
+		if err != nil {
+			return e, fmt.Errorf("pngutil: zTXt chunk %q: %w", e.Keyword, err)
+		}
+		e.Text = latin1ToUTF8(p)
+		return e, nil
+
+	case "iTXt":
+		if len(rest) < 2 {
+			return e, fmt.Errorf("pngutil: iTXt chunk %q is truncated", e.Keyword)
+		}
+		compressed, method := rest[0], rest[1]
+		lang, rest, ok := bytes.Cut(rest[2:], []byte{0})
+		if !ok {
+			return e, fmt.Errorf("pngutil: iTXt chunk %q is truncated", e.Keyword)
+		}
+		e.Language = string(lang)
+		tkw, rest, ok := bytes.Cut(rest, []byte{0})
+		if !ok {
+			return e, fmt.Errorf("pngutil: iTXt chunk %q is truncated", e.Keyword)
+		}
+		if !utf8.Valid(tkw) {
+			return e, fmt.Errorf("pngutil: iTXt chunk %q has a translated keyword that isn't valid UTF-8", e.Keyword)
+		}
+		e.TranslatedKeyword = string(tkw)
+		if compressed == 1 {
+			e.Compressed = true
+			if method != 0 {
+				return e, fmt.Errorf("pngutil: iTXt chunk %q has unknown compression method", e.Keyword)
+			}
+			rest, err = inflate(rest, max)
+			if errors.Is(err, ErrLimitExceeded) {
+				return e, fmt.Errorf("%w: iTXt chunk %q inflates to more than %d bytes", ErrLimitExceeded, e.Keyword, max)
+			}
+			if err != nil {
+				return e, fmt.Errorf("pngutil: iTXt chunk %q: %w", e.Keyword, err)
+			}
+		}
+		if !utf8.Valid(rest) {
+			return e, fmt.Errorf("pngutil: iTXt chunk %q isn't valid UTF-8", e.Keyword)
+		}
+		e.Text = string(rest)
+		return e, nil
+	}
+
+	return e, fmt.Errorf("pngutil: %s isn't a text chunk", typ)
+}
+
+/*
+ContinuedLanguage is the language tag of the iTXt chunks holding
+the second and later parts of text split across chunks by
+Options.SplitText or StreamText. The first part has no language
+tag and each later part directly follows the one before it with
+the same keyword. ReadMeta, ReadMetaValues and ReadLocalized
+rejoin the parts while ReadEntries returns them as they are.
+*/
+const ContinuedLanguage = "x-cont"
+
+// maxTextPart is the most text an uncompressed iTXt continuation chunk can hold.
+const maxTextPart = maxChunkLength - maxKeywordLength - len(ContinuedLanguage) - 5
+
+/*
+encodeText returns metadata and the Entries of opts encoded as
+text chunks, using zTXt or compressed iTXt for the keywords opts
+requests and splitting values too long for one chunk.
+*/
+func encodeText(metadata Metadata, opts Options) ([]byte, error) {
+
+	split := opts.SplitText
+	switch {
+	case split <= 0 || split > maxTextPart:
+		split = maxTextPart
+	case split < utf8.UTFMax:
+		split = utf8.UTFMax
+	}
+	long := false
+	for _, v := range metadata {
+		long = long || len(v) > split
+	}
+
+	var ztxt Metadata
+	var compressed, parts []Entry
+	if len(opts.ZTXt) > 0 || len(opts.CompressText) > 0 || long {
+		ztxt = make(Metadata, len(opts.ZTXt))
+		plain := make(Metadata, len(metadata))
+		for k, v := range metadata {
+			plain[k] = v
+		}
+		for _, k := range opts.ZTXt {
+			if v, ok := plain[k]; ok {
+				ztxt[k] = v
+				delete(plain, k)
+			}
+		}
+		for _, k := range opts.CompressText {
+			if v, ok := plain[k]; ok {
+				compressed = append(compressed, Entry{Keyword: k, Text: v, Compressed: true})
+				delete(plain, k)
+			}
+		}
+		for _, k := range sortedKeys(plain) {
+			if v := plain[k]; len(v) > split {
+				for i, p := range splitUTF8(v, split) {
+					parts = append(parts, continuedEntry(k, p, i > 0))
+				}
+				delete(plain, k)
+			}
+		}
+		metadata = plain
+	}
+
+	bb, err := encodeMeta(metadata)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range sortedKeys(ztxt) {
+		if bb, err = encodeZTXt(bb, k, ztxt[k]); err != nil {
+			return nil, err
+		}
+	}
+	if bb, err = encodeEntries(bb, parts); err != nil {
+		return nil, err
+	}
+	if bb, err = encodeEntries(bb, compressed); err != nil {
+		return nil, err
+	}
+	return encodeEntries(bb, opts.Entries)
+}
+
+/*
+splitUTF8 splits s into parts of at most n bytes without
+dividing any UTF-8 encoded character. n must be at least 4.
+*/
+func splitUTF8(s string, n int) []string {
+	parts := make([]string, 0, len(s)/n+1)
+	for len(s) > n {
+		i := n
+		for i > 0 && !utf8.RuneStart(s[i]) {
+			i--
+		}
+		if i == 0 {
+			i = n // not UTF-8, so split anywhere
+		}
+		parts = append(parts, s[:i])
+		s = s[i:]
+	}
+	return append(parts, s)
+}
+
+/*
+continuedEntry returns the Entry holding part of the text of
+keyword, marking it with ContinuedLanguage if it isn't the first.
+*/
+func continuedEntry(keyword, part string, continued bool) Entry {
+	e := Entry{Keyword: keyword, Text: part}
+	if continued {
+		e.Language = ContinuedLanguage
+	}
+	return e
+}
+
+/*
+joinContinued returns entries with each continuation part
+appended to the part before it, as written by Options.SplitText
+and StreamText. A continuation whose keyword doesn't match the
+entry before it is left alone.
+*/
+func joinContinued(entries []Entry) []Entry {
+	out := entries[:0:0]
+	for _, e := range entries {
+		if n := len(out); n > 0 && e.Language == ContinuedLanguage && e.Keyword == out[n-1].Keyword {
+			out[n-1].Text += e.Text
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// Part sizes StreamText uses if none is given and at most.
+const (
+	defaultStreamPart = 1 << 20
+	maxStreamPart     = 16 << 20
+)
+
+/*
+StreamText writes the text read from r to w as consecutive
+uncompressed iTXt chunks with the given keyword, each holding at
+most partSize bytes of text, following the convention used by
+Options.SplitText. Only one part is held in memory at a time so
+values of any size can be written. It returns the number of
+bytes written to w.
+
+A partSize of zero or less means 1 MiB and one larger than
+16 MiB is reduced to it. The text must be UTF-8.
+*/
+func StreamText(w io.Writer, keyword string, r io.Reader, partSize int) (n int64, err error) {
+
+	if _, err = encodeKeyword(keyword); err != nil {
+		return 0, err
+	}
+	switch {
+	case partSize <= 0:
+		partSize = defaultStreamPart
+	case partSize > maxStreamPart:
+		partSize = maxStreamPart
+	case partSize < utf8.UTFMax:
+		partSize = utf8.UTFMax
+	}
+
+	br := bufio.NewReaderSize(r, partSize)
+	for first := true; ; first = false {
+		p, err := br.Peek(partSize)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return n, fmt.Errorf("pngutil: %w", err)
+		}
+		if len(p) == 0 && !first {
+			return n, nil
+		}
+
+		// Don't divide a character between parts.
+		end := len(p)
+		if len(p) == partSize {
+			i := len(p) - 1
+			for i > 0 && !utf8.RuneStart(p[i]) {
+				i--
+			}
+			if !utf8.FullRune(p[i:]) {
+				end = i
+			}
+		}
+		if !utf8.Valid(p[:end]) {
+			return n, fmt.Errorf("pngutil: text of keyword %q isn't valid UTF-8", keyword)
+		}
+
+		chunk, err := encodeEntries(nil, []Entry{continuedEntry(keyword, string(p[:end]), !first)})
+		if err != nil {
+			return n, err
+		}
+		c, err := w.Write(chunk)
+		n += int64(c)
+		if err != nil {
+			return n, fmt.Errorf("pngutil: %w", err)
+		}
+		if len(p) < partSize {
+			return n, nil
+		}
+		br.Discard(end)
+	}
+}
+
+/*
+encodeZTXt appends a zTXt chunk holding keyword and text to dst.
+Since zTXt holds Latin-1 text an error is returned if text has
+characters outside it.
+*/
+func encodeZTXt(dst []byte, keyword, text string) ([]byte, error) {
+	latin1, ok := utf8ToLatin1(text)
+	if !ok {
+		return nil, fmt.Errorf("pngutil: text of keyword %q can't be stored in zTXt as it isn't Latin-1", keyword)
+	}
+	kw, err := encodeKeyword(keyword)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(kw)
+	buf.Write([]byte{0, 0}) // null separator and compression method
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(latin1); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() > maxChunkLength {
+		return nil, fmt.Errorf("%w: text of keyword %q is too large for one chunk", ErrLimitExceeded, keyword)
+	}
+	return AppendChunk(dst, "zTXt", buf.Bytes()), nil
+}
+
+/*
+encodeEntries appends entries to dst as iTXt chunks carrying
+their language tags, compressing the text of those which ask.
+*/
+func encodeEntries(dst []byte, entries []Entry) ([]byte, error) {
+	for _, e := range entries {
+		if !validLanguage(e.Language) {
+			return nil, fmt.Errorf("pngutil: invalid language tag %q for keyword %q", e.Language, e.Keyword)
+		}
+		if strings.IndexByte(e.TranslatedKeyword, 0) >= 0 || !utf8.ValidString(e.TranslatedKeyword) {
+			return nil, fmt.Errorf("pngutil: invalid translated keyword %q for keyword %q", e.TranslatedKeyword, e.Keyword)
+		}
+		kw, err := encodeKeyword(e.Keyword)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, 0, len(kw)+len(e.Language)+len(e.TranslatedKeyword)+len(e.Text)+5)
+		data = append(data, kw...)
+		data = append(data, 0, 0, 0) // null separator, compression flag and method
+		data = append(data, e.Language...)
+		data = append(data, 0)
+		data = append(data, e.TranslatedKeyword...)
+		data = append(data, 0)
+		if e.Compressed {
+			data[len(kw)+1] = 1
+			var buf bytes.Buffer
+			zw := zlib.NewWriter(&buf)
+			if _, err := zw.Write([]byte(e.Text)); err != nil {
+				return nil, err
+			}
+			if err := zw.Close(); err != nil {
+				return nil, err
+			}
+			data = append(data, buf.Bytes()...)
+		} else {
+			data = append(data, e.Text...)
+		}
+		if len(data) > maxChunkLength {
+			return nil, fmt.Errorf("%w: text of keyword %q is too large for one chunk", ErrLimitExceeded, e.Keyword)
+		}
+		dst = AppendChunk(dst, "iTXt", data)
+	}
+	return dst, nil
+}
+
+// validLanguage reports whether tag is empty or hyphen-separated alphanumeric words.
+func validLanguage(tag string) bool {
+	if tag == "" {
+		return true
+	}
+	for _, word := range strings.Split(tag, "-") {
+		if len(word) == 0 || len(word) > 8 {
+			return false
+		}
+		for i := 0; i < len(word); i++ {
+			c := word[i]
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/*
+ReadMeta returns the textual metadata of rs, decoding its tEXt,
+zTXt and iTXt chunks into a Metadata keyed by keyword. If a
+keyword appears more than once the last value wins; see
+ReadMetaValues to read every value of a keyword, ReadLocalized
+to read them by language, and Options.Duplicates for other ways
+of collapsing them.
+
+Text split across consecutive iTXt chunks by Options.SplitText
+or StreamText is rejoined before duplicates are considered.
+
+Like ReplaceMeta, ReadMeta calls Assert first. Use
+DecodeRegistered to read the chunks other than text that have
+a registered handler.
+*/
+func ReadMeta(rs io.ReadSeeker) (Metadata, error) {
+	return ReadMetaWithOptions(rs, Options{})
+}
+
+/*
+ReadMetaWithOptions is like ReadMeta but accepts Options. It
+consults Limits, Context, and Duplicates to decide how repeated
+keywords are collapsed.
+*/
+func ReadMetaWithOptions(rs io.ReadSeeker, opts Options) (Metadata, error) {
+	idx, err := indexPNG(rs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return readMeta(rs, idx, opts)
+}
+
+/*
+ReadMetaValues returns every value of every keyword in rs, in
+the order they appear. The spec allows a keyword to be repeated,
+which Metadata can't represent.
+*/
+func ReadMetaValues(rs io.ReadSeeker) (map[string][]string, error) {
+	entries, err := ReadEntries(rs)
+	if err != nil {
+		return nil, err
+	}
+	vals := make(map[string][]string)
+	for _, e := range joinContinued(entries) {
+		vals[e.Keyword] = append(vals[e.Keyword], e.Text)
+	}
+	return vals, nil
+}
+
+/*
+DuplicatePolicy decides the value a keyword takes in Metadata
+when rs has more than one text chunk with that keyword.
+*/
+type DuplicatePolicy int
+
+const (
+	DuplicateLast  DuplicatePolicy = iota // the last value wins
+	DuplicateFirst                        // the first value wins
+	DuplicateJoin                         // values are joined by newlines
+	DuplicateError                        // a *KeywordError is returned
+
+	// DuplicateConcat concatenates values with no separator.
+	DuplicateConcat
+)
+
+/*
+readMeta returns the textual metadata of rs, rejoining split
+text and then collapsing repeated keywords as opts.Duplicates
+directs.
+*/
+func readMeta(rs io.ReadSeeker, idx []chunkHeader, opts Options) (Metadata, error) {
+	entries, err := readEntries(rs, idx, opts.limits())
+	if err != nil {
+		return nil, err
+	}
+	meta := make(Metadata)
+	for _, e := range joinContinued(entries) {
+		prev, seen := meta[e.Keyword]
+		switch {
+		case !seen || opts.Duplicates == DuplicateLast:
+			meta[e.Keyword] = e.Text
+		case opts.Duplicates == DuplicateJoin:
+			meta[e.Keyword] = prev + "\n" + e.Text
+		case opts.Duplicates == DuplicateConcat:
+			meta[e.Keyword] = prev + e.Text
+		case opts.Duplicates == DuplicateError:
+			return nil, &KeywordError{e.Keyword, "appears more than once"}
+		}
+	}
+	return meta, nil
+}
+
+/*
+ReadEntries returns every text chunk of rs in the order they
+appear, including the language tag and translated keyword of
+iTXt chunks, which ReadMeta discards. Parts of split text are
+returned separately; see ContinuedLanguage.
+*/
+func ReadEntries(rs io.ReadSeeker) ([]Entry, error) {
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return nil, err
+	}
+	return readEntries(rs, idx, Options{}.limits())
+}
+
+// readEntries decodes the text chunks of rs, which idx locates.
+func readEntries(rs io.ReadSeeker, idx []chunkHeader, lim Limits) ([]Entry, error) {
+	var entries []Entry
+	var total int64
+	for _, h := range idx {
+		if !textChunks[h.typ] {
+			continue
+		}
+		total += int64(h.length)
+		if err := lim.checkText(total); err != nil {
+			return nil, err
+		}
+		data, err := readChunkData(rs, h)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeText(h.typ, data, lim.textBudget(total))
+		if err != nil {
+			return nil, err
+		}
+
+		// Inflated text counts as well as the compressed data.
+		if h.typ == "zTXt" || e.Compressed {
+			total += int64(len(e.Text))
+			if err := lim.checkText(total); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+/*
+ReadLocalized returns the text chunks of rs grouped by keyword
+and then by language tag, so translations written with
+Options.Entries can be read back together. Chunks without a
+language tag, including all tEXt and zTXt chunks, are grouped
+under the empty string. Language tags are compared as written,
+so "en" and "EN" are distinct.
+
+If a keyword and language pair appears more than once the last
+value wins.
+*/
+func ReadLocalized(rs io.ReadSeeker) (map[string]map[string]string, error) {
+	entries, err := ReadEntries(rs)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]map[string]string)
+	for _, e := range joinContinued(entries) {
+		if out[e.Keyword] == nil {
+			out[e.Keyword] = make(map[string]string)
+		}
+		out[e.Keyword][e.Language] = e.Text
+	}
+	return out, nil
+}
+
+/*
+inflate decompresses the zlib stream p, returning ErrLimitExceeded
+if it holds more than max bytes. A negative max means no limit.
+*/
+func inflate(p []byte, max int64) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	r := io.Reader(zr)
+	if max >= 0 {
+		r = io.LimitReader(zr, max+1)
+	}
+	out, err := io.ReadAll(r)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, errors.New("compressed text is truncated")
+	}
+	if err == nil && max >= 0 && int64(len(out)) > max {
+		return nil, ErrLimitExceeded
+	}
+	return out, err
+}
+
+func latin1ToUTF8(p []byte) string {
+	rr := make([]rune, len(p))
+	for i, b := range p {
+		rr[i] = rune(b)
+	}
+	return string(rr)
+}
+
+// utf8ToLatin1 returns s encoded as Latin-1 and whether it could be.
+func utf8ToLatin1(s string) ([]byte, bool) {
+	p := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xFF {
+			return nil, false
+		}
+		p = append(p, byte(r))
+	}
+	return p, true
+}
+
+// textKeyword returns the keyword of a text chunk without decoding its text.
+func textKeyword(data []byte) (string, error) {
+	kw, _, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return "", errors.New("pngutil: text chunk has no keyword separator")
+	}
+	return latin1ToUTF8(kw), nil
+}
+
+/*
+AppendMeta returns f with meta merged into its existing textual
+metadata. Text chunks whose keywords appear in meta are replaced
+and the new values written as iTXt chunks after IHDR; all other
+chunks, including text chunks with other keywords, are kept byte
+for byte and in place. Compare ReplaceMeta, which discards all
+existing text.
+
+As with ReplaceMeta, the result reads from f so f shouldn't be
+altered until it has been drained.
+*/
+func AppendMeta(f io.ReadSeeker, meta Metadata) (*multiReadSeeker, error) {
+	return setText(f, meta)
+}
+
+/*
+DeleteMeta returns f without the text chunks whose keywords are
+among keys. Every other chunk is kept byte for byte and in place,
+so deleting keys f doesn't have leaves it unchanged.
+*/
+func DeleteMeta(f io.ReadSeeker, keys ...string) (*multiReadSeeker, error) {
+
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, err
+	}
+	del := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		del[k] = true
+	}
+
+	a := newAssembler(f, len(idx))
+	a.copyRange(0, idx[0].offset)
+	for _, h := range idx {
+		if textChunks[h.typ] {
+			data, err := readChunkData(f, h)
+			if err != nil {
+				return nil, err
+			}
+			k, err := textKeyword(data)
+			if err != nil {
+				return nil, err
+			}
+			if del[k] {
+				continue
+			}
+		}
+		a.copyChunk(h)
+	}
+	return a.finish()
+}
+
+/*
+UpdateMeta returns f with the text of existing text chunks whose
+keywords appear in meta replaced, leaving every chunk where it was
+and every other chunk byte for byte. Each chunk keeps its type,
+and an iTXt chunk its language tag, translated keyword and
+compression, except that tEXt and zTXt chunks are rewritten as
+iTXt if the new text isn't Latin-1. Every chunk with a matching
+keyword is updated, except that text split across chunks, as by
+Options.SplitText, is replaced by a single chunk holding the new
+value where its first part was.
+
+Keywords in meta which f doesn't have are ignored; use AppendMeta
+to add them.
+*/
+func UpdateMeta(f io.ReadSeeker, meta Metadata) (*multiReadSeeker, error) {
+
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	a := newAssembler(f, len(idx)+2)
+	a.copyRange(0, idx[0].offset)
+	prev := "" // keyword of the last text chunk
+	for _, h := range idx {
+		if !textChunks[h.typ] {
+			a.copyChunk(h)
+			continue
+		}
+		data, err := readChunkData(f, h)
+		if err != nil {
+			return nil, err
+		}
+		k, err := textKeyword(data)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := meta[k]
+		if !ok {
+			prev = k
+			a.copyChunk(h)
+			continue
+		}
+		if h.typ == "iTXt" && k == prev {
+			e, err := decodeText(h.typ, data, Options{}.limits().textBudget(0))
+			if err != nil {
+				return nil, err
+			}
+			if e.Language == ContinuedLanguage {
+				continue // the first part holds the whole new value
+			}
+		}
+		prev = k
+		chunk, err := updateText(h.typ, data, v)
+		if err != nil {
+			return nil, err
+		}
+		a.write(h.typ, chunk)
+	}
+	return a.finish()
+}
+
+// updateText returns a text chunk like the one of type typ holding data but with text.
+func updateText(typ string, data []byte, text string) ([]byte, error) {
+	e, err := decodeText(typ, data, Options{}.limits().textBudget(0))
+	if err != nil {
+		return nil, err
+	}
+	latin1, ok := utf8ToLatin1(text)
+	switch {
+	case typ == "tEXt" && ok:
+		kw, err := encodeKeyword(e.Keyword)
+		if err != nil {
+			return nil, err
+		}
+		if len(kw)+1+len(latin1) > maxChunkLength {
+			return nil, fmt.Errorf("%w: text of keyword %q is too large for one chunk", ErrLimitExceeded, e.Keyword)
+		}
+		return AppendChunk(nil, "tEXt", append(append(kw, 0), latin1...)), nil
+	case typ == "zTXt" && ok:
+		return encodeZTXt(nil, e.Keyword, text)
+	case typ == "zTXt":
+		e.Compressed = true
+	}
+	e.Text = text
+	return encodeEntries(nil, []Entry{e})
+}
+
+/*
+setText returns f with meta written as iTXt chunks after IHDR
+and any existing text chunks whose keywords appear in meta
+removed. All other chunks are kept byte for byte.
+*/
+func setText(f io.ReadSeeker, meta Metadata) (*multiReadSeeker, error) {
+
+	idx, err := indexPNG(f, Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	a := newAssembler(f, len(idx)+2)
+	a.copyRange(0, idx[0].end())
+	bb, err := encodeMeta(meta)
+	if err != nil {
+		return nil, err
+	}
+	a.write("metadata", bb)
+	for _, h := range idx[1:] {
+		if textChunks[h.typ] {
+			data, err := readChunkData(f, h)
+			if err != nil {
+				return nil, err
+			}
+			k, err := textKeyword(data)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := meta[k]; ok {
+				continue
+			}
+		}
+		a.copyChunk(h)
+	}
+	return a.finish()
+}
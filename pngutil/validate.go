@@ -0,0 +1,340 @@
+package pngutil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Severity grades a Finding.
+type Severity int
+
+const (
+	/*
+		SeverityWarning marks a problem decoders commonly
+		tolerate, such as a misplaced or corrupt ancillary chunk,
+		which they ignore, or data after IEND.
+	*/
+	SeverityWarning Severity = iota
+
+	// SeverityError marks a problem which typically stops a decoder.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+/*
+Finding is a single problem found by ValidateReport. Err is the
+error Validate reports for it, which may be a *ChunkError or
+*CRCError.
+*/
+type Finding struct {
+	Severity Severity
+	Type     string // chunk type, or empty if not particular to a chunk
+	Offset   int64  // offset of the chunk's length field, or -1
+	Message  string
+	Err      error
+}
+
+/*
+ValidationReport lists every problem ValidateReport found in a
+PNG in the order they occur in the stream.
+*/
+type ValidationReport struct {
+	Findings []Finding
+}
+
+// OK reports whether no finding has SeverityError.
+func (r *ValidationReport) OK() bool {
+	for _, f := range r.Findings {
+		if f.Severity == SeverityError {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Err returns the errors of all findings, of any severity, joined
+by errors.Join, or nil if there are none.
+*/
+func (r *ValidationReport) Err() error {
+	errs := make([]error, len(r.Findings))
+	for i, f := range r.Findings {
+		errs[i] = f.Err
+	}
+	return errors.Join(errs...)
+}
+
+/*
+add records err with severity sev, attributing it to the chunk
+located by h if it's non-nil.
+*/
+func (r *ValidationReport) add(sev Severity, h *chunkHeader, err error) {
+	f := Finding{Severity: sev, Offset: -1, Message: err.Error(), Err: err}
+	if h != nil {
+		f.Type, f.Offset = h.typ, h.offset
+	}
+	r.Findings = append(r.Findings, f)
+}
+
+/*
+Validate walks every chunk of rs from the signature to the end
+of the stream, checking that IHDR comes first and is legal, that
+the IDAT chunks are consecutive, that IEND comes last with
+nothing following it, that no chunk runs past the end of the
+stream, that chunk types are well formed, that every CRC is
+correct, that PLTE and tRNS suit the colour type and bit depth,
+and the ordering rules ValidateOrder checks. Unlike Assert,
+which only inspects the head and tail of the stream, Validate
+reads all of it.
+
+Each problem is reported as a *ChunkError, a *CRCError or, for
+problems with the contents of IHDR, PLTE and tRNS or not
+particular to a chunk, a plain error, returned in the order
+they're found joined by errors.Join. The walk stops at the first
+chunk whose framing is broken since nothing after it can be
+located. Use ValidateReport to tell serious problems from those
+decoders tolerate. The offset of rs is left unspecified.
+*/
+func Validate(rs io.ReadSeeker) error {
+	r, err := ValidateReport(rs)
+	if err != nil {
+		return err
+	}
+	return r.Err()
+}
+
+/*
+ValidateReport makes the checks of Validate, returning every
+problem found as a Finding graded by severity so that tools can
+show everything wrong with a file at once. err is non-nil only
+if rs couldn't be read.
+*/
+func ValidateReport(rs io.ReadSeeker) (r *ValidationReport, err error) {
+
+	r = &ValidationReport{}
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	p := make([]byte, 8)
+	if _, err = io.ReadFull(rs, p); err != nil || !bytes.Equal(p, header) {
+		r.add(SeverityError, nil, errors.New("pngutil: missing PNG signature"))
+		return r, nil
+	}
+
+	// Chunks that decoders can't skip make their problems errors.
+	severity := func(typ string) Severity {
+		if ChunkType(typ).Ancillary() {
+			return SeverityWarning
+		}
+		return SeverityError
+	}
+
+	var s orderState
+	var info *ImageInfo
+	var plte, trns *chunkHeader
+	ended := false
+	pos := int64(len(header))
+	for !ended && pos < size {
+
+		if _, err = rs.Seek(pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err = io.ReadFull(rs, p); err != nil {
+			r.add(SeverityError, nil, fmt.Errorf("pngutil: truncated chunk header at offset %d", pos))
+			break
+		}
+		h := chunkHeader{
+			offset: pos,
+			length: binary.BigEndian.Uint32(p[0:4]),
+			typ:    string(p[4:8]),
+		}
+		if h.length > maxChunkLength {
+			r.add(SeverityError, &h, &ChunkError{Type: h.typ, Offset: h.offset, Err: fmt.Errorf("invalid length %d", h.length)})
+			break
+		}
+		if h.end() > size {
+			r.add(SeverityError, &h, &ChunkError{Type: h.typ, Offset: h.offset, Err: fmt.Errorf("length %d runs past end of stream at %d", h.length, size)})
+			break
+		}
+		if h.offset == int64(len(header)) && h.typ == "CgBI" {
+			r.add(SeverityError, &h, ErrCgBI)
+			return r, nil
+		}
+		pos = h.end()
+		ended = h.typ == "IEND"
+
+		if !validChunkType(h.typ) {
+			r.add(SeverityError, &h, &ChunkError{Type: h.typ, Offset: h.offset, Err: errors.New("invalid chunk type")})
+			continue
+		}
+		if err := s.next(h.typ); err != nil {
+			r.add(severity(h.typ), &h, &ChunkError{Type: h.typ, Offset: h.offset, Err: err})
+		}
+		stored, actual, err := checkCRC(rs, h)
+		if err != nil {
+			return nil, err
+		}
+		if stored != actual {
+			r.add(severity(h.typ), &h, &CRCError{Type: h.typ, Offset: h.offset, Stored: stored, Actual: actual})
+		}
+
+		switch {
+		case h.typ == "IHDR" && h.length != 13:
+			r.add(SeverityError, &h, &ChunkError{Type: h.typ, Offset: h.offset, Err: fmt.Errorf("has length %d, want 13", h.length)})
+		case h.typ == "IHDR":
+			data, err := readChunkData(rs, h)
+			if err != nil {
+				return nil, err
+			}
+			hdr, _ := parseIHDR(data)
+			if err = hdr.Check(); err != nil {
+				r.add(SeverityError, &h, &ChunkError{Type: "IHDR", Offset: h.offset, Err: err})
+			} else {
+				info = &hdr
+			}
+		case h.typ == "PLTE" && plte == nil:
+			plte = &h
+		case h.typ == "tRNS" && trns == nil:
+			trns = &h
+		case ended && h.length != 0:
+			r.add(SeverityWarning, &h, &ChunkError{Type: h.typ, Offset: h.offset, Err: fmt.Errorf("has length %d, want 0", h.length)})
+		}
+	}
+
+	if info != nil {
+		if err = checkColor(r, rs, *info, plte, trns); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case !ended:
+		r.add(SeverityError, nil, errors.New("pngutil: missing IEND chunk"))
+	case pos < size:
+		r.add(SeverityWarning, nil, fmt.Errorf("pngutil: unexpected data after IEND chunk at offset %d", pos))
+	}
+	return r, nil
+}
+
+/*
+checkColor adds to r any problems with the PLTE and tRNS chunks
+located by plte and trns, which may be nil, given the image
+header h. Decoders ignore a tRNS chunk they can't use so its
+problems are warnings.
+*/
+func checkColor(r *ValidationReport, rs io.ReadSeeker, h ImageInfo, plte, trns *chunkHeader) error {
+	n := int64(-1)
+	if plte != nil {
+		n = int64(plte.length)
+	}
+	if err := checkPalette(h, n); err != nil {
+		r.add(SeverityError, plte, err)
+	}
+	if trns == nil {
+		return nil
+	}
+	if trns.length > 256 {
+		r.add(SeverityWarning, trns, fmt.Errorf("pngutil: tRNS chunk has length %d, want at most 256", trns.length))
+		return nil
+	}
+	data, err := readChunkData(rs, *trns)
+	if err != nil {
+		return err
+	}
+	var tr Transparency
+	if err = tr.unmarshal(data, h.ColorType); err == nil {
+		err = tr.check(h, int(n/3))
+	}
+	if err != nil {
+		r.add(SeverityWarning, trns, err)
+	}
+	return nil
+}
+
+/*
+VerifyImageData inflates the image data of rs, discarding the
+result, to check that the zlib stream spread across its IDAT
+chunks is intact and decompresses to exactly as many bytes as
+IHDR implies. This catches damage CRCs miss, such as truncated
+image data whose chunk CRCs were recomputed, at the cost of
+decompressing the whole image; the pixels themselves aren't
+checked. The offset of rs is left unspecified.
+*/
+func VerifyImageData(rs io.ReadSeeker) error {
+	idx, err := indexPNG(rs, Options{})
+	if err != nil {
+		return err
+	}
+	return verifyImageData(context.Background(), rs, idx)
+}
+
+// verifyImageData inflates the IDAT chunks of rs, which idx locates.
+func verifyImageData(ctx context.Context, rs io.ReadSeeker, idx []chunkHeader) error {
+
+	h, err := readIHDR(rs, idx)
+	if err != nil {
+		return err
+	}
+	if err = h.Check(); err != nil {
+		return err
+	}
+
+	a := newAssembler(rs, len(idx))
+	found := false
+	for _, c := range idx {
+		if c.typ == "IDAT" {
+			a.copyRange(c.dataOffset(), c.end()-4)
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: no IDAT chunk", ErrNoChunk)
+	}
+	mrs, err := a.finish()
+	if err != nil {
+		return err
+	}
+
+	zr, err := zlib.NewReader(mrs)
+	if err != nil {
+		return fmt.Errorf("pngutil: image data is corrupt: %w", err)
+	}
+	want := h.rawSize()
+	var n int64
+	for err == nil && n <= want {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		var c int64
+		c, err = io.CopyN(io.Discard, zr, 1<<20)
+		n += c
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("pngutil: image data is corrupt after %d bytes: %w", n, err)
+	}
+	if n > want {
+		return fmt.Errorf("pngutil: image data inflates to more than the %d bytes IHDR implies", want)
+	}
+	if n < want {
+		return fmt.Errorf("pngutil: image data inflates to %d bytes, want %d", n, want)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package pngutil
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const rdfNS = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+// XMPKeyword is the iTXt keyword under which Adobe tools store an XMP packet.
+const XMPKeyword = "XML:com.adobe.xmp"
+
+/*
+WriteXMP returns f with packet stored as its XMP metadata,
+replacing any already present. As Adobe's tools expect, packet
+is written after IHDR in an uncompressed iTXt chunk with no
+language tag or translated keyword. Other chunks are kept byte
+for byte, as with AppendMeta.
+*/
+func WriteXMP(f io.ReadSeeker, packet []byte) (*multiReadSeeker, error) {
+	if !utf8.Valid(packet) {
+		return nil, errors.New("pngutil: XMP packet isn't valid UTF-8")
+	}
+	return setText(f, Metadata{XMPKeyword: string(packet)})
+}
+
+/*
+ReadXMP returns the XMP packet stored in rs, or ErrNoChunk if it
+has none. If there's more than one the first is returned.
+*/
+func ReadXMP(rs io.ReadSeeker) ([]byte, error) {
+	entries, err := ReadEntries(rs)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Keyword == XMPKeyword {
+			return []byte(e.Text), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no XMP packet", ErrNoChunk)
+}
+
+// Conventional prefixes of the XMP namespaces FieldMap understands.
+var xmpPrefixes = map[string]string{
+	"http://purl.org/dc/elements/1.1/":    "dc",
+	"http://ns.adobe.com/xap/1.0/":        "xmp",
+	"http://ns.adobe.com/xap/1.0/rights/": "xmpRights",
+	"http://ns.adobe.com/photoshop/1.0/":  "photoshop",
+	"http://ns.adobe.com/exif/1.0/":       "exif",
+	"http://ns.adobe.com/tiff/1.0/":       "tiff",
+}
+
+func xmpName(n xml.Name) string {
+	if prefix, ok := xmpPrefixes[n.Space]; ok {
+		return prefix + ":" + n.Local
+	}
+	return ""
+}
+
+/*
+parseXMP returns the simple properties of an XMP packet keyed
+by their conventional prefixed name, e.g. "dc:creator". Values
+of language alternatives (rdf:Alt) are the first alternative,
+and those of ordered or unordered arrays (rdf:Seq and rdf:Bag)
+are their items joined by "; ".
+*/
+func parseXMP(packet []byte) (map[string]string, error) {
+
+	d := xml.NewDecoder(bytes.NewReader(packet))
+	fields := make(map[string]string)
+
+	var (
+		prop      string   // property being collected, if any
+		depth     int      // element depth within prop
+		container string   // rdf:Alt, rdf:Seq or rdf:Bag
+		items     []string // collected values of prop
+		text      strings.Builder
+	)
+
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pngutil: parsing XMP: %w", err)
+		}
+
+		switch t := tok.(type) {
+
+		case xml.StartElement:
+			if prop != "" {
+				depth++
+				if t.Name.Space == rdfNS && t.Name.Local != "li" {
+					container = t.Name.Local
+				}
+				text.Reset()
+				continue
+			}
+			// Properties may be written as attributes of rdf:Description.
+			for _, attr := range t.Attr {
+				if name := xmpName(attr.Name); name != "" {
+					fields[name] = attr.Value
+				}
+			}
+			if prop = xmpName(t.Name); prop != "" {
+				depth, container, items = 0, "", nil
+				text.Reset()
+			}
+
+		case xml.CharData:
+			if prop != "" {
+				text.Write(t)
+			}
+
+		case xml.EndElement:
+			if prop == "" {
+				continue
+			}
+			if v := strings.TrimSpace(text.String()); v != "" {
+				items = append(items, v)
+			}
+			text.Reset()
+			if depth > 0 {
+				depth--
+				continue
+			}
+			if len(items) > 0 {
+				if container == "Alt" {
+					fields[prop] = items[0]
+				} else {
+					fields[prop] = strings.Join(items, "; ")
+				}
+			}
+			prop = ""
+		}
+	}
+
+	return fields, nil
+}